@@ -1,9 +1,12 @@
 package cepm
 
 import (
+	"bufio"
+	metamgr "github.com/basho-labs/riak-mesos/metadata_manager"
 	"github.com/stretchr/testify/assert"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestListen(t *testing.T) {
@@ -14,3 +17,47 @@ func TestListen(t *testing.T) {
 	_, err := net.Dial("tcp", cpmd.ln.Addr().String())
 	assert.Nil(err)
 }
+
+func sendCommand(t *testing.T, c *CEPM, command string) (net.Conn, string) {
+	conn, err := net.Dial("tcp", c.ln.Addr().String())
+	if err != nil {
+		t.Fatal("Could not connect to CEPMd: ", err)
+	}
+	conn.Write([]byte(command + "\n"))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal("Could not read CEPMd reply: ", err)
+	}
+	return conn, reply
+}
+
+func TestRegisterAndPortPlease(t *testing.T) {
+	assert := assert.New(t)
+	zkConn := metamgr.NewFakeZkConnection()
+	mgr := metamgr.NewMetadataManagerWithConnector("riak", []string{}, zkConn.Connector())
+	c := NewCPMd(0, mgr)
+	c.Background()
+
+	registration, reply := sendCommand(t, c, "REGISTER riak-default-1@host1 31002")
+	assert.Equal("OK\n", reply)
+
+	conn, reply := sendCommand(t, c, "PORT_PLEASE riak-default-1@host1")
+	conn.Close()
+	assert.Equal("31002\n", reply)
+
+	conn, reply = sendCommand(t, c, "PORT_PLEASE riak-default-2@host2")
+	conn.Close()
+	assert.Equal("NOTFOUND\n", reply)
+
+	// Hanging up deregisters the node
+	registration.Close()
+	for i := 0; i < 50; i++ {
+		conn, reply = sendCommand(t, c, "PORT_PLEASE riak-default-1@host1")
+		conn.Close()
+		if reply == "NOTFOUND\n" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal("NOTFOUND\n", reply)
+}
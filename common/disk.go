@@ -0,0 +1,182 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+)
+
+// Disk sources, see Resource.DiskInfo.Source in mesos.proto. A disk resource without a source is the agent's root disk
+const (
+	DISK_SOURCE_PATH  string = "PATH"  // A directory on a separate volume, which can be split between tasks
+	DISK_SOURCE_MOUNT string = "MOUNT" // A dedicated, mounted disk, which can only be used whole
+)
+
+// The mesosproto we build against predates DiskInfo.Source, so it ends up amongst the DiskInfo's unrecognized
+// fields. It's read from and written back to there by hand.
+const (
+	diskInfoSourceField    = 3
+	diskSourceTypeField    = 1
+	diskSourcePathField    = 2
+	diskSourceMountField   = 3
+	diskSourceRootField    = 1
+	diskSourceTypePath     = 1
+	diskSourceTypeMount    = 2
+	protobufWireVarint     = 0
+	protobufWireFixed64    = 1
+	protobufWireBytes      = 2
+	protobufWireFixed32    = 5
+	protobufMaxFieldNumber = 1<<29 - 1
+)
+
+type DiskSource struct {
+	Type string
+	Root string
+}
+
+func (source *DiskSource) String() string {
+	if source == nil {
+		return "ROOT"
+	}
+	return fmt.Sprintf("%s:%s", source.Type, source.Root)
+}
+
+func (source *DiskSource) Equals(other *DiskSource) bool {
+	if source == nil || other == nil {
+		return source == nil && other == nil
+	}
+	return source.Type == other.Type && source.Root == other.Root
+}
+
+// DiskResource is the disk on offer from a single PATH or MOUNT source
+type DiskResource struct {
+	Source *DiskSource
+	Size   float64
+}
+
+// GetDiskSource returns the source of a disk resource, or nil for the root disk
+func GetDiskSource(resource *mesos.Resource) *DiskSource {
+	if resource.Disk == nil {
+		return nil
+	}
+	var source *DiskSource
+	forEachProtobufField(resource.Disk.XXX_unrecognized, func(field int, wireType int, value uint64, data []byte) {
+		if field == diskInfoSourceField && wireType == protobufWireBytes {
+			source = decodeDiskSource(data)
+		}
+	})
+	return source
+}
+
+// SetDiskSource sets the source of a disk resource, a nil source leaves it on the root disk
+func SetDiskSource(resource *mesos.Resource, source *DiskSource) {
+	if source == nil {
+		return
+	}
+	if resource.Disk == nil {
+		resource.Disk = &mesos.Resource_DiskInfo{}
+	}
+	unrecognized := []byte{}
+	forEachProtobufField(resource.Disk.XXX_unrecognized, func(field int, wireType int, value uint64, data []byte) {
+		if field != diskInfoSourceField {
+			unrecognized = appendProtobufField(unrecognized, field, wireType, value, data)
+		}
+	})
+	resource.Disk.XXX_unrecognized = appendProtobufField(unrecognized, diskInfoSourceField, protobufWireBytes, 0, encodeDiskSource(source))
+}
+
+// IsPersistentVolume is true for disk resources which hold a persistent volume, as opposed to a plain reservation
+func IsPersistentVolume(resource *mesos.Resource) bool {
+	return resource.GetName() == "disk" && resource.Disk != nil && resource.Disk.Persistence != nil
+}
+
+func decodeDiskSource(data []byte) *DiskSource {
+	source := &DiskSource{}
+	forEachProtobufField(data, func(field int, wireType int, value uint64, data []byte) {
+		switch {
+		case field == diskSourceTypeField && wireType == protobufWireVarint:
+			switch value {
+			case diskSourceTypePath:
+				source.Type = DISK_SOURCE_PATH
+			case diskSourceTypeMount:
+				source.Type = DISK_SOURCE_MOUNT
+			}
+		case (field == diskSourcePathField || field == diskSourceMountField) && wireType == protobufWireBytes:
+			forEachProtobufField(data, func(field int, wireType int, value uint64, data []byte) {
+				if field == diskSourceRootField && wireType == protobufWireBytes {
+					source.Root = string(data)
+				}
+			})
+		}
+	})
+	if source.Type == "" {
+		return nil
+	}
+	return source
+}
+
+func encodeDiskSource(source *DiskSource) []byte {
+	sourceType, rootField := uint64(diskSourceTypePath), diskSourcePathField
+	if source.Type == DISK_SOURCE_MOUNT {
+		sourceType, rootField = diskSourceTypeMount, diskSourceMountField
+	}
+	root := appendProtobufField([]byte{}, diskSourceRootField, protobufWireBytes, 0, []byte(source.Root))
+	data := appendProtobufField([]byte{}, diskSourceTypeField, protobufWireVarint, sourceType, nil)
+	return appendProtobufField(data, rootField, protobufWireBytes, 0, root)
+}
+
+// forEachProtobufField walks the fields of an encoded protobuf message, stopping at anything malformed
+func forEachProtobufField(data []byte, fun func(field int, wireType int, value uint64, data []byte)) {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 > protobufMaxFieldNumber {
+			return
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), int(key&7)
+
+		switch wireType {
+		case protobufWireVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return
+			}
+			fun(field, wireType, value, nil)
+			data = data[n:]
+		case protobufWireFixed64, protobufWireFixed32:
+			size := 8
+			if wireType == protobufWireFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return
+			}
+			fun(field, wireType, 0, data[:size])
+			data = data[size:]
+		case protobufWireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return
+			}
+			fun(field, wireType, 0, data[n:n+int(length)])
+			data = data[n+int(length):]
+		default:
+			return
+		}
+	}
+}
+
+func appendProtobufField(buf []byte, field int, wireType int, value uint64, data []byte) []byte {
+	varint := make([]byte, binary.MaxVarintLen64)
+	buf = append(buf, varint[:binary.PutUvarint(varint, uint64(field)<<3|uint64(wireType))]...)
+	switch wireType {
+	case protobufWireVarint:
+		buf = append(buf, varint[:binary.PutUvarint(varint, value)]...)
+	case protobufWireBytes:
+		buf = append(buf, varint[:binary.PutUvarint(varint, uint64(len(data)))]...)
+		buf = append(buf, data...)
+	default:
+		buf = append(buf, data...)
+	}
+	return buf
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// The logs of a Riak node which can be shipped and read through the API, in root/riak/log of the task's sandbox
+var RIAK_LOG_FILES = []string{"console.log", "error.log", "crash.log"}
+
+// Where the executor ships its Riak node's logs to
+const (
+	LOG_SHIPPING_STDOUT string = "stdout" // console.log to the task's stdout, error.log and crash.log to its stderr
+	LOG_SHIPPING_SYSLOG string = "syslog" // To the syslog at SyslogAddress
+	LOG_SHIPPING_NONE   string = "none"   // Nowhere, the logs stay in the sandbox
+)
+
+// How many lines of a log can be read through the API at once
+const (
+	DEFAULT_LOG_TAIL_LINES int = 100
+	MAX_LOG_TAIL_LINES     int = 10000
+)
+
+// LogShipping is set per cluster, nil has the executor ship to LOG_SHIPPING_STDOUT
+type LogShipping struct {
+	Destination string
+	// e.g. udp://logs.example.com:514 or tcp://logs.example.com:514
+	SyslogAddress string `json:",omitempty"`
+}
+
+func (logShipping *LogShipping) Validate() error {
+	switch logShipping.Destination {
+	case LOG_SHIPPING_STDOUT, LOG_SHIPPING_NONE:
+		if logShipping.SyslogAddress != "" {
+			return fmt.Errorf("SyslogAddress is only used with the %s destination", LOG_SHIPPING_SYSLOG)
+		}
+		return nil
+	case LOG_SHIPPING_SYSLOG:
+		_, _, err := logShipping.SyslogNetwork()
+		return err
+	}
+	return fmt.Errorf("Unknown log shipping Destination: %q", logShipping.Destination)
+}
+
+// SyslogNetwork splits SyslogAddress into the network and address to dial
+func (logShipping *LogShipping) SyslogNetwork() (string, string, error) {
+	address, err := url.Parse(logShipping.SyslogAddress)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid SyslogAddress: %v", err)
+	}
+	if (address.Scheme != "udp" && address.Scheme != "tcp") || address.Host == "" {
+		return "", "", fmt.Errorf("SyslogAddress must look like udp://host:port or tcp://host:port, not %q", logShipping.SyslogAddress)
+	}
+	return address.Scheme, address.Host, nil
+}
+
+// IsRiakLogFile is true for the names in RIAK_LOG_FILES
+func IsRiakLogFile(name string) bool {
+	for _, file := range RIAK_LOG_FILES {
+		if name == file {
+			return true
+		}
+	}
+	return false
+}
+
+// LogTail is the result of COMMAND_TAIL_LOG, the last lines of one of RIAK_LOG_FILES
+type LogTail struct {
+	File  string
+	Lines []string
+}
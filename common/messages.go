@@ -0,0 +1,132 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version of the framework messages exchanged between the scheduler and the executors. An executor refuses
+// messages of a newer version than it knows, so the scheduler can tell it has to be relaunched.
+const FRAMEWORK_MESSAGE_VERSION int = 1
+
+// Commands the scheduler can send to a node's executor
+const (
+	COMMAND_FINISH              string = "finish"              // Stop Riak and finish the task
+	COMMAND_RESTART             string = "restart"             // Restart Riak in the same task, with the message's TaskData
+	COMMAND_RELOAD_CONFIG       string = "reload-config"       // Render riak.conf and advanced.config again, with the message's TaskData, without restarting Riak
+	COMMAND_COLLECT_DIAGNOSTICS string = "collect-diagnostics" // Report the executor's view of the node
+	COMMAND_REPORT_DISK_USAGE   string = "report-disk-usage"   // Report how much of the sandbox's disk Riak uses
+	COMMAND_RUN_RIAK_ADMIN      string = "run-riak-admin"      // Run riak-admin with the message's Args
+	COMMAND_RUN_RIAK_DEBUG      string = "run-riak-debug"      // Collect a riak-debug bundle into the task's sandbox
+	COMMAND_TAIL_LOG            string = "tail-log"            // Read the last Lines of the log named by the message's first Arg
+)
+
+// FrameworkMessage is a command from the scheduler to a node's executor. The executor answers every message which
+// has a RequestID with a FrameworkReply carrying the same RequestID.
+type FrameworkMessage struct {
+	Version   int
+	RequestID string `json:",omitempty"`
+	Command   string
+	TaskData  *TaskData `json:",omitempty"`
+	Args      []string  `json:",omitempty"`
+	// How long a command which runs a program lets it run, the executor's default if 0
+	TimeoutSeconds int64 `json:",omitempty"`
+	Lines          int   `json:",omitempty"`
+}
+
+func (s *FrameworkMessage) Serialize() (string, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+// DeserializeFrameworkMessage also understands the bare "finish" the scheduler sends, which executors of every
+// version accept
+func DeserializeFrameworkMessage(data string) (FrameworkMessage, error) {
+	t := FrameworkMessage{}
+	if data == COMMAND_FINISH {
+		t.Command = COMMAND_FINISH
+		return t, nil
+	}
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return t, err
+	}
+	if t.Version > FRAMEWORK_MESSAGE_VERSION {
+		return t, fmt.Errorf("Unsupported framework message version %d, expected at most %d", t.Version, FRAMEWORK_MESSAGE_VERSION)
+	}
+	return t, nil
+}
+
+// FrameworkReply is an executor's answer to a FrameworkMessage. Error is set if the command failed, otherwise
+// Result holds the command's result, if it has one.
+type FrameworkReply struct {
+	Version   int
+	RequestID string
+	TaskID    string
+	Command   string
+	Error     string          `json:",omitempty"`
+	Result    json.RawMessage `json:",omitempty"`
+}
+
+// NewFrameworkReply answers the message, with the result serialized into the reply unless err is set
+func NewFrameworkReply(message FrameworkMessage, taskID string, result interface{}, err error) *FrameworkReply {
+	reply := &FrameworkReply{
+		Version:   FRAMEWORK_MESSAGE_VERSION,
+		RequestID: message.RequestID,
+		TaskID:    taskID,
+		Command:   message.Command,
+	}
+	if err == nil && result != nil {
+		reply.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+func (s *FrameworkReply) Serialize() (string, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func DeserializeFrameworkReply(data string) (FrameworkReply, error) {
+	t := FrameworkReply{}
+	err := json.Unmarshal([]byte(data), &t)
+	return t, err
+}
+
+// DiskUsage is the result of COMMAND_REPORT_DISK_USAGE
+type DiskUsage struct {
+	Path       string
+	TotalBytes uint64
+	FreeBytes  uint64
+	// Size of everything under Path
+	UsedBytes uint64
+}
+
+// Diagnostics is the result of COMMAND_COLLECT_DIAGNOSTICS
+type Diagnostics struct {
+	TaskID          string
+	NodeName        string
+	ConfigRevision  int64
+	InPlaceRestarts int64
+	Healthy         bool
+	HealthCheck     string `json:",omitempty"`
+	RiakConfig      string
+	AdvancedConfig  string
+}
+
+// CommandOutput is the result of COMMAND_RUN_RIAK_ADMIN
+type CommandOutput struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// DebugBundle is the result of COMMAND_RUN_RIAK_DEBUG, the bundle can be downloaded from the task's sandbox
+type DebugBundle struct {
+	// Absolute path of the bundle on the agent
+	Path   string
+	Size   int64
+	Output CommandOutput
+}
@@ -5,14 +5,60 @@ import (
 	log "github.com/Sirupsen/logrus"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	util "github.com/mesos/mesos-go/mesosutil"
-	"math/rand"
+	"sort"
+	"strings"
 )
 
 type ResourceGroup struct {
-	Cpus  float64
-	Mem   float64
+	Cpus float64
+	Mem  float64
+	// Disk is the root disk, PATH and MOUNT disks are in Disks
 	Disk  float64
 	Ports []int64
+	Disks []*DiskResource
+}
+
+func (group *ResourceGroup) diskSize(source *DiskSource) float64 {
+	if source == nil {
+		return group.Disk
+	}
+	for _, disk := range group.Disks {
+		if disk.Source.Equals(source) {
+			return disk.Size
+		}
+	}
+	return 0
+}
+
+func (group *ResourceGroup) addDisk(size float64, source *DiskSource) {
+	if source == nil {
+		group.Disk = group.Disk + size
+		return
+	}
+	for _, disk := range group.Disks {
+		if disk.Source.Equals(source) {
+			disk.Size = disk.Size + size
+			return
+		}
+	}
+	group.Disks = append(group.Disks, &DiskResource{Source: source, Size: size})
+}
+
+// PortRange is an inclusive range of port numbers
+type PortRange struct {
+	Begin int64
+	End   int64
+}
+
+func (portRange *PortRange) Validate() error {
+	if portRange.Begin <= 0 || portRange.End > 65535 || portRange.Begin > portRange.End {
+		return fmt.Errorf("Invalid port range %d-%d", portRange.Begin, portRange.End)
+	}
+	return nil
+}
+
+func (portRange *PortRange) Contains(port int64) bool {
+	return port >= portRange.Begin && port <= portRange.End
 }
 
 type OfferHelper struct {
@@ -31,6 +77,7 @@ type OfferHelper struct {
 func NewOfferHelper(mesosOffer *mesos.Offer) *OfferHelper {
 	unreservedCpus, unreservedMem, unreservedDisk, unreservedPorts := getUnreservedResources(mesosOffer.Resources)
 	reservedCpus, reservedMem, reservedDisk, reservedPorts, persistenceIDs := getReservedResources(mesosOffer.Resources)
+	unreservedDisks, reservedDisks := getDisks(mesosOffer.Resources, false), getDisks(mesosOffer.Resources, true)
 
 	return &OfferHelper{
 		MesosOffer:     mesosOffer,
@@ -41,12 +88,14 @@ func NewOfferHelper(mesosOffer *mesos.Offer) *OfferHelper {
 			Mem:   reservedMem,
 			Disk:  reservedDisk,
 			Ports: reservedPorts,
+			Disks: reservedDisks,
 		},
 		UnreservedResources: &ResourceGroup{
 			Cpus:  unreservedCpus,
 			Mem:   unreservedMem,
 			Disk:  unreservedDisk,
 			Ports: unreservedPorts,
+			Disks: unreservedDisks,
 		},
 		ResourcesToReserve:  []*mesos.Resource{},
 		ResourcesToUneserve: []*mesos.Resource{},
@@ -57,68 +106,84 @@ func NewOfferHelper(mesosOffer *mesos.Offer) *OfferHelper {
 }
 
 func (offerHelper *OfferHelper) String() string {
-	return fmt.Sprintf("Reserved: (cpus:%v, mem: %v, disk: %v, ports: %v, persistenceIds: %+v), "+
-		"Unreserved: (cpus:%v, mem: %v, disk: %v, ports: %+v)",
+	return fmt.Sprintf("Reserved: (cpus:%v, mem: %v, disk: %v, ports: %v, persistenceIds: %+v, disks: %v), "+
+		"Unreserved: (cpus:%v, mem: %v, disk: %v, ports: %+v, disks: %v)",
 		offerHelper.ReservedResources.Cpus, offerHelper.ReservedResources.Mem,
 		offerHelper.ReservedResources.Disk, len(offerHelper.ReservedResources.Ports), len(offerHelper.PersistenceIDs),
+		disksString(offerHelper.ReservedResources.Disks),
 		offerHelper.UnreservedResources.Cpus, offerHelper.UnreservedResources.Mem,
-		offerHelper.UnreservedResources.Disk, len(offerHelper.UnreservedResources.Ports))
+		offerHelper.UnreservedResources.Disk, len(offerHelper.UnreservedResources.Ports),
+		disksString(offerHelper.UnreservedResources.Disks))
 }
 
 func (offerHelper *OfferHelper) OfferHasReservations() bool {
 	return offerHelper.ReservedResources.Cpus > 0 ||
 		offerHelper.ReservedResources.Mem > 0 ||
 		offerHelper.ReservedResources.Disk > 0 ||
-		len(offerHelper.ReservedResources.Ports) > 0
+		len(offerHelper.ReservedResources.Ports) > 0 ||
+		len(offerHelper.ReservedResources.Disks) > 0
 }
 
 func (offerHelper *OfferHelper) OfferHasVolumes() bool {
 	return len(offerHelper.PersistenceIDs) > 0
 }
 
-func (offerHelper *OfferHelper) MaybeUnreserve() {
-	if len(offerHelper.TasksToLaunch) > 0 {
-		return
-	}
+// ReservedVolumes lists the persistent volumes on offer
+func (offerHelper *OfferHelper) ReservedVolumes() []*mesos.Resource {
+	return FilterReservedVolumes(offerHelper.MesosOffer.Resources)
+}
 
-	if offerHelper.OfferHasReservations() {
-		log.Warnf("An offer has reserved resources, but no nodes can use it. Unreserving resources for OfferID: %+v", offerHelper.OfferIDStr)
-		offerHelper.ResourcesToUneserve = append(offerHelper.ResourcesToUneserve, CopyReservedResources(offerHelper.MesosOffer.Resources)...)
-	}
+// DestroyVolume destroys one of the offered persistent volumes, and unreserves the disk underneath it
+func (offerHelper *OfferHelper) DestroyVolume(volume *mesos.Resource) {
+	log.Warnf("Destroying persistent volume %s for OfferID: %+v", volume.Disk.Persistence.GetId(), offerHelper.OfferIDStr)
+	offerHelper.VolumesToDestroy = append(offerHelper.VolumesToDestroy, CopyReservedVolumes([]*mesos.Resource{volume})...)
+	offerHelper.ResourcesToUneserve = append(offerHelper.ResourcesToUneserve, CopyReservedResources([]*mesos.Resource{volume})...)
+}
 
-	if offerHelper.OfferHasVolumes() {
-		log.Warnf("An offer has persisted volumes, but no nodes can use it. Destroying volumes for OfferID: %+v", offerHelper.OfferIDStr)
-		offerHelper.VolumesToDestroy = append(offerHelper.VolumesToDestroy, CopyReservedVolumes(offerHelper.MesosOffer.Resources)...)
+// UnreserveResources unreserves every offered reserved resource which isn't a persistent volume
+func (offerHelper *OfferHelper) UnreserveResources() {
+	resources := util.FilterResources(offerHelper.MesosOffer.Resources, func(res *mesos.Resource) bool {
+		return !IsPersistentVolume(res)
+	})
+	reserved := CopyReservedResources(resources)
+	if len(reserved) == 0 {
+		return
 	}
+	log.Warnf("An offer has reserved resources, but no nodes can use it. Unreserving resources for OfferID: %+v", offerHelper.OfferIDStr)
+	offerHelper.ResourcesToUneserve = append(offerHelper.ResourcesToUneserve, reserved...)
 }
 
-func (offerHelper *OfferHelper) MakeReservation(cpus float64, mem float64, disk float64, ports int,
-	principal string, role string) {
-	reservation := offerHelper.apply(offerHelper.UnreservedResources, cpus, mem, disk, ports, principal, role, "", "")
+func (offerHelper *OfferHelper) MakeReservation(cpus float64, mem float64, disk float64, source *DiskSource, ports []int64,
+	principal string, role string) error {
+	reservation, err := offerHelper.apply(offerHelper.UnreservedResources, cpus, mem, disk, source, ports, principal, role, "", "")
+	if err != nil {
+		return err
+	}
 	offerHelper.ResourcesToReserve = append(offerHelper.ResourcesToReserve, reservation...)
+	return nil
 }
 
-func (offerHelper *OfferHelper) MakeVolume(disk float64, principal string, role string,
+func (offerHelper *OfferHelper) MakeVolume(disk float64, source *DiskSource, principal string, role string,
 	persistenceID string, containerPath string) {
-	volume := offerHelper.apply(offerHelper.UnreservedResources, 0, 0, disk, 0, principal, role, persistenceID, containerPath)
+	volume, _ := offerHelper.apply(offerHelper.UnreservedResources, 0, 0, disk, source, nil, principal, role, persistenceID, containerPath)
 	// Add the disk back since it was likely already removed in the reservation
-	offerHelper.UnreservedResources.Disk = offerHelper.UnreservedResources.Disk + disk
+	offerHelper.UnreservedResources.addDisk(disk, source)
 	offerHelper.VolumesToCreate = append(offerHelper.VolumesToCreate, volume...)
 }
 
-func (offerHelper *OfferHelper) ApplyReserved(cpus float64, mem float64, disk float64, ports int,
-	principal string, role string, persistenceID string, containerPath string) []*mesos.Resource {
-	return offerHelper.apply(offerHelper.ReservedResources, cpus, mem, disk, ports, principal, role, persistenceID, containerPath)
+func (offerHelper *OfferHelper) ApplyReserved(cpus float64, mem float64, disk float64, source *DiskSource, ports []int64,
+	principal string, role string, persistenceID string, containerPath string) ([]*mesos.Resource, error) {
+	return offerHelper.apply(offerHelper.ReservedResources, cpus, mem, disk, source, ports, principal, role, persistenceID, containerPath)
 }
 
-func (offerHelper *OfferHelper) ApplyUnreserved(cpus float64, mem float64, disk float64, ports int) []*mesos.Resource {
-	return offerHelper.apply(offerHelper.UnreservedResources, cpus, mem, disk, ports, "", "", "", "")
+func (offerHelper *OfferHelper) ApplyUnreserved(cpus float64, mem float64, disk float64, ports []int64) ([]*mesos.Resource, error) {
+	return offerHelper.apply(offerHelper.UnreservedResources, cpus, mem, disk, nil, ports, "", "", "", "")
 }
 
-func (offerHelper *OfferHelper) CanFitReserved(cpus float64, mem float64, disk float64, ports int) bool {
+func (offerHelper *OfferHelper) CanFitReserved(cpus float64, mem float64, disk float64, source *DiskSource, ports int) bool {
 	return offerHelper.ReservedResources.Cpus >= cpus &&
 		offerHelper.ReservedResources.Mem >= mem &&
-		offerHelper.ReservedResources.Disk >= disk &&
+		offerHelper.ReservedResources.diskSize(source) >= disk &&
 		len(offerHelper.ReservedResources.Ports) >= ports
 }
 
@@ -129,6 +194,64 @@ func (offerHelper *OfferHelper) CanFitUnreserved(cpus float64, mem float64, disk
 		len(offerHelper.UnreservedResources.Ports) >= ports
 }
 
+// SelectUnreservedDisk picks where a volume of the given size would go: the root disk, then a PATH disk, then a
+// whole MOUNT disk, or only a MOUNT disk if requireMount is set. Returns the source (nil for the root disk) and how
+// much of it to reserve, which for a MOUNT disk is all of it.
+func (offerHelper *OfferHelper) SelectUnreservedDisk(size float64, requireMount bool) (*DiskSource, float64, bool) {
+	if !requireMount && offerHelper.UnreservedResources.Disk >= size {
+		return nil, size, true
+	}
+	for _, sourceType := range []string{DISK_SOURCE_PATH, DISK_SOURCE_MOUNT} {
+		if requireMount && sourceType != DISK_SOURCE_MOUNT {
+			continue
+		}
+		for _, disk := range offerHelper.UnreservedResources.Disks {
+			if disk.Source.Type != sourceType || disk.Size < size {
+				continue
+			}
+			if sourceType == DISK_SOURCE_MOUNT {
+				return disk.Source, disk.Size, true
+			}
+			return disk.Source, size, true
+		}
+	}
+	return nil, 0, false
+}
+
+// SelectUnreservedPorts picks count unreserved ports from the offer without taking them. The preferred ports are
+// used if they're all on offer, otherwise the lowest ports, from within portRange if it has enough of them.
+// Returns nil if the offer doesn't have enough ports.
+func (offerHelper *OfferHelper) SelectUnreservedPorts(count int, preferred []int64, portRange *PortRange) []int64 {
+	available := offerHelper.UnreservedResources.Ports
+	if len(available) < count {
+		return nil
+	}
+	if len(preferred) == count && containsPorts(available, preferred) {
+		return copyPorts(preferred)
+	}
+
+	sorted := copyPorts(available)
+	sort.Sort(intarray(sorted))
+	if portRange != nil {
+		inRange := []int64{}
+		for _, port := range sorted {
+			if portRange.Contains(port) {
+				inRange = append(inRange, port)
+			}
+		}
+		if len(inRange) >= count {
+			return inRange[:count]
+		}
+		log.Warnf("Not enough ports offered in the range %d-%d, using ports outside of it. OfferID: %+v",
+			portRange.Begin, portRange.End, offerHelper.OfferIDStr)
+	}
+	return sorted[:count]
+}
+
+func (offerHelper *OfferHelper) HasReservedPorts(ports []int64) bool {
+	return containsPorts(offerHelper.ReservedResources.Ports, ports)
+}
+
 func (offerHelper *OfferHelper) HasPersistenceId(persistenceId string) bool {
 	for _, offerPersistenceId := range offerHelper.PersistenceIDs {
 		if offerPersistenceId == persistenceId {
@@ -138,6 +261,44 @@ func (offerHelper *OfferHelper) HasPersistenceId(persistenceId string) bool {
 	return false
 }
 
+// Attributes are the agent's attributes as text, e.g. "rack" = "r1", "zones" = "{a,b}" or "ids" = "[1-4]"
+func (offerHelper *OfferHelper) Attributes() map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range offerHelper.MesosOffer.GetAttributes() {
+		switch attribute.GetType() {
+		case mesos.Value_SCALAR:
+			attributes[attribute.GetName()] = fmt.Sprint(attribute.GetScalar().GetValue())
+		case mesos.Value_RANGES:
+			ranges := []string{}
+			for _, valueRange := range attribute.GetRanges().GetRange() {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", valueRange.GetBegin(), valueRange.GetEnd()))
+			}
+			attributes[attribute.GetName()] = "[" + strings.Join(ranges, ",") + "]"
+		case mesos.Value_SET:
+			attributes[attribute.GetName()] = "{" + strings.Join(attribute.GetSet().GetItem(), ",") + "}"
+		default:
+			attributes[attribute.GetName()] = attribute.GetText().GetValue()
+		}
+	}
+	return attributes
+}
+
+// Port agents listen on when an offer doesn't say where its agent is
+const DEFAULT_AGENT_PORT int32 = 5051
+
+// AgentURL is where the offer's agent serves its HTTP endpoints, e.g. the files of task sandboxes
+func (offerHelper *OfferHelper) AgentURL() string {
+	url := offerHelper.MesosOffer.GetUrl()
+	if url == nil {
+		return fmt.Sprintf("http://%s:%d", offerHelper.MesosOffer.GetHostname(), DEFAULT_AGENT_PORT)
+	}
+	host := url.GetAddress().GetHostname()
+	if host == "" {
+		host = url.GetAddress().GetIp()
+	}
+	return fmt.Sprintf("%s://%s:%d", url.GetScheme(), host, url.GetAddress().GetPort())
+}
+
 func (offerHelper *OfferHelper) Operations() []*mesos.Offer_Operation {
 	operations := []*mesos.Offer_Operation{}
 	if len(offerHelper.TasksToLaunch) > 0 {
@@ -177,7 +338,7 @@ func getReservedResources(resources []*mesos.Resource) (float64, float64, float6
 
 func getPersistenceIds(resources []*mesos.Resource) []string {
 	filtered := util.FilterResources(resources, func(res *mesos.Resource) bool {
-		return res.GetName() == "disk" && res.Reservation != nil && res.Disk != nil
+		return res.Reservation != nil && IsPersistentVolume(res)
 	})
 	val := []string{}
 	for _, res := range filtered {
@@ -200,8 +361,34 @@ func getPorts(resources []*mesos.Resource, withReservation bool) []int64 {
 	return val
 }
 
+// getDisks collects the PATH and MOUNT disk resources, by source
+func getDisks(resources []*mesos.Resource, withReservation bool) []*DiskResource {
+	group := &ResourceGroup{Disks: []*DiskResource{}}
+	for _, res := range resources {
+		if res.GetName() != "disk" || (res.Reservation != nil) != withReservation {
+			continue
+		}
+		if source := GetDiskSource(res); source != nil {
+			group.addDisk(res.GetScalar().GetValue(), source)
+		}
+	}
+	return group.Disks
+}
+
+func disksString(disks []*DiskResource) string {
+	strs := []string{}
+	for _, disk := range disks {
+		strs = append(strs, fmt.Sprintf("%s=%v", disk.Source.String(), disk.Size))
+	}
+	return fmt.Sprintf("%v", strs)
+}
+
+// getResource adds up the scalar resources of the given name, other than PATH and MOUNT disks
 func getResource(name string, resources []*mesos.Resource, withReservation bool) float64 {
 	filtered := util.FilterResources(resources, func(res *mesos.Resource) bool {
+		if GetDiskSource(res) != nil {
+			return false
+		}
 		if withReservation {
 			return res.GetName() == name && res.Reservation != nil
 		}
@@ -214,9 +401,14 @@ func getResource(name string, resources []*mesos.Resource, withReservation bool)
 	return val
 }
 
-func (offerHelper *OfferHelper) apply(against *ResourceGroup, cpus float64, mem float64, disk float64, ports int,
-	principal string, role string, persistenceID string, containerPath string) []*mesos.Resource {
+// apply takes the resources from against, and returns them to be asked for. Nothing is taken if any of the ports
+// aren't in against, as Mesos would refuse them.
+func (offerHelper *OfferHelper) apply(against *ResourceGroup, cpus float64, mem float64, disk float64, source *DiskSource, ports []int64,
+	principal string, role string, persistenceID string, containerPath string) ([]*mesos.Resource, error) {
 
+	if !containsPorts(against.Ports, ports) {
+		return nil, fmt.Errorf("Ports %v aren't all in the offer's %v. OfferID: %+v", ports, against.Ports, offerHelper.OfferIDStr)
+	}
 	ask := []*mesos.Resource{}
 
 	if cpus > 0 {
@@ -238,26 +430,31 @@ func (offerHelper *OfferHelper) apply(against *ResourceGroup, cpus float64, mem
 	}
 
 	if disk > 0 {
-		against.Disk = against.Disk - disk
+		against.addDisk(-disk, source)
+		var resource *mesos.Resource
 		if principal != "" && role != "" && containerPath != "" && persistenceID != "" {
-			ask = append(ask, util.NewVolumeResourceWithReservation(disk, containerPath, persistenceID, mesos.Volume_RW.Enum(), principal, role))
+			resource = util.NewVolumeResourceWithReservation(disk, containerPath, persistenceID, mesos.Volume_RW.Enum(), principal, role)
 		} else if principal != "" && role != "" {
-			ask = append(ask, util.NewScalarResourceWithReservation("disk", disk, principal, role))
+			resource = util.NewScalarResourceWithReservation("disk", disk, principal, role)
 		} else {
-			ask = append(ask, util.NewScalarResource("disk", disk))
+			resource = util.NewScalarResource("disk", disk)
 		}
+		SetDiskSource(resource, source)
+		ask = append(ask, resource)
 	}
 
-	if ports > 0 {
-		sliceLoc := 0
-		if len(against.Ports)-ports > 0 {
-			sliceLoc = rand.Intn(len(against.Ports) - ports)
+	if len(ports) > 0 {
+		taking := make(map[int64]bool)
+		for _, port := range ports {
+			taking[port] = true
 		}
-		takingPorts := make([]int64, ports)
-		copy(takingPorts, against.Ports[sliceLoc:(sliceLoc+ports)])
-		leavingPorts := make([]int64, len(against.Ports)-ports)
-		copy(leavingPorts, against.Ports[:sliceLoc])
-		copy(leavingPorts[sliceLoc:], against.Ports[(sliceLoc+ports):])
+		leavingPorts := []int64{}
+		for _, port := range against.Ports {
+			if !taking[port] {
+				leavingPorts = append(leavingPorts, port)
+			}
+		}
+		takingPorts := copyPorts(ports)
 
 		against.Ports = leavingPorts
 		if principal != "" && role != "" {
@@ -267,5 +464,24 @@ func (offerHelper *OfferHelper) apply(against *ResourceGroup, cpus float64, mem
 		}
 	}
 
-	return ask
+	return ask, nil
+}
+
+func containsPorts(available []int64, ports []int64) bool {
+	offered := make(map[int64]bool)
+	for _, port := range available {
+		offered[port] = true
+	}
+	for _, port := range ports {
+		if !offered[port] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyPorts(ports []int64) []int64 {
+	dup := make([]int64, len(ports))
+	copy(dup, ports)
+	return dup
 }
@@ -0,0 +1,47 @@
+package common
+
+// Every node gets a port for each of these names, handed out in this order
+var STANDARD_PORT_NAMES = []string{"http", "pb", "disterl", "handoff", "solr", "solr_jmx"}
+
+func IsStandardPortName(name string) bool {
+	for _, standardName := range STANDARD_PORT_NAMES {
+		if name == standardName {
+			return true
+		}
+	}
+	return false
+}
+
+// AssignNamedPorts gives each name one of the ports. A name keeps the port it had previously if that port is still
+// among the given ones, the rest are handed out in order. Names left over once the ports run out aren't assigned.
+func AssignNamedPorts(names []string, ports []int64, previous map[string]int64) map[string]int64 {
+	assigned := make(map[string]int64)
+	taken := make(map[int64]bool)
+	available := make(map[int64]bool)
+	for _, port := range ports {
+		available[port] = true
+	}
+
+	for _, name := range names {
+		if port, ok := previous[name]; ok && available[port] && !taken[port] {
+			assigned[name] = port
+			taken[port] = true
+		}
+	}
+
+	next := 0
+	for _, name := range names {
+		if _, ok := assigned[name]; ok {
+			continue
+		}
+		for next < len(ports) && taken[ports[next]] {
+			next = next + 1
+		}
+		if next == len(ports) {
+			break
+		}
+		assigned[name] = ports[next]
+		taken[ports[next]] = true
+	}
+	return assigned
+}
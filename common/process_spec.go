@@ -0,0 +1,65 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An rlimit of a ProcessSpec which lifts the limit altogether
+const RLIMIT_UNLIMITED int64 = -1
+
+// How Riak's process is isolated from the rest of the agent
+const (
+	PROCESS_ISOLATION_NONE            string = ""                // Riak sees the agent's filesystem, and runs from the sandbox
+	PROCESS_ISOLATION_CHROOT          string = "chroot"          // Riak is chrooted into the sandbox's root, which has to hold everything it needs
+	PROCESS_ISOLATION_MOUNT_NAMESPACE string = "mount-namespace" // Riak gets a mount namespace of its own, so its mounts don't leak onto the agent
+)
+
+// ProcessSpec is set per cluster, tuning how the executor runs Riak on Linux. Without one, Riak runs as the executor's
+// user with its open files limit raised to 65536, and nothing else changed.
+type ProcessSpec struct {
+	// Soft and hard limits, or RLIMIT_UNLIMITED. Zero leaves one as the executor has it, but for the open files limit,
+	// which is still raised to 65536.
+	NoFileLimit  int64  `json:",omitempty"`
+	NProcLimit   int64  `json:",omitempty"`
+	MemlockBytes int64  `json:",omitempty"`
+	Isolation    string `json:",omitempty"`
+	// Riak runs as this user, rather than the executor's, who's given the dirs Riak writes to
+	User string `json:",omitempty"`
+	// Written to Riak's oom_score_adj, nil to inherit the executor's
+	OOMScoreAdj *int `json:",omitempty"`
+	// Limits of the cgroup v2 Riak is started in, zero for none. Only applied when the executor isn't already in a
+	// Mesos containerizer's cgroup, which limits Riak to the task's resources.
+	CgroupMemoryMaxBytes int64   `json:",omitempty"`
+	CgroupCPUs           float64 `json:",omitempty"`
+}
+
+func (processSpec *ProcessSpec) Validate() error {
+	for name, limit := range map[string]int64{
+		"NoFileLimit":  processSpec.NoFileLimit,
+		"NProcLimit":   processSpec.NProcLimit,
+		"MemlockBytes": processSpec.MemlockBytes,
+	} {
+		if limit < RLIMIT_UNLIMITED {
+			return fmt.Errorf("Process spec %s must be positive, or %d for unlimited, not %d", name, RLIMIT_UNLIMITED, limit)
+		}
+	}
+	switch processSpec.Isolation {
+	case PROCESS_ISOLATION_NONE, PROCESS_ISOLATION_CHROOT, PROCESS_ISOLATION_MOUNT_NAMESPACE:
+	default:
+		return fmt.Errorf("Unknown process spec Isolation: %s", processSpec.Isolation)
+	}
+	if strings.ContainsAny(processSpec.User, " /:") {
+		return fmt.Errorf("Invalid process spec User: %q", processSpec.User)
+	}
+	if processSpec.OOMScoreAdj != nil && (*processSpec.OOMScoreAdj < -1000 || *processSpec.OOMScoreAdj > 1000) {
+		return fmt.Errorf("Process spec OOMScoreAdj must be between -1000 and 1000, not %d", *processSpec.OOMScoreAdj)
+	}
+	if processSpec.CgroupMemoryMaxBytes < 0 {
+		return fmt.Errorf("Process spec CgroupMemoryMaxBytes can't be negative: %d", processSpec.CgroupMemoryMaxBytes)
+	}
+	if processSpec.CgroupCPUs < 0 {
+		return fmt.Errorf("Process spec CgroupCPUs can't be negative: %v", processSpec.CgroupCPUs)
+	}
+	return nil
+}
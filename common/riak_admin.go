@@ -0,0 +1,72 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The riak-admin subcommands which can be run through the API. For the ones which both inspect and change the
+// cluster, only the listed first arguments are allowed, and an empty list allows no arguments at all. The other
+// subcommands take any arguments.
+var RiakAdminCommands = map[string][]string{
+	"aae-status":       nil,
+	"bucket-type":      {"status", "list"},
+	"cluster":          {"status", "plan"},
+	"diag":             nil,
+	"handoff":          {"summary", "details", "config"},
+	"member-status":    nil,
+	"ring-status":      nil,
+	"ringready":        nil,
+	"search":           {"aae-status"},
+	"services":         nil,
+	"status":           nil,
+	"transfer-limit":   {}, // Sets the limit when given one
+	"transfers":        nil,
+	"vnode-status":     nil,
+	"wait-for-service": nil,
+}
+
+// Longest a riak-admin or riak-debug run through the API can be given to finish
+const MAX_RIAK_COMMAND_TIMEOUT_SECONDS int64 = 3600
+
+// How long riak-admin and riak-debug get to finish, unless the API request says otherwise
+const (
+	DEFAULT_RIAK_ADMIN_TIMEOUT_SECONDS int64 = 60
+	DEFAULT_RIAK_DEBUG_TIMEOUT_SECONDS int64 = 600
+)
+
+// ValidateRiakAdminArgs checks that riak-admin would be run with an allowed subcommand
+func ValidateRiakAdminArgs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("No riak-admin subcommand given, allowed are: %s", strings.Join(riakAdminCommandNames(), ", "))
+	}
+	firstArgs, allowed := RiakAdminCommands[args[0]]
+	if !allowed {
+		return fmt.Errorf("riak-admin %s is not allowed, allowed are: %s", args[0], strings.Join(riakAdminCommandNames(), ", "))
+	}
+	if firstArgs == nil {
+		return nil
+	}
+	if len(firstArgs) == 0 {
+		if len(args) > 1 {
+			return fmt.Errorf("riak-admin %s is only allowed without arguments", args[0])
+		}
+		return nil
+	}
+	for _, firstArg := range firstArgs {
+		if len(args) > 1 && args[1] == firstArg {
+			return nil
+		}
+	}
+	return fmt.Errorf("riak-admin %s is only allowed with: %s", args[0], strings.Join(firstArgs, ", "))
+}
+
+func riakAdminCommandNames() []string {
+	names := []string{}
+	for name := range RiakAdminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 type TaskData struct {
@@ -11,11 +12,78 @@ type TaskData struct {
 	ClusterName            string
 	URI                    string
 	Host                   string
-	UseSuperChroot         bool
 	HTTPPort               int64
 	PBPort                 int64
 	HandoffPort            int64
 	DisterlPort            int64
+	NamedPorts             map[string]int64
+	// The executor fetches this revision of the cluster's configs, or the current one if it's 0
+	ConfigRevision int64 `json:",omitempty"`
+	// The task's resources and the attributes of its agent
+	Cpus       float64
+	Mem        float64
+	Disk       float64
+	Attributes map[string]string
+	// The cluster's template variables, as of ConfigRevision
+	Variables map[string]string `json:",omitempty"`
+	// Nil for the executor's defaults
+	HealthCheck  *HealthCheck  `json:",omitempty"`
+	LogShipping  *LogShipping  `json:",omitempty"`
+	StopSequence *StopSequence `json:",omitempty"`
+	ProcessSpec  *ProcessSpec  `json:",omitempty"`
+}
+
+// What the executor does once its Riak node fails too many liveness checks in a row
+const (
+	HEALTH_CHECK_ACTION_RESTART string = "restart" // Restart Riak in the same task
+	HEALTH_CHECK_ACTION_FAIL    string = "fail"    // Fail the task, for the scheduler to relaunch it
+)
+
+// HealthCheck is set per cluster, tuning how the executor decides its Riak node is ready, and then alive
+type HealthCheck struct {
+	// The task fails if Riak isn't ready after this long
+	StartTimeoutSeconds int64
+	// Once ready, Riak is checked this often. Zero for the executor's default, as for the fields below.
+	IntervalSeconds int64 `json:",omitempty"`
+	// FailureAction is taken after this many failed checks in a row
+	MaxFailures   int    `json:",omitempty"`
+	FailureAction string `json:",omitempty"`
+}
+
+func (healthCheck *HealthCheck) Validate() error {
+	if healthCheck.StartTimeoutSeconds <= 0 {
+		return fmt.Errorf("Health check StartTimeoutSeconds must be positive, not %d", healthCheck.StartTimeoutSeconds)
+	}
+	if healthCheck.IntervalSeconds < 0 {
+		return fmt.Errorf("Health check IntervalSeconds can't be negative: %d", healthCheck.IntervalSeconds)
+	}
+	if healthCheck.MaxFailures < 0 {
+		return fmt.Errorf("Health check MaxFailures can't be negative: %d", healthCheck.MaxFailures)
+	}
+	switch healthCheck.FailureAction {
+	case "", HEALTH_CHECK_ACTION_RESTART, HEALTH_CHECK_ACTION_FAIL:
+		return nil
+	}
+	return fmt.Errorf("Unknown health check FailureAction: %s", healthCheck.FailureAction)
+}
+
+// StopSequence is set per cluster, tuning how long the executor waits for each way of stopping Riak to work: riak
+// stop, then SIGTERM, then SIGKILL
+type StopSequence struct {
+	// Riak is sent SIGTERM if riak stop didn't stop it after this long. Zero for the executor's defaults, as below.
+	StopCommandTimeoutSeconds int64 `json:",omitempty"`
+	// Riak is sent SIGKILL if SIGTERM didn't stop it after this long
+	SigtermTimeoutSeconds int64 `json:",omitempty"`
+}
+
+func (stopSequence *StopSequence) Validate() error {
+	if stopSequence.StopCommandTimeoutSeconds < 0 {
+		return fmt.Errorf("Stop sequence StopCommandTimeoutSeconds can't be negative: %d", stopSequence.StopCommandTimeoutSeconds)
+	}
+	if stopSequence.SigtermTimeoutSeconds < 0 {
+		return fmt.Errorf("Stop sequence SigtermTimeoutSeconds can't be negative: %d", stopSequence.SigtermTimeoutSeconds)
+	}
+	return nil
 }
 
 func (s *TaskData) Serialize() ([]byte, error) {
@@ -29,6 +97,38 @@ func DeserializeTaskData(data []byte) (TaskData, error) {
 	return t, err
 }
 
+// TaskTemplateData is what both of a cluster's config templates can use about the task, e.g. {{.Mem}} or {{.Vars.name}}
+type TaskTemplateData struct {
+	Cpus          float64
+	Mem           float64
+	Disk          float64
+	DataDir       string
+	Hostname      string
+	ClusterName   string
+	FrameworkName string
+	Attributes    map[string]string
+	Vars          map[string]string
+}
+
+// RiakConfigTemplateData is what the executor renders a cluster's riak.conf template with
+type RiakConfigTemplateData struct {
+	TaskTemplateData
+	HTTPPort               int64
+	PBPort                 int64
+	HandoffPort            int64
+	FullyQualifiedNodeName string
+	DisterlPort            int64
+	// Every named port, e.g. {{.Ports.solr}}
+	Ports map[string]int64
+}
+
+// AdvancedConfigTemplateData is what the executor renders a cluster's advanced.config template with
+type AdvancedConfigTemplateData struct {
+	TaskTemplateData
+	CEPMDPort int
+	Ports     map[string]int64
+}
+
 type CoordinatedData struct {
 	FrameworkName string
 	ClusterName   string
@@ -36,6 +136,8 @@ type CoordinatedData struct {
 	DisterlPort   int
 	PBPort        int
 	HTTPPort      int
+	HandoffPort   int
+	NamedPorts    map[string]int
 	Hostname      string
 }
 
@@ -68,6 +170,8 @@ func DeserializeDisterlData(data []byte) (DisterlData, error) {
 
 type TaskStatusData struct {
 	RexPort int64
+	// How many times the scheduler has had the executor restart Riak in the same task
+	InPlaceRestarts int64 `json:",omitempty"`
 }
 
 func (s *TaskStatusData) Serialize() ([]byte, error) {
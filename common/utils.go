@@ -56,6 +56,7 @@ func CopyReservedVolumes(immutableResources []*mesos.Resource) []*mesos.Resource
 		principal := resource.Reservation.GetPrincipal()
 		role := resource.GetRole()
 		newVolume := NewReservedVolume(resource.Scalar.GetValue(), &containerPath, &persistenceID, &principal, &role)
+		SetDiskSource(newVolume, GetDiskSource(resource))
 		reserved = append(reserved, newVolume)
 	}
 	return reserved
@@ -66,7 +67,13 @@ func CopyReservedResources(immutableResources []*mesos.Resource) []*mesos.Resour
 	for _, resource := range FilterReservedResources(immutableResources) {
 		principal := resource.Reservation.GetPrincipal()
 		role := resource.GetRole()
+		if resource.GetType() == mesos.Value_RANGES {
+			newResource := util.NewRangesResourceWithReservation(resource.GetName(), resource.GetRanges().GetRange(), principal, role)
+			reserved = append(reserved, newResource)
+			continue
+		}
 		newResource := NewReservedScalarResource(resource.GetName(), resource.Scalar.GetValue(), &principal, &role)
+		SetDiskSource(newResource, GetDiskSource(resource))
 		reserved = append(reserved, newResource)
 	}
 	return reserved
@@ -74,12 +81,7 @@ func CopyReservedResources(immutableResources []*mesos.Resource) []*mesos.Resour
 
 func FilterReservedVolumes(immutableResources []*mesos.Resource) []*mesos.Resource {
 	return util.FilterResources(immutableResources, func(res *mesos.Resource) bool {
-		if res.Reservation != nil &&
-			res.Disk != nil &&
-			res.GetName() == "disk" {
-			return true
-		}
-		return false
+		return res.Reservation != nil && IsPersistentVolume(res)
 	})
 }
 
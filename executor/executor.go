@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
 	exec "github.com/mesos/mesos-go/executor"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	"os"
@@ -81,7 +82,7 @@ func (exec *ExecutorCore) LaunchTask(driver exec.ExecutorDriver, taskInfo *mesos
 	}
 
 	if exec.riakNode != nil {
-		log.Fatalf("Task being started, twice, existing task: %+v, new task: %+v", exec.riakNode)
+		log.Fatalf("Task being started, twice, existing task: %+v, new task: %+v", exec.riakNode.taskInfo, taskInfo)
 	}
 	exec.riakNode = NewRiakNode(taskInfo, exec)
 	exec.riakNode.Run()
@@ -102,20 +103,23 @@ func (exec *ExecutorCore) KillTask(driver exec.ExecutorDriver, taskId *mesos.Tas
 		log.Panic("Got error", err)
 	}
 
-	exec.riakNode.finish()
+	exec.riakNode.finish(common.FrameworkMessage{})
 }
 
 func (exec *ExecutorCore) FrameworkMessage(driver exec.ExecutorDriver, msg string) {
 	exec.lock.Lock()
 	defer exec.lock.Unlock()
 	fmt.Println("Got framework message: ", msg)
-	switch msg {
-	case "finish":
-		{
-			log.Info("Force finishing riak node")
-			exec.riakNode.ForceFinish()
-		}
+	message, err := common.DeserializeFrameworkMessage(msg)
+	if err != nil {
+		log.Error("Unable to parse framework message: ", err)
+		return
+	}
+	if exec.riakNode == nil {
+		log.Warnf("Got %s before a task was launched", message.Command)
+		return
 	}
+	exec.riakNode.handleMessage(message)
 }
 
 func (exec *ExecutorCore) Shutdown(driver exec.ExecutorDriver) {
@@ -164,7 +168,7 @@ func signalWatcher(signals chan os.Signal, exec *ExecutorCore) {
 		case syscall.SIGUSR1:
 			{
 				log.Info("Marking task as finished")
-				exec.riakNode.finish()
+				exec.riakNode.finish(common.FrameworkMessage{})
 			}
 		case syscall.SIGUSR2:
 			{
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecutorDriver implements exec.ExecutorDriver, and records the status updates and framework messages sent
+type fakeExecutorDriver struct {
+	lock     *sync.Mutex
+	statuses []*mesos.TaskStatus
+	messages []string
+}
+
+func newFakeExecutorDriver() *fakeExecutorDriver {
+	return &fakeExecutorDriver{lock: &sync.Mutex{}}
+}
+
+func (driver *fakeExecutorDriver) Start() (mesos.Status, error) {
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeExecutorDriver) Stop() (mesos.Status, error) {
+	return mesos.Status_DRIVER_STOPPED, nil
+}
+func (driver *fakeExecutorDriver) Abort() (mesos.Status, error) {
+	return mesos.Status_DRIVER_ABORTED, nil
+}
+func (driver *fakeExecutorDriver) Join() (mesos.Status, error) {
+	return mesos.Status_DRIVER_STOPPED, nil
+}
+func (driver *fakeExecutorDriver) Run() (mesos.Status, error) {
+	return mesos.Status_DRIVER_STOPPED, nil
+}
+func (driver *fakeExecutorDriver) SendStatusUpdate(status *mesos.TaskStatus) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.statuses = append(driver.statuses, status)
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeExecutorDriver) SendFrameworkMessage(data string) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.messages = append(driver.messages, data)
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+
+// waitForReply waits for the reply to the request, which commands run in the background send
+func (driver *fakeExecutorDriver) waitForReply(t *testing.T, requestID string) common.FrameworkReply {
+	for i := 0; i < 500; i++ {
+		driver.lock.Lock()
+		for _, message := range driver.messages {
+			if reply, err := common.DeserializeFrameworkReply(message); err == nil && reply.RequestID == requestID {
+				driver.lock.Unlock()
+				return reply
+			}
+		}
+		driver.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the reply to %s", requestID)
+	return common.FrameworkReply{}
+}
+
+// newTestRiakNode is a RiakNode of task riak-default-1 whose executor has the driver, without Riak running
+func newTestRiakNode(driver *fakeExecutorDriver) *RiakNode {
+	executor := newExecutor()
+	executor.Driver = driver
+	executor.riakNode = &RiakNode{
+		executor:    executor,
+		taskInfo:    &mesos.TaskInfo{TaskId: &mesos.TaskID{Value: proto.String("riak-default-1")}},
+		finishChan:  make(chan common.FrameworkMessage, 1),
+		restartChan: make(chan common.FrameworkMessage, 1),
+		requestChan: make(chan common.FrameworkMessage, 4),
+	}
+	return executor.riakNode
+}
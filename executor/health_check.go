@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How long each request of a health check waits for Riak to answer
+const HEALTH_CHECK_REQUEST_TIMEOUT = 5 * time.Second
+
+// Liveness checks of clusters which don't set their own
+const (
+	DEFAULT_HEALTH_CHECK_INTERVAL     = 30 * time.Second
+	DEFAULT_HEALTH_CHECK_MAX_FAILURES = 3
+	DEFAULT_HEALTH_CHECK_ACTION       = common.HEALTH_CHECK_ACTION_RESTART
+)
+
+// riakHealthCheck passes once the node answers /ping on its HTTP port, and its /stats show riak_kv running vnodes
+func riakHealthCheck(httpPort int64) process_manager.Healthchecker {
+	client := &http.Client{Timeout: HEALTH_CHECK_REQUEST_TIMEOUT}
+	baseURI := fmt.Sprintf("http://127.0.0.1:%d", httpPort)
+	return func() error {
+		body, err := healthCheckGet(client, baseURI+"/ping")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(body)) != "OK" {
+			return fmt.Errorf("Unexpected reply to /ping: %q", body)
+		}
+
+		body, err = healthCheckGet(client, baseURI+"/stats")
+		if err != nil {
+			return err
+		}
+		stats := make(map[string]interface{})
+		if err := json.Unmarshal(body, &stats); err != nil {
+			return fmt.Errorf("Unable to parse /stats: %v", err)
+		}
+		if _, running := stats["riak_kv_version"]; !running {
+			return fmt.Errorf("riak_kv is not running yet")
+		}
+		if vnodes, _ := stats["riak_kv_vnodes_running"].(float64); vnodes <= 0 {
+			return fmt.Errorf("riak_kv has no vnodes running yet")
+		}
+		return nil
+	}
+}
+
+func healthCheckGet(client *http.Client, uri string) ([]byte, error) {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Riak's HTTP interface isn't up: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", uri, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s returned %d: %s", uri, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// healthCheckStartTimeout is how long Riak gets to pass its health check, the process manager's default if the
+// cluster doesn't set one
+func healthCheckStartTimeout(healthCheck *common.HealthCheck) time.Duration {
+	if healthCheck == nil {
+		return process_manager.DEFAULT_HEALTHCHECK_TIMEOUT
+	}
+	return time.Duration(healthCheck.StartTimeoutSeconds) * time.Second
+}
+
+func healthCheckInterval(healthCheck *common.HealthCheck) time.Duration {
+	if healthCheck == nil || healthCheck.IntervalSeconds == 0 {
+		return DEFAULT_HEALTH_CHECK_INTERVAL
+	}
+	return time.Duration(healthCheck.IntervalSeconds) * time.Second
+}
+
+func healthCheckMaxFailures(healthCheck *common.HealthCheck) int {
+	if healthCheck == nil || healthCheck.MaxFailures == 0 {
+		return DEFAULT_HEALTH_CHECK_MAX_FAILURES
+	}
+	return healthCheck.MaxFailures
+}
+
+func healthCheckFailureAction(healthCheck *common.HealthCheck) string {
+	if healthCheck == nil || healthCheck.FailureAction == "" {
+		return DEFAULT_HEALTH_CHECK_ACTION
+	}
+	return healthCheck.FailureAction
+}
+
+// healthTracker counts Riak's failed health checks in a row, and remembers whether it was last reported healthy
+type healthTracker struct {
+	maxFailures int
+	failures    int
+	healthy     bool
+	lastErr     error
+}
+
+func newHealthTracker(maxFailures int) *healthTracker {
+	return &healthTracker{maxFailures: maxFailures, healthy: true}
+}
+
+// record counts a health check. changed is true when Riak's health has to be reported again, exceeded when it
+// failed maxFailures checks in a row, which starts the count again.
+func (tracker *healthTracker) record(checkErr error) (changed bool, exceeded bool) {
+	tracker.lastErr = checkErr
+	if checkErr == nil {
+		tracker.failures = 0
+	} else {
+		tracker.failures = tracker.failures + 1
+	}
+	if tracker.healthy != (checkErr == nil) {
+		tracker.healthy = checkErr == nil
+		changed = true
+	}
+	if tracker.failures >= tracker.maxFailures {
+		tracker.failures = 0
+		exceeded = true
+	}
+	return changed, exceeded
+}
+
+// reset is for a restarted Riak, which was reported healthy once it passed its health check
+func (tracker *healthTracker) reset() {
+	tracker.failures = 0
+	tracker.healthy = true
+	tracker.lastErr = nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeRiak answers /ping and /stats like Riak's HTTP interface, with what the test sets
+type fakeRiak struct {
+	ping       string
+	stats      string
+	statusCode int
+}
+
+func (riak *fakeRiak) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(riak.statusCode)
+	switch r.URL.Path {
+	case "/ping":
+		fmt.Fprint(w, riak.ping)
+	case "/stats":
+		fmt.Fprint(w, riak.stats)
+	}
+}
+
+func fakeRiakPort(t *testing.T, server *httptest.Server) int64 {
+	serverURL, _ := url.Parse(server.URL)
+	port, err := strconv.ParseInt(serverURL.Port(), 10, 64)
+	assert.Nil(t, err)
+	return port
+}
+
+func TestRiakHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+	riak := &fakeRiak{ping: "OK", stats: `{"riak_kv_version":"2.1.4","riak_kv_vnodes_running":8}`, statusCode: 200}
+	server := httptest.NewServer(riak)
+	healthCheck := riakHealthCheck(fakeRiakPort(t, server))
+	assert.Nil(healthCheck())
+
+	riak.stats = `{"riak_kv_version":"2.1.4","riak_kv_vnodes_running":0}`
+	assert.EqualError(healthCheck(), "riak_kv has no vnodes running yet")
+
+	riak.stats = `{"riak_core_version":"2.1.4"}`
+	assert.EqualError(healthCheck(), "riak_kv is not running yet")
+
+	riak.stats = `{"riak_kv_version":`
+	assert.Contains(healthCheck().Error(), "Unable to parse /stats")
+
+	riak.ping = "pang"
+	assert.EqualError(healthCheck(), `Unexpected reply to /ping: "pang"`)
+
+	riak.statusCode = 503
+	assert.Contains(healthCheck().Error(), "/ping returned 503: pang")
+
+	server.Close()
+	assert.Contains(healthCheck().Error(), "Riak's HTTP interface isn't up")
+}
+
+func TestHealthCheckStartTimeout(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(process_manager.DEFAULT_HEALTHCHECK_TIMEOUT, healthCheckStartTimeout(nil))
+	assert.Equal(10*time.Minute, healthCheckStartTimeout(&common.HealthCheck{StartTimeoutSeconds: 600}))
+}
+
+func TestHealthCheckDefaults(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(DEFAULT_HEALTH_CHECK_INTERVAL, healthCheckInterval(nil))
+	assert.Equal(DEFAULT_HEALTH_CHECK_MAX_FAILURES, healthCheckMaxFailures(nil))
+	assert.Equal(DEFAULT_HEALTH_CHECK_ACTION, healthCheckFailureAction(nil))
+
+	healthCheck := &common.HealthCheck{StartTimeoutSeconds: 600}
+	assert.Equal(DEFAULT_HEALTH_CHECK_INTERVAL, healthCheckInterval(healthCheck))
+	assert.Equal(DEFAULT_HEALTH_CHECK_MAX_FAILURES, healthCheckMaxFailures(healthCheck))
+	assert.Equal(DEFAULT_HEALTH_CHECK_ACTION, healthCheckFailureAction(healthCheck))
+
+	healthCheck = &common.HealthCheck{StartTimeoutSeconds: 600, IntervalSeconds: 5, MaxFailures: 1, FailureAction: common.HEALTH_CHECK_ACTION_FAIL}
+	assert.Equal(5*time.Second, healthCheckInterval(healthCheck))
+	assert.Equal(1, healthCheckMaxFailures(healthCheck))
+	assert.Equal(common.HEALTH_CHECK_ACTION_FAIL, healthCheckFailureAction(healthCheck))
+}
+
+func TestHealthTracker(t *testing.T) {
+	assert := assert.New(t)
+	health := newHealthTracker(3)
+	checkErr := errors.New("riak_kv is not running yet")
+
+	// Only changes of health are reported
+	changed, exceeded := health.record(nil)
+	assert.False(changed)
+	assert.False(exceeded)
+	changed, exceeded = health.record(checkErr)
+	assert.True(changed)
+	assert.False(exceeded)
+	assert.False(health.healthy)
+	assert.Equal(checkErr, health.lastErr)
+	changed, exceeded = health.record(checkErr)
+	assert.False(changed)
+	assert.False(exceeded)
+
+	// A passing check starts the count again
+	changed, exceeded = health.record(nil)
+	assert.True(changed)
+	assert.False(exceeded)
+	assert.True(health.healthy)
+	assert.Nil(health.lastErr)
+	health.record(checkErr)
+	health.record(checkErr)
+	_, exceeded = health.record(checkErr)
+	assert.True(exceeded)
+	assert.Equal(0, health.failures)
+	assert.False(health.healthy)
+
+	// So does exceeding it
+	health.record(checkErr)
+	_, exceeded = health.record(checkErr)
+	assert.False(exceeded)
+	_, exceeded = health.record(checkErr)
+	assert.True(exceeded)
+
+	health.reset()
+	assert.True(health.healthy)
+	assert.Nil(health.lastErr)
+	changed, _ = health.record(nil)
+	assert.False(changed)
+}
+
+func TestHealthStatus(t *testing.T) {
+	assert := assert.New(t)
+	riakNode := &RiakNode{
+		taskInfo:   &mesos.TaskInfo{TaskId: &mesos.TaskID{Value: proto.String("riak-default-1")}},
+		statusData: []byte("{}"),
+	}
+
+	status := riakNode.healthStatus(nil)
+	assert.Equal("riak-default-1", status.GetTaskId().GetValue())
+	assert.Equal(mesos.TaskState_TASK_RUNNING, status.GetState())
+	assert.True(status.GetHealthy())
+	assert.Nil(status.Message)
+	assert.Equal([]byte("{}"), status.Data)
+
+	status = riakNode.healthStatus(errors.New("riak_kv has no vnodes running yet"))
+	assert.Equal(mesos.TaskState_TASK_RUNNING, status.GetState())
+	assert.False(status.GetHealthy())
+	assert.Equal("riak_kv has no vnodes running yet", status.GetMessage())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the log shipper looks for new lines in Riak's logs
+const LOG_SHIPPING_INTERVAL = time.Second
+
+// Where Riak writes common.RIAK_LOG_FILES, relative to the sandbox
+const RIAK_LOG_DIR = "root/riak/log"
+
+// logShipper follows Riak's logs, passing every new line on with the node's name in front of it
+type logShipper struct {
+	lock        *sync.Mutex
+	nodeName    string
+	logDir      string
+	logShipping *common.LogShipping
+	syslog      *syslog.Writer
+	// Where each log was read up to, and the file it was read from, to notice it being rotated
+	offsets map[string]int64
+	infos   map[string]os.FileInfo
+	// The start of a line which isn't completely written yet
+	partial map[string]string
+}
+
+// newLogShipper only ships what's written to the logs from now on
+func newLogShipper(nodeName string, logDir string) *logShipper {
+	shipper := &logShipper{
+		lock:     &sync.Mutex{},
+		nodeName: nodeName,
+		logDir:   logDir,
+		offsets:  make(map[string]int64),
+		infos:    make(map[string]os.FileInfo),
+		partial:  make(map[string]string),
+	}
+	for _, file := range common.RIAK_LOG_FILES {
+		if info, err := os.Stat(filepath.Join(logDir, file)); err == nil {
+			shipper.offsets[file] = info.Size()
+			shipper.infos[file] = info
+		}
+	}
+	return shipper
+}
+
+// Configure changes where the logs are shipped to, nil for LOG_SHIPPING_STDOUT
+func (shipper *logShipper) Configure(logShipping *common.LogShipping) {
+	shipper.lock.Lock()
+	defer shipper.lock.Unlock()
+	if shipper.syslog != nil {
+		shipper.syslog.Close()
+		shipper.syslog = nil
+	}
+	shipper.logShipping = logShipping
+}
+
+func (shipper *logShipper) loop() {
+	for range time.Tick(LOG_SHIPPING_INTERVAL) {
+		shipper.ship()
+	}
+}
+
+func (shipper *logShipper) ship() {
+	shipper.lock.Lock()
+	defer shipper.lock.Unlock()
+	destination := common.LOG_SHIPPING_STDOUT
+	if shipper.logShipping != nil {
+		destination = shipper.logShipping.Destination
+	}
+	if destination == common.LOG_SHIPPING_NONE {
+		return
+	}
+	if destination == common.LOG_SHIPPING_SYSLOG && shipper.syslog == nil {
+		network, address, _ := shipper.logShipping.SyslogNetwork()
+		writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "riak")
+		if err != nil {
+			log.Warnf("Unable to reach syslog at %s, shipping logs to stdout meanwhile: %v", shipper.logShipping.SyslogAddress, err)
+		}
+		shipper.syslog = writer
+	}
+
+	for _, file := range common.RIAK_LOG_FILES {
+		lines, err := shipper.newLines(file)
+		if err != nil {
+			log.Errorf("Unable to read %s: %v", file, err)
+			continue
+		}
+		for _, line := range lines {
+			shipper.send(file, fmt.Sprintf("%s %s: %s", shipper.nodeName, file, line))
+		}
+	}
+}
+
+func (shipper *logShipper) send(file string, line string) {
+	if shipper.syslog != nil {
+		var err error
+		if file == "console.log" {
+			err = shipper.syslog.Info(line)
+		} else {
+			err = shipper.syslog.Err(line)
+		}
+		if err == nil {
+			return
+		}
+		log.Warn("Unable to ship logs to syslog: ", err)
+		shipper.syslog.Close()
+		shipper.syslog = nil
+	}
+	if file == "console.log" {
+		fmt.Fprintln(os.Stdout, line)
+	} else {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// newLines are the lines completed in the log since it was last read, starting over once the log was rotated
+func (shipper *logShipper) newLines(file string) ([]string, error) {
+	path := filepath.Join(shipper.logDir, file)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if previous, seen := shipper.infos[file]; !seen || !os.SameFile(previous, info) || info.Size() < shipper.offsets[file] {
+		shipper.offsets[file] = 0
+		shipper.partial[file] = ""
+	}
+	shipper.infos[file] = info
+	if info.Size() == shipper.offsets[file] {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(shipper.offsets[file], 0); err != nil {
+		return nil, err
+	}
+	written := &bytes.Buffer{}
+	read, err := io.Copy(written, f)
+	if err != nil {
+		return nil, err
+	}
+	shipper.offsets[file] = shipper.offsets[file] + read
+
+	lines := strings.Split(shipper.partial[file]+written.String(), "\n")
+	shipper.partial[file] = lines[len(lines)-1]
+	return lines[:len(lines)-1], nil
+}
+
+// tailLog reads the last lines of the log, none if Riak hasn't written it
+func tailLog(path string, lines int) ([]string, error) {
+	const chunkSize = 64 * 1024
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reads chunks from the end, until there's a newline in front of the first of the lines
+	end := info.Size()
+	tail := []byte{}
+	for end > 0 && bytes.Count(tail, []byte("\n")) <= lines {
+		start := end - chunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := make([]byte, end-start)
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		end = start
+	}
+
+	text := strings.TrimSuffix(string(tail), "\n")
+	if text == "" {
+		return []string{}, nil
+	}
+	all := strings.Split(text, "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return all, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func appendLog(t *testing.T, path string, text string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(text)
+	assert.Nil(t, err)
+	f.Close()
+}
+
+func TestLogShipperNewLines(t *testing.T) {
+	assert := assert.New(t)
+	logDir, _ := ioutil.TempDir("", "riak-log")
+	defer os.RemoveAll(logDir)
+	console := filepath.Join(logDir, "console.log")
+
+	// What was logged before the shipper started isn't shipped
+	appendLog(t, console, "starting\n")
+	shipper := newLogShipper("riak-default-1@agent1", logDir)
+	lines, err := shipper.newLines("console.log")
+	assert.Nil(err)
+	assert.Equal(0, len(lines))
+
+	// A line is only shipped once it's complete
+	appendLog(t, console, "ready\npartial")
+	lines, _ = shipper.newLines("console.log")
+	assert.Equal([]string{"ready"}, lines)
+	appendLog(t, console, " line\n")
+	lines, _ = shipper.newLines("console.log")
+	assert.Equal([]string{"partial line"}, lines)
+	lines, _ = shipper.newLines("console.log")
+	assert.Equal(0, len(lines))
+
+	// A rotated log is read from its start
+	assert.Nil(os.Rename(console, console+".0"))
+	appendLog(t, console, "rotated\n")
+	lines, _ = shipper.newLines("console.log")
+	assert.Equal([]string{"rotated"}, lines)
+
+	// As is one which was truncated in place
+	assert.Nil(ioutil.WriteFile(console, []byte("new\n"), 0644))
+	lines, _ = shipper.newLines("console.log")
+	assert.Equal([]string{"new"}, lines)
+
+	// Logs Riak hasn't written yet are read from their start once it has
+	lines, err = shipper.newLines("crash.log")
+	assert.Nil(err)
+	assert.Equal(0, len(lines))
+	appendLog(t, filepath.Join(logDir, "crash.log"), "crashed\n")
+	lines, _ = shipper.newLines("crash.log")
+	assert.Equal([]string{"crashed"}, lines)
+}
+
+func TestTailLog(t *testing.T) {
+	assert := assert.New(t)
+	logDir, _ := ioutil.TempDir("", "riak-log")
+	defer os.RemoveAll(logDir)
+	path := filepath.Join(logDir, "console.log")
+
+	lines, err := tailLog(path, 10)
+	assert.Nil(err)
+	assert.Equal([]string{}, lines)
+
+	appendLog(t, path, "first\nsecond\nthird\n")
+	lines, _ = tailLog(path, 2)
+	assert.Equal([]string{"second", "third"}, lines)
+	lines, _ = tailLog(path, 10)
+	assert.Equal([]string{"first", "second", "third"}, lines)
+
+	// Lines spanning the chunks read from the end of the log
+	long := []string{}
+	for i := 0; i < 10000; i++ {
+		long = append(long, fmt.Sprintf("line %05d %s", i, strings.Repeat("x", 20)))
+	}
+	assert.Nil(ioutil.WriteFile(path, []byte(strings.Join(long, "\n")+"\n"), 0644))
+	lines, _ = tailLog(path, 5000)
+	assert.Equal(long[5000:], lines)
+}
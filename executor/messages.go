@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// How long riak-admin and riak-debug get to finish, if the scheduler doesn't say
+const (
+	RIAK_ADMIN_TIMEOUT = time.Duration(common.DEFAULT_RIAK_ADMIN_TIMEOUT_SECONDS) * time.Second
+	RIAK_DEBUG_TIMEOUT = time.Duration(common.DEFAULT_RIAK_DEBUG_TIMEOUT_SECONDS) * time.Second
+)
+
+// Where riak-debug leaves its bundle in the chroot dir, replacing the one it collected before
+const (
+	RIAK_DEBUG_DIR    = "/riak/debug"
+	RIAK_DEBUG_BUNDLE = "riak-debug.tar.gz"
+)
+
+// handleMessage runs a command from the scheduler and replies with its result. Commands which need Riak's state are
+// handled by runLoop, the others in their own goroutine, as the executor's lock is held meanwhile.
+func (riakNode *RiakNode) handleMessage(message common.FrameworkMessage) {
+	switch message.Command {
+	case common.COMMAND_FINISH:
+		// Stopping Riak can take minutes, runLoop replies once it has
+		riakNode.finish(message)
+	case common.COMMAND_RESTART:
+		if message.TaskData == nil {
+			riakNode.reply(message, nil, errors.New("No task data to restart Riak with"))
+			return
+		}
+		log.Info("Restarting riak node in place")
+		// runLoop replies once Riak was configured for the restart
+		riakNode.RestartInPlace(message)
+	case common.COMMAND_RELOAD_CONFIG, common.COMMAND_COLLECT_DIAGNOSTICS:
+		if message.Command == common.COMMAND_RELOAD_CONFIG && message.TaskData == nil {
+			riakNode.reply(message, nil, errors.New("No task data to render the configs with"))
+			return
+		}
+		select {
+		case riakNode.requestChan <- message:
+		default:
+			riakNode.reply(message, nil, errors.New("Riak is busy or not running"))
+		}
+	case common.COMMAND_REPORT_DISK_USAGE:
+		go func() {
+			usage, err := diskUsage(filepath.Join("root", "riak", "data"))
+			riakNode.reply(message, usage, err)
+		}()
+	case common.COMMAND_RUN_RIAK_ADMIN:
+		if err := common.ValidateRiakAdminArgs(message.Args); err != nil {
+			riakNode.reply(message, nil, err)
+			return
+		}
+		processSpec := riakNode.scriptProcessSpec()
+		go func() {
+			output, err := runRiakScript(processSpec, "riak-admin", message.Args, messageTimeout(message, RIAK_ADMIN_TIMEOUT))
+			riakNode.reply(message, output, err)
+		}()
+	case common.COMMAND_TAIL_LOG:
+		if len(message.Args) != 1 || !common.IsRiakLogFile(message.Args[0]) || message.Lines <= 0 || message.Lines > common.MAX_LOG_TAIL_LINES {
+			riakNode.reply(message, nil, fmt.Errorf("Invalid log or lines: %v, %d", message.Args, message.Lines))
+			return
+		}
+		go func() {
+			lines, err := tailLog(filepath.Join(RIAK_LOG_DIR, message.Args[0]), message.Lines)
+			riakNode.reply(message, &common.LogTail{File: message.Args[0], Lines: lines}, err)
+		}()
+	case common.COMMAND_RUN_RIAK_DEBUG:
+		processSpec := riakNode.scriptProcessSpec()
+		go func() {
+			bundle, err := collectDebugBundle(processSpec, messageTimeout(message, RIAK_DEBUG_TIMEOUT))
+			riakNode.reply(message, bundle, err)
+		}()
+	default:
+		riakNode.reply(message, nil, fmt.Errorf("Unknown command %q", message.Command))
+	}
+}
+
+// reply answers the message, unless the scheduler isn't waiting for an answer
+func (riakNode *RiakNode) reply(message common.FrameworkMessage, result interface{}, err error) {
+	if err != nil {
+		log.Errorf("%s failed: %v", message.Command, err)
+	}
+	if message.RequestID == "" {
+		return
+	}
+	data, err := common.NewFrameworkReply(message, riakNode.taskInfo.GetTaskId().GetValue(), result, err).Serialize()
+	if err != nil {
+		log.Error("Unable to serialize framework reply: ", err)
+		return
+	}
+	if _, err := riakNode.executor.Driver.SendFrameworkMessage(data); err != nil {
+		log.Error("Unable to send framework reply: ", err)
+	}
+}
+
+// diagnostics is the executor's view of Riak, for COMMAND_COLLECT_DIAGNOSTICS
+func (riakNode *RiakNode) diagnostics(healthCheckErr error) *common.Diagnostics {
+	diagnostics := &common.Diagnostics{
+		TaskID:          riakNode.taskInfo.GetTaskId().GetValue(),
+		NodeName:        riakNode.taskData.FullyQualifiedNodeName,
+		ConfigRevision:  riakNode.taskData.ConfigRevision,
+		InPlaceRestarts: riakNode.inPlaceRestarts,
+		Healthy:         healthCheckErr == nil,
+	}
+	if healthCheckErr != nil {
+		diagnostics.HealthCheck = healthCheckErr.Error()
+	}
+	riakConfig, _ := ioutil.ReadFile("root/riak/etc/riak.conf")
+	diagnostics.RiakConfig = string(riakConfig)
+	advancedConfig, _ := ioutil.ReadFile("root/riak/etc/advanced.config")
+	diagnostics.AdvancedConfig = string(advancedConfig)
+	return diagnostics
+}
+
+func diskUsage(path string) (*common.DiskUsage, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+	usage := &common.DiskUsage{
+		Path:       path,
+		TotalBytes: stat.Blocks * uint64(stat.Bsize),
+		FreeBytes:  stat.Bavail * uint64(stat.Bsize),
+	}
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			usage.UsedBytes = usage.UsedBytes + uint64(info.Size())
+		}
+		return nil
+	})
+	return usage, err
+}
+
+func messageTimeout(message common.FrameworkMessage, defaultTimeout time.Duration) time.Duration {
+	if message.TimeoutSeconds <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(message.TimeoutSeconds) * time.Second
+}
+
+// collectDebugBundle has riak-debug collect the node's logs, configs and state into RIAK_DEBUG_BUNDLE, in a dir of
+// the chroot dir riak-debug can write to however it's isolated
+func collectDebugBundle(processSpec process_manager.ProcessSpec, timeout time.Duration) (*common.DebugBundle, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	chroot := filepath.Join(wd, "root")
+	if err := os.MkdirAll(filepath.Join(chroot, RIAK_DEBUG_DIR), 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(chroot, RIAK_DEBUG_DIR, RIAK_DEBUG_BUNDLE)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scriptPath := path
+	if processSpec.Chroot {
+		scriptPath = filepath.Join(RIAK_DEBUG_DIR, RIAK_DEBUG_BUNDLE)
+	}
+	output, err := runRiakScript(processSpec, "riak-debug", []string{scriptPath}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("riak-debug exited with status %d without a bundle: %s", output.ExitCode, output.Stderr)
+	}
+	return &common.DebugBundle{Path: path, Size: info.Size(), Output: *output}, nil
+}
+
+// scriptProcessSpec is how Riak's scripts are run, as the same user and isolated the same way as Riak
+func (riakNode *RiakNode) scriptProcessSpec() process_manager.ProcessSpec {
+	return riakProcessSpec(riakNode.taskInfo.GetTaskId().GetValue(), riakNode.taskData.ProcessSpec)
+}
+
+// runRiakScript runs one of Riak's scripts with the args and the environment, user and isolation Riak runs with,
+// killing it if it doesn't finish within the timeout. A non-zero exit status isn't an error, it's part of the output.
+func runRiakScript(processSpec process_manager.ProcessSpec, script string, args []string, timeout time.Duration) (*common.CommandOutput, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	chroot := filepath.Join(wd, "root")
+	procattr, err := process_manager.ProcAttributes(chroot, processSpec)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(process_manager.ChrootPath(chroot, processSpec, filepath.Join("/riak/bin", script)), args...)
+	cmd.Env = procattr.Env
+	cmd.Dir = procattr.Dir
+	// In a process group of its own, so everything the script started can be killed with it
+	cmd.SysProcAttr = procattr.Sys
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return nil, fmt.Errorf("%s didn't finish within %v", script, timeout)
+	}
+
+	output := &common.CommandOutput{Stdout: stdout.String(), Stderr: stderr.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		output.ExitCode = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+	} else if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFinishMessage(t *testing.T) {
+	assert := assert.New(t)
+	executor := newTestRiakNode(newFakeExecutorDriver()).executor
+
+	// What the scheduler sends to finish a node's task
+	executor.FrameworkMessage(nil, common.COMMAND_FINISH)
+	select {
+	case message := <-executor.riakNode.finishChan:
+		assert.Equal(common.COMMAND_FINISH, message.Command)
+		assert.Equal("", message.RequestID)
+	default:
+		t.Fatal("Riak wasn't asked to finish")
+	}
+
+	message, _ := (&common.FrameworkMessage{Version: common.FRAMEWORK_MESSAGE_VERSION, Command: common.COMMAND_FINISH}).Serialize()
+	executor.FrameworkMessage(nil, message)
+	assert.Equal(1, len(executor.riakNode.finishChan))
+}
+
+// fakeRiakScript puts a script at root/riak/bin in the test's dir
+func fakeRiakScript(t *testing.T, script string, body string) {
+	assert.Nil(t, os.MkdirAll(filepath.Join("root", "riak", "bin"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join("root", "riak", "bin", script), []byte("#!/bin/sh\n"+body), 0755))
+}
+
+func TestRunRiakScript(t *testing.T) {
+	assert := assert.New(t)
+	defer chdirTemp(t)()
+
+	fakeRiakScript(t, "riak-admin", "echo \"$@\"\necho failed >&2\nexit 3\n")
+	output, err := runRiakScript(process_manager.ProcessSpec{}, "riak-admin", []string{"cluster", "status"}, time.Minute)
+	assert.Nil(err)
+	assert.Equal(&common.CommandOutput{Stdout: "cluster status\n", Stderr: "failed\n", ExitCode: 3}, output)
+
+	// Whatever the script started is killed with it
+	fakeRiakScript(t, "riak-admin", "sleep 60 &\nsleep 60\n")
+	started := time.Now()
+	_, err = runRiakScript(process_manager.ProcessSpec{}, "riak-admin", []string{"status"}, 100*time.Millisecond)
+	assert.EqualError(err, "riak-admin didn't finish within 100ms")
+	assert.True(time.Since(started) < 10*time.Second)
+
+	_, err = runRiakScript(process_manager.ProcessSpec{}, "riak-missing", nil, time.Minute)
+	assert.NotNil(err)
+}
+
+func TestRunRiakAdminMessage(t *testing.T) {
+	assert := assert.New(t)
+	defer chdirTemp(t)()
+	driver := newFakeExecutorDriver()
+	riakNode := newTestRiakNode(driver)
+	fakeRiakScript(t, "riak-admin", "touch ran\necho \"$@\"\n")
+
+	// Subcommands which aren't allowed are refused without running riak-admin
+	for i, args := range [][]string{{}, {"leave"}, {"cluster", "commit"}, {"transfer-limit", "4"}} {
+		requestID := fmt.Sprintf("refused-%d", i)
+		riakNode.handleMessage(common.FrameworkMessage{RequestID: requestID, Command: common.COMMAND_RUN_RIAK_ADMIN, Args: args})
+		reply := driver.waitForReply(t, requestID)
+		assert.NotEqual("", reply.Error, args)
+	}
+	_, err := os.Stat("ran")
+	assert.True(os.IsNotExist(err))
+
+	riakNode.handleMessage(common.FrameworkMessage{RequestID: "allowed", Command: common.COMMAND_RUN_RIAK_ADMIN, Args: []string{"member-status"}})
+	reply := driver.waitForReply(t, "allowed")
+	assert.Equal("", reply.Error)
+	output := &common.CommandOutput{}
+	assert.Nil(json.Unmarshal(reply.Result, output))
+	assert.Equal("member-status\n", output.Stdout)
+
+	// The scheduler's timeout replaces the default
+	fakeRiakScript(t, "riak-admin", "sleep 60\n")
+	riakNode.handleMessage(common.FrameworkMessage{RequestID: "slow", Command: common.COMMAND_RUN_RIAK_ADMIN, Args: []string{"status"}, TimeoutSeconds: 1})
+	reply = driver.waitForReply(t, "slow")
+	assert.Equal("riak-admin didn't finish within 1s", reply.Error)
+}
+
+func TestMessageTimeout(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(RIAK_ADMIN_TIMEOUT, messageTimeout(common.FrameworkMessage{}, RIAK_ADMIN_TIMEOUT))
+	assert.Equal(90*time.Second, messageTimeout(common.FrameworkMessage{TimeoutSeconds: 90}, RIAK_ADMIN_TIMEOUT))
+}
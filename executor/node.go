@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,9 +11,8 @@ import (
 	"text/template"
 	"time"
 
-	"bytes"
-	"errors"
 	log "github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	"github.com/basho-labs/riak-mesos/cepmd/cepm"
 	"github.com/basho-labs/riak-mesos/common"
@@ -24,24 +25,23 @@ type RiakNode struct {
 	executor        *ExecutorCore
 	taskInfo        *mesos.TaskInfo
 	generation      uint64
-	finishChan      chan interface{}
+	finishChan      chan common.FrameworkMessage
 	running         bool
 	metadataManager *metamgr.MetadataManager
 	taskData        common.TaskData
 	pm              *process_manager.ProcessManager
 	killStatus      *mesos.TaskStatus
-}
-
-type templateData struct {
-	HTTPPort               int64
-	PBPort                 int64
-	HandoffPort            int64
-	FullyQualifiedNodeName string
-	DisterlPort            int64
-}
-
-type advancedTemplateData struct {
-	CEPMDPort int
+	// How Riak is started, again when it's restarted in place
+	processArgs []string
+	// Sent along with every TASK_RUNNING update
+	statusData []byte
+	// Restarts the scheduler asked for, with the TaskData Riak is to be restarted with in this task
+	restartChan     chan common.FrameworkMessage
+	inPlaceRestarts int64
+	cepmdPort       int
+	// Framework messages runLoop answers
+	requestChan chan common.FrameworkMessage
+	logShipper  *logShipper
 }
 
 func NewRiakNode(taskInfo *mesos.TaskInfo, executor *ExecutorCore) *RiakNode {
@@ -65,6 +65,9 @@ func NewRiakNode(taskInfo *mesos.TaskInfo, executor *ExecutorCore) *RiakNode {
 		metadataManager: mgr,
 		taskData:        taskData,
 		killStatus:      killStatus,
+		restartChan:     make(chan common.FrameworkMessage, 1),
+		finishChan:      make(chan common.FrameworkMessage, 1),
+		requestChan:     make(chan common.FrameworkMessage, 4),
 	}
 }
 
@@ -73,37 +76,115 @@ func (riakNode *RiakNode) runLoop(child *metamgr.ZkNode) {
 	var runStatus *mesos.TaskStatus
 	var err error
 
-	// runStatus := &mesos.TaskStatus{
-	// 	TaskId: riakNode.taskInfo.GetTaskId(),
-	// 	State:  mesos.TaskState_TASK_RUNNING.Enum(),
-	// }
-	// _, err := riakNode.executor.Driver.SendStatusUpdate(runStatus)
-	// if err != nil {
-	// 	log.Panic("Got error", err)
-	// }
+	healthCheck := riakHealthCheck(riakNode.taskData.HTTPPort)
+	health := newHealthTracker(healthCheckMaxFailures(riakNode.taskData.HealthCheck))
+	failureAction := healthCheckFailureAction(riakNode.taskData.HealthCheck)
+	ticker := time.NewTicker(healthCheckInterval(riakNode.taskData.HealthCheck))
+	defer func() { ticker.Stop() }()
 
 	waitChan := riakNode.pm.Listen()
-	select {
-	case <-waitChan:
-		{
-			log.Infof("Riak Died, finishing with status: %+v", riakNode.killStatus)
-			_, err = riakNode.executor.Driver.SendStatusUpdate(riakNode.killStatus)
-			if err != nil {
-				log.Panic("Got error", err)
+loop:
+	for {
+		select {
+		case <-waitChan:
+			{
+				if stoppedBy := riakNode.pm.StoppedBy(); stoppedBy != "" {
+					riakNode.killStatus.Message = proto.String(stopMessage(riakNode.killStatus.GetMessage(), stoppedBy))
+				}
+				log.Infof("Riak Died, finishing with status: %+v", riakNode.killStatus)
+				_, err = riakNode.executor.Driver.SendStatusUpdate(riakNode.killStatus)
+				if err != nil {
+					log.Panic("Got error", err)
+				}
+				break loop
 			}
-		}
-	case <-riakNode.finishChan:
-		{
-			log.Info("Finish channel says to shut down Riak")
-			riakNode.pm.TearDown()
-			runStatus = &mesos.TaskStatus{
-				TaskId: riakNode.taskInfo.GetTaskId(),
-				State:  mesos.TaskState_TASK_FINISHED.Enum(),
+		case message := <-riakNode.finishChan:
+			{
+				log.Info("Finish channel says to shut down Riak")
+				riakNode.pm.TearDown()
+				runStatus = &mesos.TaskStatus{
+					TaskId:  riakNode.taskInfo.GetTaskId(),
+					State:   mesos.TaskState_TASK_FINISHED.Enum(),
+					Message: proto.String(stopMessage("", riakNode.pm.StoppedBy())),
+				}
+				riakNode.killStatus = runStatus
+				_, err = riakNode.executor.Driver.SendStatusUpdate(riakNode.killStatus)
+				if err != nil {
+					log.Panic("Got error", err)
+				}
+				riakNode.reply(message, nil, nil)
+				break loop
 			}
-			riakNode.killStatus = runStatus
-			_, err = riakNode.executor.Driver.SendStatusUpdate(riakNode.killStatus)
-			if err != nil {
-				log.Panic("Got error", err)
+		case <-ticker.C:
+			{
+				checkErr := healthCheck()
+				changed, exceeded := health.record(checkErr)
+				if changed {
+					riakNode.sendHealth(checkErr)
+				}
+				if !exceeded {
+					if checkErr != nil {
+						log.Warnf("Riak failed %d health checks in a row: %v", health.failures, checkErr)
+					}
+					continue
+				}
+				message := fmt.Sprintf("Riak failed %d health checks in a row: %v", health.maxFailures, checkErr)
+				if failureAction == common.HEALTH_CHECK_ACTION_FAIL {
+					log.Error(message, ", failing the task")
+					// The process manager tells waitChan once Riak is down
+					riakNode.killStatus = &mesos.TaskStatus{
+						TaskId:  riakNode.taskInfo.GetTaskId(),
+						State:   mesos.TaskState_TASK_FAILED.Enum(),
+						Message: proto.String(message),
+					}
+					riakNode.pm.TearDown()
+					continue
+				}
+				log.Info(message, ", restarting it")
+				if !riakNode.restartProcess(message) {
+					break loop
+				}
+				waitChan = riakNode.pm.Listen()
+				health.reset()
+			}
+		case message := <-riakNode.restartChan:
+			{
+				log.Info("Scheduler asked for Riak to be restarted with config revision ", message.TaskData.ConfigRevision)
+				// Riak keeps running as it was if it can't be configured, the scheduler relaunches the task instead
+				if _, err := riakNode.configure(*message.TaskData, riakNode.cepmdPort); err != nil {
+					riakNode.reply(message, nil, err)
+					continue
+				}
+				riakNode.reply(message, nil, nil)
+				riakNode.taskData = *message.TaskData
+				riakNode.logShipper.Configure(riakNode.taskData.LogShipping)
+				riakNode.inPlaceRestarts = riakNode.inPlaceRestarts + 1
+				riakNode.statusData = riakNode.serializeStatusData()
+				if !riakNode.restartProcess("Restart asked for by the scheduler") {
+					break loop
+				}
+				waitChan = riakNode.pm.Listen()
+				// Picks up the restarted node's health check settings
+				healthCheck = riakHealthCheck(riakNode.taskData.HTTPPort)
+				health = newHealthTracker(healthCheckMaxFailures(riakNode.taskData.HealthCheck))
+				failureAction = healthCheckFailureAction(riakNode.taskData.HealthCheck)
+				ticker.Stop()
+				ticker = time.NewTicker(healthCheckInterval(riakNode.taskData.HealthCheck))
+			}
+		case message := <-riakNode.requestChan:
+			{
+				switch message.Command {
+				case common.COMMAND_RELOAD_CONFIG:
+					log.Info("Scheduler asked for the configs of revision ", message.TaskData.ConfigRevision)
+					if _, err := riakNode.configure(*message.TaskData, riakNode.cepmdPort); err != nil {
+						riakNode.reply(message, nil, err)
+						continue
+					}
+					riakNode.taskData = *message.TaskData
+					riakNode.reply(message, nil, nil)
+				case common.COMMAND_COLLECT_DIAGNOSTICS:
+					riakNode.reply(message, riakNode.diagnostics(health.lastErr), nil)
+				}
 			}
 		}
 	}
@@ -113,90 +194,183 @@ func (riakNode *RiakNode) runLoop(child *metamgr.ZkNode) {
 	riakNode.executor.Driver.Stop()
 
 }
-func (riakNode *RiakNode) configureRiak(taskData common.TaskData) templateData {
 
-	fetchURI := fmt.Sprintf("%s/api/v1/clusters/%s/config", riakNode.taskData.URI, riakNode.taskData.ClusterName)
-	resp, err := http.Get(fetchURI)
+// restartProcess stops Riak and starts it again in this task, then tells the scheduler it's running. If it doesn't
+// come back, the task fails with the reason it was restarted and returns false.
+func (riakNode *RiakNode) restartProcess(reason string) bool {
+	riakNode.pm.TearDown()
+	if err := riakNode.startProcess(); err != nil {
+		log.Error("Could not restart Riak: ", err)
+		riakNode.killStatus = &mesos.TaskStatus{
+			TaskId:  riakNode.taskInfo.GetTaskId(),
+			State:   mesos.TaskState_TASK_FAILED.Enum(),
+			Message: proto.String(fmt.Sprintf("%s, then failed to restart: %v", reason, err)),
+		}
+		_, err = riakNode.executor.Driver.SendStatusUpdate(riakNode.killStatus)
+		if err != nil {
+			log.Panic("Got error", err)
+		}
+		return false
+	}
+	riakNode.sendHealth(nil)
+	return true
+}
+
+// RestartInPlace has runLoop restart Riak with the message's TaskData, and reply once Riak was configured with it,
+// unless a restart is already waiting
+func (riakNode *RiakNode) RestartInPlace(message common.FrameworkMessage) {
+	select {
+	case riakNode.restartChan <- message:
+	default:
+		riakNode.reply(message, nil, errors.New("Riak is already waiting to be restarted"))
+	}
+}
+
+// sendHealth tells the scheduler whether Riak passed its last health check, and why not if it didn't
+func (riakNode *RiakNode) sendHealth(checkErr error) {
+	_, err := riakNode.executor.Driver.SendStatusUpdate(riakNode.healthStatus(checkErr))
 	if err != nil {
-		log.Error("Unable to fetch config: ", err)
+		log.Panic("Got error", err)
 	}
-	config, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+}
+
+func (riakNode *RiakNode) healthStatus(checkErr error) *mesos.TaskStatus {
+	runStatus := &mesos.TaskStatus{
+		TaskId:  riakNode.taskInfo.GetTaskId(),
+		State:   mesos.TaskState_TASK_RUNNING.Enum(),
+		Data:    riakNode.statusData,
+		Healthy: proto.Bool(checkErr == nil),
+	}
+	if checkErr != nil {
+		runStatus.Message = proto.String(checkErr.Error())
+	}
+	return runStatus
+}
+
+// configure renders riak.conf and advanced.config for the taskData, and only writes them if both could be rendered
+func (riakNode *RiakNode) configure(taskData common.TaskData, cepmdPort int) (common.RiakConfigTemplateData, error) {
+	riakConfig, vars, err := riakNode.renderRiakConfig(taskData)
 	if err != nil {
-		log.Error("Unable to fetch config: ", err)
+		return vars, err
+	}
+	advancedConfig, err := riakNode.renderAdvancedConfig(taskData, cepmdPort)
+	if err != nil {
+		return vars, err
+	}
+	if err := ioutil.WriteFile("root/riak/etc/riak.conf", riakConfig, 0664); err != nil {
+		return vars, fmt.Errorf("Unable to write riak.conf: %v", err)
 	}
+	if err := ioutil.WriteFile("root/riak/etc/advanced.config", advancedConfig, 0664); err != nil {
+		return vars, fmt.Errorf("Unable to write advanced.config: %v", err)
+	}
+	return vars, nil
+}
 
-	tmpl, err := template.New("config").Parse(string(config))
+func (riakNode *RiakNode) renderRiakConfig(taskData common.TaskData) ([]byte, common.RiakConfigTemplateData, error) {
+	vars := common.RiakConfigTemplateData{}
 
+	// The node's riak.conf, with the settings of the cluster and the node merged in
+	fetchURI := fmt.Sprintf("%s/api/v1/clusters/%s/nodes/%s/config", taskData.URI, taskData.ClusterName, riakNode.taskInfo.TaskId.GetValue())
+	if taskData.ConfigRevision != 0 {
+		fetchURI = fmt.Sprintf("%s?revision=%d", fetchURI, taskData.ConfigRevision)
+	}
+	tmpl, err := fetchConfigTemplate("riak.conf", fetchURI)
 	if err != nil {
-		log.Panic(err)
+		return nil, vars, err
 	}
 
 	// Populate template data from the MesosTask
-	vars := templateData{}
-	vars.FullyQualifiedNodeName = riakNode.taskData.FullyQualifiedNodeName
+	vars.TaskTemplateData = riakNode.taskTemplateData(taskData)
+	vars.FullyQualifiedNodeName = taskData.FullyQualifiedNodeName
 
 	vars.HTTPPort = taskData.HTTPPort
 	vars.PBPort = taskData.PBPort
 	vars.HandoffPort = taskData.HandoffPort
 	vars.DisterlPort = taskData.DisterlPort
+	vars.Ports = taskData.NamedPorts
 
-	file, err := os.OpenFile("root/riak/etc/riak.conf", os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0664)
+	config := &bytes.Buffer{}
+	if err := tmpl.Execute(config, vars); err != nil {
+		return nil, vars, fmt.Errorf("Unable to render riak.conf: %v", err)
+	}
+	return config.Bytes(), vars, nil
+}
 
-	defer file.Close()
+func (riakNode *RiakNode) renderAdvancedConfig(taskData common.TaskData, cepmdPort int) ([]byte, error) {
+	fetchURI := fmt.Sprintf("%s/api/v1/clusters/%s/advancedConfig", taskData.URI, taskData.ClusterName)
+	if taskData.ConfigRevision != 0 {
+		fetchURI = fmt.Sprintf("%s?revision=%d", fetchURI, taskData.ConfigRevision)
+	}
+	tmpl, err := fetchConfigTemplate("advanced.config", fetchURI)
 	if err != nil {
-		log.Panic("Unable to open file: ", err)
+		return nil, err
 	}
 
-	err = tmpl.Execute(file, vars)
+	// Populate template data from the MesosTask
+	vars := common.AdvancedConfigTemplateData{}
+	vars.TaskTemplateData = riakNode.taskTemplateData(taskData)
+	vars.CEPMDPort = cepmdPort
+	vars.Ports = taskData.NamedPorts
 
-	if err != nil {
-		log.Panic("Got error", err)
+	config := &bytes.Buffer{}
+	if err := tmpl.Execute(config, vars); err != nil {
+		return nil, fmt.Errorf("Unable to render advanced.config: %v", err)
 	}
-	return vars
+	return config.Bytes(), nil
 }
-func (riakNode *RiakNode) configureAdvanced(cepmdPort int) {
 
-	fetchURI := fmt.Sprintf("%s/api/v1/clusters/%s/advancedConfig", riakNode.taskData.URI, riakNode.taskData.ClusterName)
+// fetchConfigTemplate gets a config template from the scheduler
+func fetchConfigTemplate(name string, fetchURI string) (*template.Template, error) {
 	resp, err := http.Get(fetchURI)
 	if err != nil {
-		log.Error("Unable to fetch advanced config: ", err)
+		return nil, fmt.Errorf("Unable to fetch %s: %v", name, err)
 	}
-	advancedConfig, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	defer resp.Body.Close()
+	config, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Error("Unable to fetch advanced config: ", err)
+		return nil, fmt.Errorf("Unable to fetch %s: %v", name, err)
 	}
-
-	tmpl, err := template.New("advanced").Parse(string(advancedConfig))
-
-	if err != nil {
-		log.Panic(err)
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Unable to fetch %s, the scheduler returned %d: %s", name, resp.StatusCode, bytes.TrimSpace(config))
 	}
-
-	// Populate template data from the MesosTask
-	vars := advancedTemplateData{}
-	vars.CEPMDPort = cepmdPort
-	file, err := os.OpenFile("root/riak/etc/advanced.config", os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0664)
-
-	defer file.Close()
+	tmpl, err := template.New(name).Parse(string(config))
 	if err != nil {
-		log.Panic("Unable to open file: ", err)
+		return nil, fmt.Errorf("Unable to parse %s: %v", name, err)
 	}
+	return tmpl, nil
+}
 
-	err = tmpl.Execute(file, vars)
-
+// taskTemplateData is what both config templates are told about the task, besides its ports
+func (riakNode *RiakNode) taskTemplateData(taskData common.TaskData) common.TaskTemplateData {
+	wd, err := os.Getwd()
 	if err != nil {
-		log.Panic("Got error", err)
+		log.Panic("Could not get wd: ", err)
+	}
+	return common.TaskTemplateData{
+		Cpus:          taskData.Cpus,
+		Mem:           taskData.Mem,
+		Disk:          taskData.Disk,
+		DataDir:       filepath.Join(wd, "root", "riak", "data"),
+		Hostname:      taskData.Host,
+		ClusterName:   taskData.ClusterName,
+		FrameworkName: taskData.FrameworkName,
+		Attributes:    taskData.Attributes,
+		Vars:          taskData.Variables,
 	}
 }
 
-func (riakNode *RiakNode) setCoordinatedData(child *metamgr.ZkNode, config templateData) {
+func (riakNode *RiakNode) setCoordinatedData(child *metamgr.ZkNode, config common.RiakConfigTemplateData) {
+	namedPorts := make(map[string]int)
+	for name, port := range config.Ports {
+		namedPorts[name] = int(port)
+	}
 	coordinatedData := common.CoordinatedData{
 		NodeName:      riakNode.taskData.FullyQualifiedNodeName,
 		DisterlPort:   int(config.DisterlPort),
 		PBPort:        int(config.PBPort),
 		HTTPPort:      int(config.HTTPPort),
+		HandoffPort:   int(config.HandoffPort),
+		NamedPorts:    namedPorts,
 		Hostname:      riakNode.taskData.Host,
 		ClusterName:   riakNode.taskData.ClusterName,
 		FrameworkName: riakNode.taskData.FrameworkName,
@@ -232,11 +406,13 @@ func (riakNode *RiakNode) getCoordinatedChild() *metamgr.ZkNode {
 func (riakNode *RiakNode) Run() {
 	var err error
 
-	config := riakNode.configureRiak(riakNode.taskData)
-
 	c := cepm.NewCPMd(0, riakNode.metadataManager)
 	c.Background()
-	riakNode.configureAdvanced(c.GetPort())
+	riakNode.cepmdPort = c.GetPort()
+	config, err := riakNode.configure(riakNode.taskData, riakNode.cepmdPort)
+	if err != nil {
+		log.Panic("Unable to configure Riak: ", err)
+	}
 
 	args := []string{"console", "-noinput"}
 
@@ -258,33 +434,21 @@ func (riakNode *RiakNode) Run() {
 	os.MkdirAll(fmt.Sprint(kernelDirs[0], "/priv"), 0777)
 	ioutil.WriteFile(fmt.Sprint(kernelDirs[0], "/priv/cepmd_port"), []byte(fmt.Sprintf("%d.", c.GetPort())), 0777)
 
-	HealthCheckFun := func() error {
-		log.Info("Checking is Riak is started")
-		data, err := ioutil.ReadFile("root/riak/log/console.log")
-		if err != nil {
-			if bytes.Contains(data, []byte("Wait complete for service riak_kv")) {
-				log.Info("Riak started, waiting 10 seconds to avoid race conditions (HACK)")
-				time.Sleep(10 * time.Second)
-				return nil
-			}
-			return errors.New("Riak KV not yet started")
-		}
-		return err
-	}
+	// Ships Riak's startup too
+	riakNode.logShipper = newLogShipper(riakNode.taskData.FullyQualifiedNodeName, RIAK_LOG_DIR)
+	riakNode.logShipper.Configure(riakNode.taskData.LogShipping)
+	go riakNode.logShipper.loop()
 
-	wd, err := os.Getwd()
-	if err != nil {
-		log.Panic("Could not get wd: ", err)
-	}
-	chroot := filepath.Join(wd, "root")
-	riakNode.pm, err = process_manager.NewProcessManager(func() { return }, "/riak/bin/riak", args, HealthCheckFun, &chroot, riakNode.taskData.UseSuperChroot)
+	riakNode.processArgs = args
+	err = riakNode.startProcess()
 
 	if err != nil {
 		log.Error("Could not start Riak: ", err)
 
 		runStatus := &mesos.TaskStatus{
-			TaskId: riakNode.taskInfo.GetTaskId(),
-			State:  mesos.TaskState_TASK_FAILED.Enum(),
+			TaskId:  riakNode.taskInfo.GetTaskId(),
+			State:   mesos.TaskState_TASK_FAILED.Enum(),
+			Message: proto.String(fmt.Sprintf("Riak failed its health check: %v", err)),
 		}
 		_, err = riakNode.executor.Driver.SendStatusUpdate(runStatus)
 		if err != nil {
@@ -298,20 +462,13 @@ func (riakNode *RiakNode) Run() {
 		child := riakNode.getCoordinatedChild()
 		riakNode.setCoordinatedData(child, config)
 
-		rexPort := riakNode.taskData.HTTPPort
-		tsd := common.TaskStatusData{
-			RexPort: rexPort,
-		}
-		tsdBytes, err := tsd.Serialize()
-
-		if err != nil {
-			log.Panic("Could not serialize Riak Explorer data", err)
-		}
+		riakNode.statusData = riakNode.serializeStatusData()
 
 		runStatus := &mesos.TaskStatus{
-			TaskId: riakNode.taskInfo.GetTaskId(),
-			State:  mesos.TaskState_TASK_RUNNING.Enum(),
-			Data:   tsdBytes,
+			TaskId:  riakNode.taskInfo.GetTaskId(),
+			State:   mesos.TaskState_TASK_RUNNING.Enum(),
+			Data:    riakNode.statusData,
+			Healthy: proto.Bool(true),
 		}
 		_, err = riakNode.executor.Driver.SendStatusUpdate(runStatus)
 		if err != nil {
@@ -322,6 +479,33 @@ func (riakNode *RiakNode) Run() {
 	}
 }
 
+func (riakNode *RiakNode) serializeStatusData() []byte {
+	tsd := common.TaskStatusData{
+		RexPort:         riakNode.taskData.HTTPPort,
+		InPlaceRestarts: riakNode.inPlaceRestarts,
+	}
+	tsdBytes, err := tsd.Serialize()
+	if err != nil {
+		log.Panic("Could not serialize Riak Explorer data", err)
+	}
+	return tsdBytes
+}
+
+// startProcess starts Riak and waits for it to pass its health check
+func (riakNode *RiakNode) startProcess() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Panic("Could not get wd: ", err)
+	}
+	chroot := filepath.Join(wd, "root")
+	healthCheck := riakHealthCheck(riakNode.taskData.HTTPPort)
+	startTimeout := healthCheckStartTimeout(riakNode.taskData.HealthCheck)
+	stopSequence := riakStopSequence(riakNode.taskData.StopSequence)
+	processSpec := riakProcessSpec(riakNode.taskInfo.GetTaskId().GetValue(), riakNode.taskData.ProcessSpec)
+	riakNode.pm, err = process_manager.NewProcessManager(func() { return }, "/riak/bin/riak", riakNode.processArgs, healthCheck, startTimeout, stopSequence, &chroot, processSpec)
+	return err
+}
+
 func (riakNode *RiakNode) next() {
 	riakNode.executor.lock.Lock()
 	defer riakNode.executor.lock.Unlock()
@@ -339,16 +523,12 @@ func (riakNode *RiakNode) next() {
 	riakNode.generation = riakNode.generation + 1
 }
 
-func (riakNode *RiakNode) finish() {
-	riakNode.finishChan <- nil
-}
-
-func (riakNode *RiakNode) ForceFinish() {
-	log.Info("Force finishing Riak")
-	runStatus := &mesos.TaskStatus{
-		TaskId: riakNode.taskInfo.GetTaskId(),
-		State:  mesos.TaskState_TASK_FINISHED.Enum(),
+// finish has runLoop stop Riak and finish the task, then reply to the message, unless it's already finishing. Only
+// runLoop touches the process manager, which restarts replace.
+func (riakNode *RiakNode) finish(message common.FrameworkMessage) {
+	select {
+	case riakNode.finishChan <- message:
+	default:
+		riakNode.reply(message, nil, errors.New("Riak is already finishing"))
 	}
-	riakNode.killStatus = runStatus
-	riakNode.pm.TearDown()
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeConfigServer serves the config templates like the scheduler does, for revision 2 of cluster default
+type fakeConfigServer struct {
+	riakConfig     string
+	advancedConfig string
+}
+
+func (server *fakeConfigServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("revision") != "2" {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Config revision %s not found for cluster default", r.URL.Query().Get("revision"))
+		return
+	}
+	switch r.URL.Path {
+	case "/api/v1/clusters/default/nodes/riak-default-1/config":
+		fmt.Fprint(w, server.riakConfig)
+	case "/api/v1/clusters/default/advancedConfig":
+		fmt.Fprint(w, server.advancedConfig)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+// chdirTemp runs the test in a dir with the task's root/riak/etc, returning the function which changes back
+func chdirTemp(t *testing.T) func() {
+	wd, _ := os.Getwd()
+	dir, err := ioutil.TempDir("", "riak-executor")
+	assert.Nil(t, err)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "root", "riak", "etc"), 0755))
+	assert.Nil(t, os.Chdir(dir))
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	assert := assert.New(t)
+	defer chdirTemp(t)()
+	configServer := &fakeConfigServer{
+		riakConfig:     "listener.http.internal = 0.0.0.0:{{.HTTPPort}}\nnodename = {{.FullyQualifiedNodeName}}\n",
+		advancedConfig: "[{riak_core, [{cepmd_port, {{.CEPMDPort}}}]}].\n",
+	}
+	server := httptest.NewServer(configServer)
+	defer server.Close()
+	riakNode := &RiakNode{taskInfo: &mesos.TaskInfo{TaskId: &mesos.TaskID{Value: proto.String("riak-default-1")}}}
+	taskData := common.TaskData{
+		URI:                    server.URL,
+		ClusterName:            "default",
+		ConfigRevision:         2,
+		FullyQualifiedNodeName: "riak-default-1@agent1",
+		HTTPPort:               31000,
+	}
+
+	vars, err := riakNode.configure(taskData, 4369)
+	assert.Nil(err)
+	assert.Equal(int64(31000), vars.HTTPPort)
+	riakConfig, _ := ioutil.ReadFile("root/riak/etc/riak.conf")
+	assert.Equal("listener.http.internal = 0.0.0.0:31000\nnodename = riak-default-1@agent1\n", string(riakConfig))
+	advancedConfig, _ := ioutil.ReadFile("root/riak/etc/advanced.config")
+	assert.Equal("[{riak_core, [{cepmd_port, 4369}]}].\n", string(advancedConfig))
+
+	// Neither config is written unless both can be rendered
+	configServer.riakConfig = "ring_size = 128\n"
+	configServer.advancedConfig = "[{riak_core, [{cepmd_port, {{.CEPMDPort}]}].\n"
+	_, err = riakNode.configure(taskData, 4369)
+	assert.Contains(err.Error(), "Unable to parse advanced.config")
+	configServer.advancedConfig = "[{riak_core, [{cepmd_port, {{.Missing}}}]}].\n"
+	_, err = riakNode.configure(taskData, 4369)
+	assert.Contains(err.Error(), "Unable to render advanced.config")
+	riakConfig, _ = ioutil.ReadFile("root/riak/etc/riak.conf")
+	assert.Equal("listener.http.internal = 0.0.0.0:31000\nnodename = riak-default-1@agent1\n", string(riakConfig))
+
+	taskData.ConfigRevision = 3
+	_, err = riakNode.configure(taskData, 4369)
+	assert.Contains(err.Error(), "Unable to fetch riak.conf, the scheduler returned 404: Config revision 3 not found")
+
+	server.Close()
+	_, err = riakNode.configure(taskData, 4369)
+	assert.Contains(err.Error(), "Unable to fetch riak.conf")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"syscall"
+)
+
+// Where Riak, and riak-debug, write in the chroot dir. Only these are given to the process spec's user.
+var riakWritableDirs = []string{"/riak/data", "/riak/log", RIAK_DEBUG_DIR, "/tmp"}
+
+// riakProcessSpec is how Riak is run for the task, the process manager's defaults if the cluster has no process spec
+func riakProcessSpec(taskID string, processSpec *common.ProcessSpec) process_manager.ProcessSpec {
+	riakSpec := process_manager.ProcessSpec{}
+	if processSpec == nil {
+		return riakSpec
+	}
+	for resource, limit := range map[int]int64{
+		syscall.RLIMIT_NOFILE:          processSpec.NoFileLimit,
+		process_manager.RLIMIT_NPROC:   processSpec.NProcLimit,
+		process_manager.RLIMIT_MEMLOCK: processSpec.MemlockBytes,
+	} {
+		switch {
+		case limit == common.RLIMIT_UNLIMITED:
+			riakSpec.Rlimits = append(riakSpec.Rlimits, process_manager.Rlimit{Resource: resource, Limit: process_manager.RLIM_INFINITY})
+		case limit > 0:
+			riakSpec.Rlimits = append(riakSpec.Rlimits, process_manager.Rlimit{Resource: resource, Limit: uint64(limit)})
+		}
+	}
+	riakSpec.Chroot = processSpec.Isolation == common.PROCESS_ISOLATION_CHROOT
+	riakSpec.MountNamespace = processSpec.Isolation == common.PROCESS_ISOLATION_MOUNT_NAMESPACE
+	riakSpec.User = processSpec.User
+	riakSpec.WritableDirs = riakWritableDirs
+	riakSpec.OOMScoreAdj = processSpec.OOMScoreAdj
+	if processSpec.CgroupMemoryMaxBytes > 0 || processSpec.CgroupCPUs > 0 {
+		riakSpec.Cgroup = &process_manager.CgroupLimits{
+			Name:           taskID,
+			MemoryMaxBytes: processSpec.CgroupMemoryMaxBytes,
+			CPUs:           processSpec.CgroupCPUs,
+		}
+	}
+	return riakSpec
+}
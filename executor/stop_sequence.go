@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"time"
+)
+
+// Stop sequences of clusters which don't set their own. These are longer than the process manager's defaults, as
+// riak stop waits for leveldb to flush its memtables and close its files, which can take minutes on a busy node, and
+// SIGTERM has the Erlang VM shut down as cleanly as it can.
+const (
+	RIAK_STOP_COMMAND_TIMEOUT = 2 * time.Minute
+	RIAK_SIGTERM_TIMEOUT      = 30 * time.Second
+)
+
+// riakStopSequence stops Riak with riak stop, before it's sent any signals
+func riakStopSequence(stopSequence *common.StopSequence) process_manager.StopSequence {
+	riakStop := process_manager.StopSequence{
+		StopCommand:        []string{"/riak/bin/riak", "stop"},
+		StopCommandTimeout: RIAK_STOP_COMMAND_TIMEOUT,
+		SigtermTimeout:     RIAK_SIGTERM_TIMEOUT,
+	}
+	if stopSequence == nil {
+		return riakStop
+	}
+	if stopSequence.StopCommandTimeoutSeconds != 0 {
+		riakStop.StopCommandTimeout = time.Duration(stopSequence.StopCommandTimeoutSeconds) * time.Second
+	}
+	if stopSequence.SigtermTimeoutSeconds != 0 {
+		riakStop.SigtermTimeout = time.Duration(stopSequence.SigtermTimeoutSeconds) * time.Second
+	}
+	return riakStop
+}
+
+// stopMessage adds what stopped Riak to the message of the task's final status
+func stopMessage(message string, stoppedBy string) string {
+	switch stoppedBy {
+	case "":
+		return message
+	case process_manager.STOP_STAGE_EXITED:
+		stoppedBy = "Riak had already exited"
+	case process_manager.STOP_STAGE_COMMAND:
+		stoppedBy = "Riak stopped by riak stop"
+	default:
+		stoppedBy = "Riak stopped by " + stoppedBy
+	}
+	if message == "" {
+		return stoppedBy
+	}
+	return fmt.Sprintf("%s, %s", message, stoppedBy)
+}
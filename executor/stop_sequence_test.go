@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/process_manager"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRiakStopSequence(t *testing.T) {
+	assert := assert.New(t)
+	stopSequence := riakStopSequence(nil)
+	assert.Equal([]string{"/riak/bin/riak", "stop"}, stopSequence.StopCommand)
+	assert.Equal(RIAK_STOP_COMMAND_TIMEOUT, stopSequence.StopCommandTimeout)
+	assert.Equal(RIAK_SIGTERM_TIMEOUT, stopSequence.SigtermTimeout)
+	// Riak gets longer than other processes
+	assert.True(RIAK_STOP_COMMAND_TIMEOUT > process_manager.DEFAULT_STOP_COMMAND_TIMEOUT)
+	assert.True(RIAK_SIGTERM_TIMEOUT > process_manager.DEFAULT_SIGTERM_TIMEOUT)
+
+	stopSequence = riakStopSequence(&common.StopSequence{StopCommandTimeoutSeconds: 600})
+	assert.Equal(10*time.Minute, stopSequence.StopCommandTimeout)
+	assert.Equal(RIAK_SIGTERM_TIMEOUT, stopSequence.SigtermTimeout)
+
+	stopSequence = riakStopSequence(&common.StopSequence{SigtermTimeoutSeconds: 5})
+	assert.Equal(RIAK_STOP_COMMAND_TIMEOUT, stopSequence.StopCommandTimeout)
+	assert.Equal(5*time.Second, stopSequence.SigtermTimeout)
+}
+
+func TestStopMessage(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", stopMessage("", ""))
+	assert.Equal("Riak stopped by riak stop", stopMessage("", process_manager.STOP_STAGE_COMMAND))
+	assert.Equal("Riak had already exited", stopMessage("", process_manager.STOP_STAGE_EXITED))
+	assert.Equal("Riak failed 3 health checks in a row, Riak stopped by SIGKILL", stopMessage("Riak failed 3 health checks in a row", process_manager.STOP_STAGE_SIGKILL))
+}
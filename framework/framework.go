@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/basho-labs/riak-mesos/scheduler"
@@ -33,6 +34,12 @@ var (
 	mesosAuthPrincipal  string
 	mesosAuthSecretFile string
 	useReservations     bool
+	refuseSeconds       float64
+	idleRefuseSeconds   float64
+	placementStrategy   string
+	volumeGracePeriod   time.Duration
+	reservationGCDryRun bool
+	auditTrustedProxies string
 )
 
 func init() {
@@ -53,6 +60,12 @@ func init() {
 		fmt.Sprintf("Authentication provider to use, default is SASL that supports mechanisms: %+v", mech.ListSupported()))
 	flag.StringVar(&mesosAuthPrincipal, "mesos_authentication_principal", "", "Mesos authentication principal.")
 	flag.StringVar(&mesosAuthSecretFile, "mesos_authentication_secret_file", "", "Mesos authentication secret file.")
+	flag.Float64Var(&refuseSeconds, "refuse_seconds", scheduler.DEFAULT_REFUSE_SECONDS, "Seconds to refuse resources from an agent after declining an offer that no node can use")
+	flag.Float64Var(&idleRefuseSeconds, "idle_refuse_seconds", scheduler.DEFAULT_IDLE_REFUSE_SECONDS, "Seconds to refuse resources from an agent while no nodes need resources. This is a long decline filter, Mesos still offers the resources again once it expires.")
+	flag.StringVar(&placementStrategy, "placement_strategy", scheduler.PLACEMENT_SPREAD, "How new nodes are placed on agents: spread (across as many agents as possible) or pack (onto as few agents as possible)")
+	flag.DurationVar(&volumeGracePeriod, "volume_grace_period", scheduler.DEFAULT_VOLUME_GRACE_PERIOD, "How long a persistent volume which doesn't belong to any node is kept before it is destroyed, unless its cluster sets a volume retention policy")
+	flag.BoolVar(&reservationGCDryRun, "reservation_gc_dry_run", false, "Only report which reservations and volumes would be reclaimed (see /api/v1/reservations), don't reclaim them")
+	flag.StringVar(&auditTrustedProxies, "audit_trusted_proxies", "", "Comma separated IP addresses and CIDR blocks of authenticating proxies, whose X-Remote-User header is recorded as the actor in the audit log")
 	flag.Parse()
 }
 
@@ -85,6 +98,12 @@ func main() {
 		authProvider,
 		mesosAuthPrincipal,
 		mesosAuthSecretFile,
-		useReservations)
+		useReservations,
+		refuseSeconds,
+		idleRefuseSeconds,
+		placementStrategy,
+		volumeGracePeriod,
+		reservationGCDryRun,
+		auditTrustedProxies)
 	sched.Run(mesosMaster)
 }
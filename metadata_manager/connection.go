@@ -0,0 +1,50 @@
+package metadata_manager
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+	"time"
+)
+
+// ZkConnection is the subset of *zk.Conn that the MetadataManager relies on, so that
+// tests can swap in a FakeZkConnection instead of talking to a live Zookeeper
+type ZkConnection interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Exists(path string) (bool, *zk.Stat, error)
+	Delete(path string, version int32) error
+	NewLock(path string, acl []zk.ACL) ZkLock
+	State() zk.State
+	Close()
+}
+
+// ZkLock is satisfied by *zk.Lock
+type ZkLock interface {
+	Lock() error
+	Unlock() error
+}
+
+// ZkConnector opens a new connection to the given Zookeeper servers
+type ZkConnector func(zookeepers []string) (ZkConnection, error)
+
+type zkConnection struct {
+	*zk.Conn
+}
+
+func (conn *zkConnection) NewLock(path string, acl []zk.ACL) ZkLock {
+	return zk.NewLock(conn.Conn, path, acl)
+}
+
+// ConnectZookeeper is the ZkConnector used outside of tests
+func ConnectZookeeper(zookeepers []string) (ZkConnection, error) {
+	conn, _, err := zk.Connect(zookeepers, time.Second)
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, err
+	}
+	return &zkConnection{Conn: conn}, nil
+}
@@ -0,0 +1,362 @@
+package metadata_manager
+
+import (
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeZkConnection is an in-memory stand in for a Zookeeper ensemble. It implements enough of
+// ZkConnection (versions, ephemeral and sequential nodes, child watches and locks) to run
+// the MetadataManager, CEPMd and the scheduler without a live Zookeeper.
+type FakeZkConnection struct {
+	lock      *sync.Mutex
+	nodes     map[string]*fakeZkNode
+	watchers  map[string][]chan zk.Event
+	locks     map[string]chan struct{}
+	zxid      int64
+	sessionID int64
+	closed    bool
+	// Deletes fail with zk.ErrConnectionClosed while this is positive, each one counting it down
+	failingDeletes int
+}
+
+type fakeZkNode struct {
+	data      []byte
+	stat      zk.Stat
+	sequence  int64
+	ephemeral bool
+}
+
+func NewFakeZkConnection() *FakeZkConnection {
+	conn := &FakeZkConnection{
+		lock:      &sync.Mutex{},
+		nodes:     make(map[string]*fakeZkNode),
+		watchers:  make(map[string][]chan zk.Event),
+		locks:     make(map[string]chan struct{}),
+		sessionID: 1,
+	}
+	conn.nodes["/"] = &fakeZkNode{}
+	return conn
+}
+
+// Connector returns a ZkConnector which always hands out this connection, reopening
+// it (with a new session) if it was closed
+func (conn *FakeZkConnection) Connector() ZkConnector {
+	return func(_ []string) (ZkConnection, error) {
+		conn.lock.Lock()
+		defer conn.lock.Unlock()
+		if conn.closed {
+			conn.closed = false
+			conn.sessionID = conn.sessionID + 1
+		}
+		return conn, nil
+	}
+}
+
+func (conn *FakeZkConnection) Create(zkPath string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return "", zk.ErrClosing
+	}
+
+	parentPath := path.Dir(zkPath)
+	parent, assigned := conn.nodes[parentPath]
+	if !assigned {
+		return "", zk.ErrNoNode
+	}
+	if parent.ephemeral {
+		return "", zk.ErrNoChildrenForEphemerals
+	}
+
+	if flags&zk.FlagSequence != 0 {
+		zkPath = fmt.Sprintf("%s%010d", zkPath, parent.sequence)
+		parent.sequence = parent.sequence + 1
+	}
+	if _, exists := conn.nodes[zkPath]; exists {
+		return "", zk.ErrNodeExists
+	}
+
+	conn.zxid = conn.zxid + 1
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	node := &fakeZkNode{
+		data:      copyBytes(data),
+		ephemeral: flags&zk.FlagEphemeral != 0,
+		stat: zk.Stat{
+			Czxid:      conn.zxid,
+			Mzxid:      conn.zxid,
+			Pzxid:      conn.zxid,
+			Ctime:      now,
+			Mtime:      now,
+			DataLength: int32(len(data)),
+		},
+	}
+	if node.ephemeral {
+		node.stat.EphemeralOwner = conn.sessionID
+	}
+	conn.nodes[zkPath] = node
+
+	parent.stat.Cversion = parent.stat.Cversion + 1
+	parent.stat.NumChildren = parent.stat.NumChildren + 1
+	parent.stat.Pzxid = conn.zxid
+	conn.fire(parentPath, zk.EventNodeChildrenChanged)
+
+	return zkPath, nil
+}
+
+func (conn *FakeZkConnection) Get(zkPath string) ([]byte, *zk.Stat, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return nil, nil, zk.ErrClosing
+	}
+
+	node, assigned := conn.nodes[zkPath]
+	if !assigned {
+		return nil, nil, zk.ErrNoNode
+	}
+	stat := node.stat
+	return copyBytes(node.data), &stat, nil
+}
+
+func (conn *FakeZkConnection) Set(zkPath string, data []byte, version int32) (*zk.Stat, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return nil, zk.ErrClosing
+	}
+
+	node, assigned := conn.nodes[zkPath]
+	if !assigned {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return nil, zk.ErrBadVersion
+	}
+
+	conn.zxid = conn.zxid + 1
+	node.data = copyBytes(data)
+	node.stat.Version = node.stat.Version + 1
+	node.stat.Mzxid = conn.zxid
+	node.stat.Mtime = time.Now().UnixNano() / int64(time.Millisecond)
+	node.stat.DataLength = int32(len(data))
+	stat := node.stat
+	return &stat, nil
+}
+
+func (conn *FakeZkConnection) Children(zkPath string) ([]string, *zk.Stat, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return nil, nil, zk.ErrClosing
+	}
+
+	node, assigned := conn.nodes[zkPath]
+	if !assigned {
+		return nil, nil, zk.ErrNoNode
+	}
+	stat := node.stat
+	return conn.children(zkPath), &stat, nil
+}
+
+func (conn *FakeZkConnection) ChildrenW(zkPath string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return nil, nil, nil, zk.ErrClosing
+	}
+
+	node, assigned := conn.nodes[zkPath]
+	if !assigned {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	stat := node.stat
+	watcher := make(chan zk.Event, 1)
+	conn.watchers[zkPath] = append(conn.watchers[zkPath], watcher)
+	return conn.children(zkPath), &stat, watcher, nil
+}
+
+func (conn *FakeZkConnection) Exists(zkPath string) (bool, *zk.Stat, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return false, nil, zk.ErrClosing
+	}
+
+	node, assigned := conn.nodes[zkPath]
+	if !assigned {
+		return false, nil, nil
+	}
+	stat := node.stat
+	return true, &stat, nil
+}
+
+func (conn *FakeZkConnection) Delete(zkPath string, version int32) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return zk.ErrClosing
+	}
+	if conn.failingDeletes > 0 {
+		conn.failingDeletes = conn.failingDeletes - 1
+		return zk.ErrConnectionClosed
+	}
+	return conn.delete(zkPath, version)
+}
+
+// FailDeletes makes the next count Deletes fail, as they would while the connection is lost
+func (conn *FakeZkConnection) FailDeletes(count int) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.failingDeletes = count
+}
+
+func (conn *FakeZkConnection) NewLock(zkPath string, acl []zk.ACL) ZkLock {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	semaphore, assigned := conn.locks[zkPath]
+	if !assigned {
+		semaphore = make(chan struct{}, 1)
+		conn.locks[zkPath] = semaphore
+	}
+	return &fakeZkLock{semaphore: semaphore}
+}
+
+func (conn *FakeZkConnection) State() zk.State {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return zk.StateDisconnected
+	}
+	return zk.StateHasSession
+}
+
+// Close ends the current session, which removes every ephemeral node it owns
+func (conn *FakeZkConnection) Close() {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.closed {
+		return
+	}
+
+	ephemerals := []string{}
+	for zkPath, node := range conn.nodes {
+		if node.ephemeral && node.stat.EphemeralOwner == conn.sessionID {
+			ephemerals = append(ephemerals, zkPath)
+		}
+	}
+	for _, zkPath := range ephemerals {
+		conn.delete(zkPath, -1)
+	}
+	conn.closed = true
+}
+
+// Paths returns every node currently stored, sorted, which is handy in test assertions
+func (conn *FakeZkConnection) Paths() []string {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	paths := make([]string, 0, len(conn.nodes))
+	for zkPath := range conn.nodes {
+		paths = append(paths, zkPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Must be called with conn.lock held
+func (conn *FakeZkConnection) children(zkPath string) []string {
+	prefix := zkPath + "/"
+	if zkPath == "/" {
+		prefix = "/"
+	}
+	children := []string{}
+	for childPath := range conn.nodes {
+		if childPath == "/" || !strings.HasPrefix(childPath, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(childPath, prefix)
+		if !strings.Contains(name, "/") {
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// Must be called with conn.lock held
+func (conn *FakeZkConnection) delete(zkPath string, version int32) error {
+	node, assigned := conn.nodes[zkPath]
+	if !assigned || zkPath == "/" {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return zk.ErrBadVersion
+	}
+	if len(conn.children(zkPath)) > 0 {
+		return zk.ErrNotEmpty
+	}
+
+	conn.zxid = conn.zxid + 1
+	delete(conn.nodes, zkPath)
+	conn.fire(zkPath, zk.EventNodeDeleted)
+
+	parentPath := path.Dir(zkPath)
+	if parent, assigned := conn.nodes[parentPath]; assigned {
+		parent.stat.Cversion = parent.stat.Cversion + 1
+		parent.stat.NumChildren = parent.stat.NumChildren - 1
+		parent.stat.Pzxid = conn.zxid
+		conn.fire(parentPath, zk.EventNodeChildrenChanged)
+	}
+	return nil
+}
+
+// Watches in Zookeeper are one shot, so they're dropped as soon as they fire.
+// Must be called with conn.lock held
+func (conn *FakeZkConnection) fire(zkPath string, eventType zk.EventType) {
+	for _, watcher := range conn.watchers[zkPath] {
+		watcher <- zk.Event{
+			Type:  eventType,
+			State: zk.StateHasSession,
+			Path:  zkPath,
+		}
+		close(watcher)
+	}
+	delete(conn.watchers, zkPath)
+}
+
+type fakeZkLock struct {
+	semaphore chan struct{}
+	locked    bool
+}
+
+func (lock *fakeZkLock) Lock() error {
+	if lock.locked {
+		return zk.ErrDeadlock
+	}
+	lock.semaphore <- struct{}{}
+	lock.locked = true
+	return nil
+}
+
+func (lock *fakeZkLock) Unlock() error {
+	if !lock.locked {
+		return zk.ErrNotLocked
+	}
+	<-lock.semaphore
+	lock.locked = false
+	return nil
+}
+
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	dup := make([]byte, len(data))
+	copy(dup, data)
+	return dup
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"strings"
 	"sync"
-	"time"
 )
 
 // TODO: Convert ZKNode functions to all work around MetadataNode interface for better testing
@@ -29,8 +28,8 @@ func (node *ZkNode) String() string {
 func (node *ZkNode) GetData() []byte {
 	return node.data
 }
-func (node *ZkNode) GetLock() *zk.Lock {
-	zkLock := zk.NewLock(node.mgr.zkConn, node.ns.GetZKPath(), zk.WorldACL(zk.PermAll))
+func (node *ZkNode) GetLock() ZkLock {
+	zkLock := node.mgr.zkConn.NewLock(node.ns.GetZKPath(), zk.WorldACL(zk.PermAll))
 	return zkLock
 }
 func (node *ZkNode) SetData(data []byte) error {
@@ -68,6 +67,12 @@ func (node *ZkNode) GetChildren() []*ZkNode {
 	return node.mgr.getChildren(node.ns)
 }
 
+// GetChildNames lists the names of the node's children, without reading them
+func (node *ZkNode) GetChildNames() ([]string, error) {
+	children, _, err := node.mgr.zkConn.Children(node.ns.GetZKPath())
+	return children, err
+}
+
 func (node *ZkNode) GetChildrenW() ([]*ZkNode, <-chan zk.Event) {
 	return node.mgr.getChildrenW(node.ns)
 }
@@ -150,10 +155,11 @@ func makeSubSpace(ns Namespace, subSpaceName string) Namespace {
 
 type MetadataManager struct {
 	frameworkID string
-	zkConn      *zk.Conn
+	zkConn      ZkConnection
+	connector   ZkConnector
 	namespace   Namespace
 	lock        *sync.Mutex
-	zkLock      zk.Lock
+	zkLock      ZkLock
 	zookeepers  []string
 }
 
@@ -162,10 +168,17 @@ func (mgr *MetadataManager) setup() {
 }
 
 func NewMetadataManager(frameworkID string, zookeepers []string) *MetadataManager {
+	return NewMetadataManagerWithConnector(frameworkID, zookeepers, ConnectZookeeper)
+}
+
+// NewMetadataManagerWithConnector is like NewMetadataManager, but lets the caller decide how
+// connections are made. Tests use this to run against a FakeZkConnection.
+func NewMetadataManagerWithConnector(frameworkID string, zookeepers []string, connector ZkConnector) *MetadataManager {
 	manager := &MetadataManager{
 		lock:        &sync.Mutex{},
 		frameworkID: frameworkID,
 		zookeepers:  zookeepers,
+		connector:   connector,
 	}
 
 	manager.CreateConnection()
@@ -178,21 +191,13 @@ func (mgr *MetadataManager) DeleteChildren(path string) {
 	mgr.DeleteChildrenWithRetry(path, 0, 10)
 }
 func (mgr *MetadataManager) DeleteChildrenWithRetry(path string, currentRetry int, retry int) {
-	children, _, _ := mgr.zkConn.Children(path)
-
-	// Leaf
-	if len(children) == 0 {
-		fmt.Println("Deleting ", path)
-		err := mgr.zkConn.Delete(path, -1)
-		if err != nil && currentRetry >= retry {
-			log.Panic(err)
-		}
-		if err != nil {
-			log.Warning(err)
-			mgr.DeleteChildrenWithRetry(path, currentRetry+1, retry)
-		} else {
-			return
-		}
+	children, _, err := mgr.zkConn.Children(path)
+	if err == zk.ErrNoNode {
+		return
+	}
+	if err != nil {
+		mgr.retryDeleteChildren(path, currentRetry, retry, err)
+		return
 	}
 
 	// Branches
@@ -200,7 +205,20 @@ func (mgr *MetadataManager) DeleteChildrenWithRetry(path string, currentRetry in
 		mgr.DeleteChildrenWithRetry(path+"/"+name, currentRetry, retry)
 	}
 
-	return
+	// Leaf, or a branch whose children are gone now
+	fmt.Println("Deleting ", path)
+	err = mgr.zkConn.Delete(path, -1)
+	if err != nil && err != zk.ErrNoNode {
+		mgr.retryDeleteChildren(path, currentRetry, retry, err)
+	}
+}
+
+func (mgr *MetadataManager) retryDeleteChildren(path string, currentRetry int, retry int, err error) {
+	if currentRetry >= retry {
+		log.Panic(err)
+	}
+	log.Warning(err)
+	mgr.DeleteChildrenWithRetry(path, currentRetry+1, retry)
 }
 
 func (mgr *MetadataManager) createPathIfNotExists(path string, ephemeral bool) {
@@ -225,19 +243,18 @@ func (mgr *MetadataManager) CreateConnection() {
 		mgr.zkConn.Close()
 	}
 
-	conn, _, err := zk.Connect(mgr.zookeepers, time.Second)
+	conn, err := mgr.connector(mgr.zookeepers)
 	if err != nil {
-		conn.Close()
 		log.Panic(err)
 	}
 	bns := baseNamespace{}
 	ns := makeSubSpace(makeSubSpace(makeSubSpace(bns, "riak"), "frameworks"), mgr.frameworkID)
 	lockPath := makeSubSpace(ns, "lock")
-	zkLock := zk.NewLock(conn, lockPath.GetZKPath(), zk.WorldACL(zk.PermAll))
+	zkLock := conn.NewLock(lockPath.GetZKPath(), zk.WorldACL(zk.PermAll))
 
 	mgr.zkConn = conn
 	mgr.namespace = ns
-	mgr.zkLock = *zkLock
+	mgr.zkLock = zkLock
 }
 func (mgr *MetadataManager) CreateNSIfNotExists(ns Namespace, ephemeral bool) {
 	components := ns.GetComponents()
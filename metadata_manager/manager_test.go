@@ -2,7 +2,9 @@ package metadata_manager
 
 import (
 	"testing"
+	"time"
 
+	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,3 +15,133 @@ func TestNS(t *testing.T) {
 
 	assert.Equal([]string{"", "riak", "frameworks", "fakeFramework"}, namespace.GetComponents())
 }
+
+func newFakeMetadataManager() (*MetadataManager, *FakeZkConnection) {
+	conn := NewFakeZkConnection()
+	return NewMetadataManagerWithConnector("fakeFramework", []string{}, conn.Connector()), conn
+}
+
+func TestSetup(t *testing.T) {
+	assert := assert.New(t)
+	_, conn := newFakeMetadataManager()
+
+	assert.Equal([]string{"/", "/riak", "/riak/frameworks", "/riak/frameworks/fakeFramework"}, conn.Paths())
+}
+
+func TestChildData(t *testing.T) {
+	assert := assert.New(t)
+	mgr, _ := newFakeMetadataManager()
+	root := mgr.GetRootNode()
+
+	_, err := root.GetChild("state")
+	assert.Equal(zk.ErrNoNode, err)
+
+	child, err := root.MakeChildWithData("state", []byte("one"), false)
+	assert.Nil(err)
+	assert.Equal([]byte("one"), child.GetData())
+
+	assert.Nil(child.SetData([]byte("two")))
+	assert.Nil(child.SetData([]byte("three")))
+	child, err = root.GetChild("state")
+	assert.Nil(err)
+	assert.Equal([]byte("three"), child.GetData())
+	assert.Equal(int32(2), child.stat.Version)
+
+	// MakeChildWithData on an existing node overwrites the data
+	child, err = root.MakeChildWithData("state", []byte("four"), false)
+	assert.Nil(err)
+	assert.Equal([]byte("four"), child.GetData())
+}
+
+func TestChildren(t *testing.T) {
+	assert := assert.New(t)
+	mgr, _ := newFakeMetadataManager()
+	root := mgr.GetRootNode()
+	root.CreateChildIfNotExists("coordinator")
+	root.CreateChildIfNotExists("coordinator")
+	coordinator, err := root.GetChild("coordinator")
+	assert.Nil(err)
+
+	children, watch := coordinator.GetChildrenW()
+	assert.Equal(0, len(children))
+
+	_, err = coordinator.MakeChildWithData("node1", []byte("1"), false)
+	assert.Nil(err)
+	event := <-watch
+	assert.Equal(zk.EventNodeChildrenChanged, event.Type)
+
+	_, err = coordinator.MakeChildWithData("node2", []byte("2"), false)
+	assert.Nil(err)
+	children = coordinator.GetChildren()
+	assert.Equal(2, len(children))
+	assert.Equal([]byte("1"), children[0].GetData())
+	assert.Equal([]byte("2"), children[1].GetData())
+
+	coordinator.Delete()
+	_, err = root.GetChild("coordinator")
+	assert.Equal(zk.ErrNoNode, err)
+}
+
+func TestDeleteChildrenRetries(t *testing.T) {
+	assert := assert.New(t)
+	mgr, conn := newFakeMetadataManager()
+	root := mgr.GetRootNode()
+	cluster, err := root.MakeChild("cluster", false)
+	assert.Nil(err)
+	_, err = cluster.MakeChild("node1", false)
+	assert.Nil(err)
+
+	// A delete which only fails once is retried, rather than retried until it panics
+	conn.FailDeletes(1)
+	cluster.Delete()
+	_, err = root.GetChild("cluster")
+	assert.Equal(zk.ErrNoNode, err)
+
+	// Deleting what's already gone is fine
+	cluster.Delete()
+}
+
+func TestEphemeral(t *testing.T) {
+	assert := assert.New(t)
+	mgr, conn := newFakeMetadataManager()
+	root := mgr.GetRootNode()
+
+	_, err := root.MakeChild("persistent", false)
+	assert.Nil(err)
+	_, err = root.MakeChild("ephemeral", true)
+	assert.Nil(err)
+
+	// Losing the session takes the ephemeral nodes with it, and the manager reconnects
+	conn.Close()
+	mgr.CreateConnection()
+
+	_, err = root.GetChild("persistent")
+	assert.Nil(err)
+	_, err = root.GetChild("ephemeral")
+	assert.Equal(zk.ErrNoNode, err)
+}
+
+func TestLock(t *testing.T) {
+	assert := assert.New(t)
+	mgr, conn := newFakeMetadataManager()
+
+	mgr.SetupFramework("http://scheduler:8080")
+	uri, err := mgr.GetRootNode().GetChild("uri")
+	assert.Nil(err)
+	assert.Equal([]byte("http://scheduler:8080"), uri.GetData())
+
+	// A second scheduler for the same framework blocks on the lock
+	locked := make(chan interface{})
+	go func() {
+		conn.NewLock("/riak/frameworks/fakeFramework/lock", zk.WorldACL(zk.PermAll)).Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		assert.Fail("Framework lock was acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Nil(mgr.zkLock.Unlock())
+	<-locked
+}
@@ -1,6 +1,7 @@
 package process_manager
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -13,46 +14,115 @@ import (
 type Healthchecker func() error
 type TeardownCallback func()
 
+// How long a process gets to start and pass its healthcheck, unless NewProcessManager is given a timeout
+const DEFAULT_HEALTHCHECK_TIMEOUT = 60 * time.Second
+
+// How long a process gets to exit after SIGTERM before it's sent SIGKILL, unless its StopSequence says otherwise
+const DEFAULT_SIGTERM_TIMEOUT = 5 * time.Second
+
+// How long a process gets to exit after its stop command is run, unless its StopSequence says otherwise
+const DEFAULT_STOP_COMMAND_TIMEOUT = 30 * time.Second
+
+// The stages of a StopSequence, as returned by StoppedBy
+const (
+	STOP_STAGE_COMMAND string = "stop command"
+	STOP_STAGE_SIGTERM string = "SIGTERM"
+	STOP_STAGE_SIGKILL string = "SIGKILL"
+	STOP_STAGE_EXITED  string = "exited" // The process was gone before it was stopped
+)
+
+// StopSequence is how a process is torn down. StopCommand is run first, if there is one, then the process is sent
+// SIGTERM, then SIGKILL. Each stage gets its own timeout for the process to exit before the next one.
+type StopSequence struct {
+	// Executable and arguments, run with the environment of the process
+	StopCommand        []string
+	StopCommandTimeout time.Duration
+	SigtermTimeout     time.Duration
+}
+
 type ProcessManager struct {
-	tdcb      TeardownCallback
-	teardown  chan chan interface{}
-	pid       int
-	subscribe chan chan int
+	tdcb         TeardownCallback
+	teardown     chan chan interface{}
+	pid          int
+	subscribe    chan chan int
+	stopSequence StopSequence
+	// Set before subscribers are told the process is gone
+	stopStage string
+	// Closed once the process is gone, with its exitStatus, after which Listen and TearDown return straight away
+	done       chan struct{}
+	exitStatus int
+	spec       ProcessSpec
+	chroot     string
+	// What the process was started with, and its cgroup if it has one of its own
+	procattr  *syscall.ProcAttr
+	cgroupDir string
+}
+
+type startResult struct {
+	pm  *ProcessManager
+	err error
 }
 
-func NewProcessManager(tdcb TeardownCallback, executablePath string, args []string, healthcheck Healthchecker, chroot *string, useSuperChroot bool) (*ProcessManager, error) {
-	retFuture := make(chan *ProcessManager)
-	go startProcessManager(tdcb, executablePath, args, healthcheck, retFuture, chroot, useSuperChroot)
-	retVal := <-retFuture
-	log.Info("Retval: ", retVal)
-	if retVal == nil {
-		err := fmt.Errorf("Unknown Error")
-		return retVal, err
-	} else {
-		return retVal, nil
+// NewProcessManager starts the process and waits up to healthcheckTimeout for it to pass its healthcheck. The error
+// says why it didn't, with the healthcheck's last error if it had one. The process is torn down with the
+// stopSequence. The executable and the stop command are paths in the chroot dir, and are run as the spec says.
+func NewProcessManager(tdcb TeardownCallback, executablePath string, args []string, healthcheck Healthchecker, healthcheckTimeout time.Duration, stopSequence StopSequence, chroot *string, spec ProcessSpec) (*ProcessManager, error) {
+	if healthcheckTimeout <= 0 {
+		healthcheckTimeout = DEFAULT_HEALTHCHECK_TIMEOUT
+	}
+	if stopSequence.StopCommandTimeout <= 0 {
+		stopSequence.StopCommandTimeout = DEFAULT_STOP_COMMAND_TIMEOUT
+	}
+	if stopSequence.SigtermTimeout <= 0 {
+		stopSequence.SigtermTimeout = DEFAULT_SIGTERM_TIMEOUT
+	}
+	retFuture := make(chan startResult, 1)
+	go startProcessManager(tdcb, executablePath, args, healthcheck, healthcheckTimeout, stopSequence, retFuture, chroot, spec)
+	retVal, ok := <-retFuture
+	log.Info("Retval: ", retVal.pm)
+	if !ok {
+		return nil, fmt.Errorf("Unknown Error")
 	}
+	return retVal.pm, retVal.err
 }
 
 func (pm *ProcessManager) Listen() chan int {
 	ret := make(chan int, 1)
-	pm.subscribe <- ret
+	select {
+	case pm.subscribe <- ret:
+	case <-pm.done:
+		ret <- pm.exitStatus
+	}
 	return ret
 }
 func (pm *ProcessManager) TearDown() {
-	replyChan := make(chan interface{})
-	pm.teardown <- replyChan
-	<-replyChan
+	replyChan := make(chan interface{}, 1)
+	select {
+	case pm.teardown <- replyChan:
+		<-replyChan
+	case <-pm.done:
+	}
 	return
 }
-func startProcessManager(tdcb TeardownCallback, executablePath string, args []string, healthcheck Healthchecker, retChan chan *ProcessManager, chroot *string, useSuperChroot bool) {
+
+// StoppedBy is the stage of the stop sequence which stopped the process once it was torn down, empty if it wasn't.
+// Subscribers can call it once they're told the process is gone.
+func (pm *ProcessManager) StoppedBy() string {
+	return pm.stopStage
+}
+
+func startProcessManager(tdcb TeardownCallback, executablePath string, args []string, healthcheck Healthchecker, healthcheckTimeout time.Duration, stopSequence StopSequence, retChan chan startResult, chroot *string, spec ProcessSpec) {
 	defer close(retChan)
+	// Unbuffered, so nothing is sent once the process manager's gone
 	pm := &ProcessManager{
-		teardown:  make(chan chan interface{}, 10),
-		tdcb:      tdcb,
-		subscribe: make(chan chan int, 10),
+		teardown:     make(chan chan interface{}),
+		tdcb:         tdcb,
+		subscribe:    make(chan chan int),
+		stopSequence: stopSequence,
+		spec:         spec,
+		done:         make(chan struct{}),
 	}
-	defer close(pm.teardown)
-	defer close(pm.subscribe)
+	defer close(pm.done)
 	signals := make(chan os.Signal, 3)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 	defer signal.Stop(signals)
@@ -62,15 +132,23 @@ func startProcessManager(tdcb TeardownCallback, executablePath string, args []st
 	sigchlds := make(chan os.Signal, 1000)
 	signal.Notify(sigchlds, syscall.SIGCHLD)
 
-	pm.start(executablePath, args, chroot, useSuperChroot)
-
-	waitChan := subscribe(pm.pid)
+	defer pm.removeCgroup()
+	waitChan, err := pm.start(executablePath, args, chroot)
+	if err != nil {
+		if pm.pid != 0 {
+			syscall.Kill(pm.pid, syscall.SIGKILL)
+			unsubscribe(pm.pid)
+		}
+		retChan <- startResult{err: fmt.Errorf("Unable to start process: %v", err)}
+		return
+	}
 	defer unsubscribe(pm.pid)
-	defer close(waitChan)
 	subscriptions := []chan int{}
 
-	// Wait 60 seconds for the process to start, and pass its healthcheck.
-	for i := 0; i < 60; i++ {
+	// Wait for the process to start, and pass its healthcheck.
+	deadline := time.After(healthcheckTimeout)
+	healthcheckErr := errors.New("Not health checked yet")
+	for {
 		select {
 		case subscribe := <-pm.subscribe:
 			{
@@ -79,8 +157,8 @@ func startProcessManager(tdcb TeardownCallback, executablePath string, args []st
 		case <-signals:
 			{
 				log.Info("Tearing down at signal")
+				pm.stopStage = pm.killProcess(waitChan)
 				pm.notify(-1, subscriptions)
-				pm.killProcess(waitChan)
 				pm.tdcb()
 				return
 			}
@@ -89,37 +167,44 @@ func startProcessManager(tdcb TeardownCallback, executablePath string, args []st
 				pm.notify(status.wstatus.ExitStatus(), subscriptions)
 				pm.tdcb()
 				pm.killProcess(waitChan)
+				retChan <- startResult{err: fmt.Errorf("Process exited with status %d before passing its healthcheck: %v", status.wstatus.ExitStatus(), healthcheckErr)}
 				return
 			}
 		case tearDownChan := <-pm.teardown:
 			{
 				log.Info("Tearing down")
+				pm.stopStage = pm.killProcess(waitChan)
 				pm.notify(-1, subscriptions)
-				pm.killProcess(waitChan)
 				pm.tdcb()
 				tearDownChan <- nil
 				return
 			}
+		case <-deadline:
+			{
+				log.Info("Process manager failed to start process in time: ", healthcheckErr)
+				pm.killProcess(waitChan)
+				retChan <- startResult{err: fmt.Errorf("Process failed its healthcheck for %v: %v", healthcheckTimeout, healthcheckErr)}
+				return
+			}
 		case <-time.After(1000 * time.Millisecond):
 			{
-				// Try pinging Riak Explorer
-				err := healthcheck()
-				if err == nil {
-					log.Info("Process status: ", err)
-					retChan <- pm
+				healthcheckErr = healthcheck()
+				if healthcheckErr == nil {
+					log.Info("Process passed its healthcheck")
+					retChan <- startResult{pm: pm}
 					// re.background() should never return
 					pm.background(waitChan, subscriptions, signals)
 					return
 				}
+				log.Info("Process healthcheck: ", healthcheckErr)
 			}
 		}
 	}
-	log.Info("Process manager failed to start process in time")
-	pm.killProcess(waitChan)
 }
 
 func (pm *ProcessManager) notify(status int, subscriptions []chan int) {
 	log.Info("Notify being called")
+	pm.exitStatus = status
 	for _, sub := range subscriptions {
 		select {
 		case sub <- status:
@@ -141,16 +226,16 @@ func (pm *ProcessManager) background(waitChan chan pidChangeNotification, subscr
 		case <-signals:
 			{
 				log.Info("Tearing down at signal")
+				pm.stopStage = pm.killProcess(waitChan)
 				pm.notify(-1, subscriptions)
 				pm.tdcb()
-				pm.killProcess(waitChan)
 				return
 			}
 		case tearDownChan := <-pm.teardown:
 			{
 				log.Info("Tearing down")
+				pm.stopStage = pm.killProcess(waitChan)
 				pm.notify(-1, subscriptions)
-				pm.killProcess(waitChan)
 				pm.tdcb()
 				tearDownChan <- nil
 				return
@@ -163,25 +248,70 @@ func (pm *ProcessManager) background(waitChan chan pidChangeNotification, subscr
 	}
 }
 
-func (pm *ProcessManager) killProcess(waitChan chan pidChangeNotification) {
+// killProcess goes through the stop sequence until the process exits, and returns the stage which stopped it
+func (pm *ProcessManager) killProcess(waitChan chan pidChangeNotification) string {
 	log.Info("Killing process")
 	// Is it alive?
 	if syscall.Kill(pm.pid, syscall.Signal(0)) != nil {
-		return
+		return STOP_STAGE_EXITED
 	}
 
 	// TODO: Work around some potential races here
 
+	if pm.runStopCommand(waitChan) {
+		return STOP_STAGE_COMMAND
+	}
+
+	log.Info("Sending SIGTERM")
 	syscall.Kill(pm.pid, syscall.SIGTERM)
 	select {
 	case <-waitChan:
 		{
-			return
+			return STOP_STAGE_SIGTERM
 		}
-	case <-time.After(time.Second * 5):
+	case <-time.After(pm.stopSequence.SigtermTimeout):
 		{
+			log.Infof("Process didn't exit %v after SIGTERM, sending SIGKILL", pm.stopSequence.SigtermTimeout)
 			syscall.Kill(pm.pid, syscall.SIGKILL)
 		}
 	}
 	<-waitChan
+	return STOP_STAGE_SIGKILL
+}
+
+// runStopCommand runs the stop command, and returns true if the process exited within the stop command's timeout.
+// It's started like the process, with the same user and isolation, and subscribed to before the reaper can reap it.
+func (pm *ProcessManager) runStopCommand(waitChan chan pidChangeNotification) bool {
+	stopCommand := pm.stopSequence.StopCommand
+	if len(stopCommand) == 0 {
+		return false
+	}
+	deadline := time.After(pm.stopSequence.StopCommandTimeout)
+	log.Infof("Running stop command: %v", stopCommand)
+	pid, commandChan, err := forkExecSubscribed(pm.chrootPath(stopCommand[0]), stopCommand, pm.procattr)
+	if err != nil {
+		log.Error("Unable to run stop command: ", err)
+		return false
+	}
+	defer unsubscribe(pid)
+
+	for {
+		select {
+		case <-waitChan:
+			return true
+		case status := <-commandChan:
+			if status.wstatus.ExitStatus() != 0 {
+				log.Warnf("Stop command exited with status %d", status.wstatus.ExitStatus())
+				return false
+			}
+			// The process may take a moment longer to exit after the command's done
+			commandChan = nil
+		case <-deadline:
+			log.Infof("Process didn't exit within %v of the stop command", pm.stopSequence.StopCommandTimeout)
+			if commandChan != nil {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+			return false
+		}
+	}
 }
@@ -7,7 +7,6 @@ import (
 	"os"
 	"strings"
 	"syscall"
-	"path/filepath"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -55,20 +54,25 @@ func GetProcAttributes() *syscall.ProcAttr {
 	return procattr
 }
 
-func (pm *ProcessManager) start(executablePath string, args []string, chroot *string, useSuperChroot bool) {
-	pm.maybeChroot(executablePath, args, chroot, useSuperChroot)
-}
-
-func (pm *ProcessManager) maybeChroot(executablePath string, args []string, chroot *string, _ bool) {
-	executablePath = filepath.Join(*chroot, executablePath)
+// start runs the executable, at its path in the chroot dir, limited and isolated as the spec says. The channel tells
+// when it exits.
+func (pm *ProcessManager) start(executablePath string, args []string, chroot *string) (chan pidChangeNotification, error) {
+	pm.chroot = *chroot
+	procattr, err := pm.procAttributes()
+	if err != nil {
+		return nil, err
+	}
+	pm.procattr = procattr
+	pm.raiseOpenFilesLimit()
+	if err := pm.maybeCreateCgroup(); err != nil {
+		return nil, err
+	}
 
-	realArgs := []string{}
-	realArgs = append([]string{executablePath}, args...)
-	procattr := GetProcAttributes()
-	pm.doStart(executablePath, realArgs, procattr)
+	executablePath = pm.chrootPath(executablePath)
+	realArgs := append([]string{executablePath}, args...)
+	return pm.doStart(executablePath, realArgs, procattr)
 }
 
-
 // setOpenFilesLimit sets the open file limit in the kernel
 // cur is the soft limit, max is the ceiling (or hard limit) for that limit
 func (pm *ProcessManager) setOpenFilesLimit(cur, max uint64) error {
@@ -98,18 +102,15 @@ func (pm *ProcessManager) setOpenFilesLimit(cur, max uint64) error {
 	return nil
 }
 
-func (pm *ProcessManager) doStart(executablePath string, args []string, procattr *syscall.ProcAttr) {
+func (pm *ProcessManager) doStart(executablePath string, args []string, procattr *syscall.ProcAttr) (chan pidChangeNotification, error) {
 	var err error
+	var waitChan chan pidChangeNotification
 	log.Infof("Getting Ready to start process: %v with args: %v and ProcAttr: %+v", executablePath, args, procattr)
-	err = pm.setOpenFilesLimit(openFilesLimit, openFilesLimit)
-	if err != nil {
-		log.Error("Error setting open files limit", err)
-	}
-
-	pm.pid, err = syscall.ForkExec(executablePath, args, procattr)
+	pm.pid, waitChan, err = pm.forkExecWithSpec(executablePath, args, procattr)
 	if err != nil {
-		log.Panicf("Error starting process %v", err)
-	} else {
-		log.Infof("Process Manager started to manage %v at PID: %v", executablePath, pm.pid)
+		log.Errorf("Error starting process %v", err)
+		return nil, err
 	}
+	log.Infof("Process Manager started to manage %v at PID: %v", executablePath, pm.pid)
+	return waitChan, nil
 }
@@ -4,26 +4,20 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
 func init() {
-	subscribeNotifications = make(chan subscriptionRequest, 1)
-	unsubscribeNotifications = make(chan unsubscriptionRequest, 1)
+	subscriptions = make(map[int]chan pidChangeNotification)
 	go processManagerLoop()
 }
 
-var subscribeNotifications chan subscriptionRequest
-var unsubscribeNotifications chan unsubscriptionRequest
+// Held while children are reaped, and while a child is started and subscribed to, so it can't be reaped before
+// there's a subscription for it
+var subscriptionsLock sync.Mutex
+var subscriptions map[int]chan pidChangeNotification
 
-type subscriptionRequest struct {
-	pid       int
-	replyChan chan pidChangeNotification
-}
-
-type unsubscriptionRequest struct {
-	pid int
-}
 type pidChangeNotification struct {
 	pid     int
 	wstatus syscall.WaitStatus
@@ -31,73 +25,80 @@ type pidChangeNotification struct {
 }
 
 func subscribe(pid int) chan pidChangeNotification {
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
+	return subscribeLocked(pid)
+}
+
+func subscribeLocked(pid int) chan pidChangeNotification {
 	// reply must be non-blocking
 	replyChan := make(chan pidChangeNotification, 1)
-	req := subscriptionRequest{pid: pid, replyChan: replyChan}
-	subscribeNotifications <- req
+	_, assigned := subscriptions[pid]
+	if assigned {
+		panic("Duplicate subscription for a PID")
+	}
+	subscriptions[pid] = replyChan
 	return replyChan
 }
 
 func unsubscribe(pid int) {
-	req := unsubscriptionRequest{pid: pid}
-	unsubscribeNotifications <- req
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
+	delete(subscriptions, pid)
 }
 
-func processManagerLoop() {
-	defer close(subscribeNotifications)
-	defer close(unsubscribeNotifications)
+// forkExecSubscribed starts the executable, and subscribes to it before it can be reaped
+func forkExecSubscribed(argv0 string, argv []string, attr *syscall.ProcAttr) (int, chan pidChangeNotification, error) {
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
+	pid, err := syscall.ForkExec(argv0, argv, attr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return pid, subscribeLocked(pid), nil
+}
 
+func processManagerLoop() {
 	sigchlds := make(chan os.Signal, 1000)
 	signal.Notify(sigchlds, syscall.SIGCHLD)
 	defer signal.Stop(sigchlds)
 	defer close(sigchlds)
-	subscriptions := make(map[int]chan pidChangeNotification)
 
+	for range sigchlds {
+		reapChildren()
+	}
+}
+
+// reapChildren reaps every child which has exited, as a SIGCHLD can stand for several of them
+func reapChildren() {
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
 	for {
+		var wstatus syscall.WaitStatus
+		var rusage syscall.Rusage
+		waitPid, err := syscall.Wait4(-1, &wstatus, syscall.WNOHANG, &rusage)
+		if err == syscall.ECHILD || (err == nil && waitPid <= 0) {
+			return
+		}
+		if err != nil {
+			log.Error("Error waiting for PID: ", err)
+			return
+		}
+		subscription, assigned := subscriptions[waitPid]
+		if !assigned {
+			log.Info("PID notification received without actor to send it to: ", waitPid)
+			continue
+		}
+		notification := pidChangeNotification{
+			pid:     waitPid,
+			wstatus: wstatus,
+			rusage:  rusage,
+		}
 		select {
-		case subscribe := <-subscribeNotifications:
-			{
-				_, assigned := subscriptions[subscribe.pid]
-				if !assigned {
-					subscriptions[subscribe.pid] = subscribe.replyChan
-				} else {
-					panic("Duplicate subscription for a PID")
-				}
-			}
-		case unsubscribe := <-unsubscribeNotifications:
-			{
-				delete(subscriptions, unsubscribe.pid)
-			}
-		case <-sigchlds:
-			{
-				var wstatus syscall.WaitStatus
-				var rusage syscall.Rusage
-				waitPid, err := syscall.Wait4(-1, &wstatus, 0, &rusage)
-				if err == nil {
-					subscription, assigned := subscriptions[waitPid]
-					if assigned {
-						notification := pidChangeNotification{
-							pid:     waitPid,
-							wstatus: wstatus,
-							rusage:  rusage,
-						}
-						select {
-						case subscription <- notification:
-							{
-							}
-						default:
-							{
-								log.Info("PID Change subscription channel full, deleting entry")
-								delete(subscriptions, waitPid)
-							}
-						}
-					} else {
-						log.Info("PID notification received without actor to send it to: ", waitPid)
-					}
-				} else {
-					log.Error("Error waiting for PID: ", err)
-				}
-			}
+		case subscription <- notification:
+		default:
+			log.Info("PID Change subscription channel full, deleting entry")
+			delete(subscriptions, waitPid)
 		}
 	}
 }
@@ -1,14 +1,21 @@
 package process_manager
 
 import (
+	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	ps "github.com/mitchellh/go-ps"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func TestTeardown(t *testing.T) {
@@ -23,8 +30,9 @@ func TestTeardown(t *testing.T) {
 		}
 	}()
 	assert := assert.New(t)
+	chroot := "/"
 
-	re, err := NewProcessManager(func() { return }, "/bin/sleep", []string{"100"}, func() error { return nil }, nil, true)
+	re, err := NewProcessManager(func() { return }, "/bin/sleep", []string{"100"}, func() error { return nil }, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{}, &chroot, ProcessSpec{})
 
 	assert.Nil(err)
 	re.TearDown()
@@ -42,8 +50,223 @@ func TestTeardown(t *testing.T) {
 
 func TestNotify(t *testing.T) {
 	assert := assert.New(t)
-	re, err := NewProcessManager(func() { return }, "/bin/sleep", []string{"1"}, func() error { return nil }, nil, true)
+	chroot := "/"
+	re, err := NewProcessManager(func() { return }, "/bin/sleep", []string{"2"}, func() error { return nil }, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{}, &chroot, ProcessSpec{})
 	assert.Nil(err)
 	status := <-re.Listen()
 	assert.Equal(status, 0)
+	// Once the process is gone, there's nothing left to listen to or tear down
+	assert.Equal(0, <-re.Listen())
+	re.TearDown()
+}
+
+func TestHealthcheckTimeout(t *testing.T) {
+	assert := assert.New(t)
+	chroot := "/"
+	re, err := NewProcessManager(func() { return }, "/bin/sleep", []string{"100"}, func() error { return errors.New("Not ready") }, 2*time.Second, StopSequence{}, &chroot, ProcessSpec{})
+	assert.Nil(re)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Not ready")
+	}
+}
+
+func TestStopSequence(t *testing.T) {
+	assert := assert.New(t)
+	chroot := "/"
+	dir, err := ioutil.TempDir("", "stop_sequence")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+	// Ignores SIGTERM, and says where it can be killed
+	stubborn := []string{"-c", fmt.Sprintf("trap '' TERM; echo $$ > %s; exec sleep 100", pidFile)}
+	healthcheck := func() error {
+		_, err := os.Stat(pidFile)
+		return err
+	}
+
+	re, err := NewProcessManager(func() { return }, "/bin/sleep", []string{"100"}, func() error { return nil }, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{}, &chroot, ProcessSpec{})
+	assert.Nil(err)
+	re.TearDown()
+	assert.Equal(STOP_STAGE_SIGTERM, re.StoppedBy())
+
+	re, err = NewProcessManager(func() { return }, "/bin/sh", stubborn, healthcheck, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{SigtermTimeout: time.Second}, &chroot, ProcessSpec{})
+	assert.Nil(err)
+	re.TearDown()
+	assert.Equal(STOP_STAGE_SIGKILL, re.StoppedBy())
+	os.Remove(pidFile)
+
+	stopSequence := StopSequence{
+		StopCommand:        []string{"/bin/sh", "-c", fmt.Sprintf("kill -KILL $(cat %s)", pidFile)},
+		StopCommandTimeout: 10 * time.Second,
+		SigtermTimeout:     10 * time.Second,
+	}
+	re, err = NewProcessManager(func() { return }, "/bin/sh", stubborn, healthcheck, DEFAULT_HEALTHCHECK_TIMEOUT, stopSequence, &chroot, ProcessSpec{})
+	assert.Nil(err)
+	status := re.Listen()
+	start := time.Now()
+	re.TearDown()
+	assert.Equal(STOP_STAGE_COMMAND, re.StoppedBy())
+	assert.True(time.Since(start) < 10*time.Second)
+	// Only told once the process is gone
+	assert.Equal(-1, <-status)
+	os.Remove(pidFile)
+
+	// Without a timeout, the stop command still gets the default one
+	re, err = NewProcessManager(func() { return }, "/bin/sh", stubborn, healthcheck, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{StopCommand: stopSequence.StopCommand}, &chroot, ProcessSpec{})
+	assert.Nil(err)
+	re.TearDown()
+	assert.Equal(STOP_STAGE_COMMAND, re.StoppedBy())
+	os.Remove(pidFile)
+
+	// A failing stop command moves straight on to SIGTERM, however soon it exits
+	stopSequence.StopCommand = []string{"/bin/false"}
+	for i := 0; i < 3; i++ {
+		re, err = NewProcessManager(func() { return }, "/bin/sleep", []string{"100"}, func() error { return nil }, DEFAULT_HEALTHCHECK_TIMEOUT, stopSequence, &chroot, ProcessSpec{})
+		assert.Nil(err)
+		start = time.Now()
+		re.TearDown()
+		assert.Equal(STOP_STAGE_SIGTERM, re.StoppedBy())
+		assert.True(time.Since(start) < stopSequence.StopCommandTimeout)
+	}
+}
+
+// startForking starts a shell in the chroot dir which forks sleep, like Riak forks the Erlang VM, and returns the
+// sleep's PID once it's running
+func startForking(t *testing.T, chroot string, spec ProcessSpec) (*ProcessManager, int) {
+	pidFile := filepath.Join(chroot, "data", "pid")
+	script := []string{"-c", fmt.Sprintf("sleep 100 & echo $! > %s; wait", pidFile)}
+	healthcheck := func() error {
+		_, err := os.Stat(pidFile)
+		return err
+	}
+	re, err := NewProcessManager(func() { return }, "/sh", script, healthcheck, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{SigtermTimeout: time.Second}, &chroot, spec)
+	if err != nil {
+		t.Fatal("Unable to start process: ", err)
+	}
+	pid, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal("Unable to read the forked PID: ", err)
+	}
+	var forkedPid int
+	fmt.Sscanf(string(pid), "%d", &forkedPid)
+	return re, forkedPid
+}
+
+func testChroot(t *testing.T) string {
+	chroot, err := ioutil.TempDir("", "process_spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(chroot, 0755)
+	os.Mkdir(filepath.Join(chroot, "data"), 0755)
+	os.Symlink("/bin/sh", filepath.Join(chroot, "sh"))
+	return chroot
+}
+
+func TestProcessSpec(t *testing.T) {
+	assert := assert.New(t)
+	chroot := testChroot(t)
+	defer os.RemoveAll(chroot)
+	var openFiles, memlock syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &openFiles)
+	syscall.Getrlimit(RLIMIT_MEMLOCK, &memlock)
+	ownOOM, _ := ioutil.ReadFile("/proc/self/oom_score_adj")
+
+	oomScoreAdj := 500
+	spec := ProcessSpec{
+		Rlimits:      []Rlimit{{Resource: syscall.RLIMIT_NOFILE, Limit: 1024}, {Resource: RLIMIT_MEMLOCK, Limit: 65536}},
+		OOMScoreAdj:  &oomScoreAdj,
+		WritableDirs: []string{"/data"},
+	}
+	if os.Getuid() == 0 {
+		spec.User = "nobody"
+	}
+	re, forkedPid := startForking(t, chroot, spec)
+	defer syscall.Kill(forkedPid, syscall.SIGKILL)
+	// What the process forks has them too
+	for _, pid := range []int{re.pid, forkedPid} {
+		limits, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+		assert.Nil(err)
+		assert.Regexp(`Max open files\s+1024\s+1024`, string(limits))
+		assert.Regexp(`Max locked memory\s+65536\s+65536`, string(limits))
+		oom, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid))
+		assert.Nil(err)
+		assert.Equal("500\n", string(oom))
+		if spec.User != "" {
+			info, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+			assert.Nil(err)
+			assert.Equal(uint32(65534), info.Sys().(*syscall.Stat_t).Uid)
+		}
+	}
+	re.TearDown()
+
+	// The test's own process keeps its limits
+	var ownOpenFiles, ownMemlock syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &ownOpenFiles)
+	syscall.Getrlimit(RLIMIT_MEMLOCK, &ownMemlock)
+	assert.Equal(openFiles, ownOpenFiles)
+	assert.Equal(memlock, ownMemlock)
+	oom, _ := ioutil.ReadFile("/proc/self/oom_score_adj")
+	assert.Equal(ownOOM, oom)
+
+	if spec.User != "" {
+		// Only the writable dirs are given to the user, once
+		info, err := os.Stat(filepath.Join(chroot, "data"))
+		assert.Nil(err)
+		assert.Equal(uint32(65534), info.Sys().(*syscall.Stat_t).Uid)
+		info, err = os.Stat(chroot)
+		assert.Nil(err)
+		assert.Equal(uint32(0), info.Sys().(*syscall.Stat_t).Uid)
+		os.Chown(filepath.Join(chroot, "data"), 0, 0)
+		os.Chmod(filepath.Join(chroot, "data"), 0777)
+		re, forkedPid = startForking(t, chroot, spec)
+		re.TearDown()
+		syscall.Kill(forkedPid, syscall.SIGKILL)
+		info, err = os.Stat(filepath.Join(chroot, "data"))
+		assert.Nil(err)
+		assert.Equal(uint32(0), info.Sys().(*syscall.Stat_t).Uid)
+	}
+
+	// Nothing starts as a user who doesn't exist
+	_, err := NewProcessManager(func() { return }, "/sh", []string{"-c", "sleep 100"}, func() error { return nil }, DEFAULT_HEALTHCHECK_TIMEOUT, StopSequence{}, &chroot, ProcessSpec{User: "no-such-user"})
+	assert.NotNil(err)
+}
+
+func TestProcessSpecCgroup(t *testing.T) {
+	if os.Getuid() != 0 || !cgroupV2Available() {
+		t.Skip("Needs root, and the cgroup v2 hierarchy")
+	}
+	assert := assert.New(t)
+	chroot := testChroot(t)
+	defer os.RemoveAll(chroot)
+
+	spec := ProcessSpec{Cgroup: &CgroupLimits{Name: "test-process-spec", MemoryMaxBytes: 512 * 1024 * 1024, CPUs: 0.5}}
+	re, forkedPid := startForking(t, chroot, spec)
+	defer syscall.Kill(forkedPid, syscall.SIGKILL)
+	cgroupDir := filepath.Join(cgroupV2Root, CGROUP_PARENT, "test-process-spec")
+	procs, err := ioutil.ReadFile(filepath.Join(cgroupDir, "cgroup.procs"))
+	assert.Nil(err)
+	// The VM Riak forks is in the cgroup, and the process manager isn't
+	assert.Contains(strings.Fields(string(procs)), strconv.Itoa(re.pid))
+	assert.Contains(strings.Fields(string(procs)), strconv.Itoa(forkedPid))
+	assert.NotContains(strings.Fields(string(procs)), strconv.Itoa(os.Getpid()))
+	re.TearDown()
+}
+
+func TestProcAttributes(t *testing.T) {
+	assert := assert.New(t)
+	procattr, err := ProcAttributes("/sandbox/root", ProcessSpec{})
+	assert.Nil(err)
+	assert.Equal("", procattr.Sys.Chroot)
+	assert.Equal("/sandbox/root/riak/bin/riak-admin", ChrootPath("/sandbox/root", ProcessSpec{}, "/riak/bin/riak-admin"))
+
+	spec := ProcessSpec{Chroot: true, MountNamespace: true, User: "nobody"}
+	procattr, err = ProcAttributes("/sandbox/root", spec)
+	assert.Nil(err)
+	assert.Equal("/sandbox/root", procattr.Sys.Chroot)
+	assert.Equal("/", procattr.Dir)
+	assert.Equal(uintptr(syscall.CLONE_NEWNS), procattr.Sys.Unshareflags)
+	assert.Equal(uint32(65534), procattr.Sys.Credential.Uid)
+	assert.True(procattr.Sys.Setpgid)
+	assert.Equal("/riak/bin/riak-admin", ChrootPath("/sandbox/root", spec, "/riak/bin/riak-admin"))
 }
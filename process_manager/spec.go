@@ -0,0 +1,42 @@
+package process_manager
+
+// Limit which lifts an rlimit altogether
+const RLIM_INFINITY uint64 = ^uint64(0)
+
+// Where the cgroups of processes are made, under the cgroup v2 hierarchy
+const CGROUP_PARENT = "riak-mesos"
+
+// ProcessSpec is how a process is isolated and limited. The zero value starts it as the process manager always has,
+// from the chroot dir but seeing the whole filesystem, with its open files limit raised to 65536.
+type ProcessSpec struct {
+	// The process starts with them, along with its oom_score_adj and cgroup, so everything it forks has them too. The
+	// process manager's own are left alone, but without an RLIMIT_NOFILE, its open files limit is raised to 65536.
+	Rlimits []Rlimit
+	// Chroot into the chroot dir, rather than only running the executable, and the stop command, from it
+	Chroot         bool
+	MountNamespace bool
+	// User name to run the process as
+	User string
+	// Paths in the chroot dir the process writes to, which are made if they're missing and, with everything in them,
+	// given to User. That's done once per user, as the chroot dir remembers it.
+	WritableDirs []string
+	// Written to the process's oom_score_adj, nil to inherit it
+	OOMScoreAdj *int
+	// Nil to leave the process in the process manager's cgroup
+	Cgroup *CgroupLimits
+}
+
+type Rlimit struct {
+	Resource int
+	// Both the soft and the hard limit, or RLIM_INFINITY
+	Limit uint64
+}
+
+// CgroupLimits are written to a cgroup v2 made for the process, which is removed once the process is gone. They're
+// only applied if the process manager isn't in a cgroup made by Mesos' containerizer.
+type CgroupLimits struct {
+	Name string
+	// Zero for no limit, as for CPUs
+	MemoryMaxBytes int64
+	CPUs           float64
+}
@@ -0,0 +1,344 @@
+//+build linux
+
+package process_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Resources the syscall package has no constants for
+const (
+	RLIMIT_NPROC   int = 0x6
+	RLIMIT_MEMLOCK int = 0x8
+)
+
+const cgroupV2Root = "/sys/fs/cgroup"
+
+// cpu.max quotas are given per this many microseconds
+const cgroupCPUPeriod int64 = 100000
+
+// Where the chroot dir remembers who its WritableDirs were given to
+const writableDirsOwnerFile = ".writable-dirs-owner"
+
+// The process manager's own executable runs as the process's init when it's given this as argv[0]
+const specInitArg0 = "riak-mesos-process-init"
+
+func init() {
+	if len(os.Args) > 2 && os.Args[0] == specInitArg0 {
+		runSpecInit(os.Args[1], os.Args[2:])
+	}
+}
+
+// specInit is what the process's init applies to itself before it execs the process, so the process, and anything
+// it forks, can't escape it
+type specInit struct {
+	Rlimits        []Rlimit
+	OOMScoreAdj    *int
+	CgroupDir      string
+	MountNamespace bool
+	Chroot         string
+	Dir            string
+	Credential     *syscall.Credential
+}
+
+// forkExecWithSpec starts the process with the spec's rlimits, oom_score_adj and cgroup. If it has any of them, the
+// process manager's own executable is started as the process's init, which takes them on, isolates itself as the
+// procattr says, then execs the process. The process manager's own process is left as it is.
+func (pm *ProcessManager) forkExecWithSpec(argv0 string, argv []string, attr *syscall.ProcAttr) (int, chan pidChangeNotification, error) {
+	if len(pm.spec.Rlimits) == 0 && pm.spec.OOMScoreAdj == nil && pm.cgroupDir == "" {
+		return forkExecSubscribed(argv0, argv, attr)
+	}
+	data, err := json.Marshal(specInit{
+		Rlimits:        pm.spec.Rlimits,
+		OOMScoreAdj:    pm.spec.OOMScoreAdj,
+		CgroupDir:      pm.cgroupDir,
+		MountNamespace: attr.Sys.Unshareflags&syscall.CLONE_NEWNS != 0,
+		Chroot:         attr.Sys.Chroot,
+		Dir:            attr.Dir,
+		Credential:     attr.Sys.Credential,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	initArgv := append([]string{specInitArg0, string(data), argv0}, argv...)
+	initAttr := &syscall.ProcAttr{
+		Env:   attr.Env,
+		Files: attr.Files,
+		Sys:   &syscall.SysProcAttr{Setpgid: attr.Sys.Setpgid},
+	}
+	return forkExecSubscribed("/proc/self/exe", initArgv, initAttr)
+}
+
+// runSpecInit is the process's init, which execs argv once it's applied the spec, or exits with 127 if it can't
+func runSpecInit(encoded string, argv []string) {
+	// Namespaces are the thread's, and have to be the one which execs
+	runtime.LockOSThread()
+	spec := specInit{}
+	err := json.Unmarshal([]byte(encoded), &spec)
+	if err == nil {
+		err = spec.apply()
+	}
+	if err == nil {
+		err = syscall.Exec(argv[0], argv[1:], os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "Unable to start %s: %v\n", argv[0], err)
+	os.Exit(127)
+}
+
+func (spec *specInit) apply() error {
+	for _, rlimit := range spec.Rlimits {
+		if err := syscall.Setrlimit(rlimit.Resource, &syscall.Rlimit{Cur: rlimit.Limit, Max: rlimit.Limit}); err != nil {
+			return fmt.Errorf("Unable to set rlimit %d to %v: %v", rlimit.Resource, rlimit.Limit, err)
+		}
+	}
+	if spec.OOMScoreAdj != nil {
+		if err := ioutil.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*spec.OOMScoreAdj)), 0644); err != nil {
+			return fmt.Errorf("Unable to set oom_score_adj: %v", err)
+		}
+	}
+	if spec.CgroupDir != "" {
+		if err := ioutil.WriteFile(filepath.Join(spec.CgroupDir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			return fmt.Errorf("Unable to join %s: %v", spec.CgroupDir, err)
+		}
+	}
+	if spec.MountNamespace {
+		if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+			return err
+		}
+		// As with Unshareflags, so none of the namespace's mounts propagate to the agent
+		if err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return err
+		}
+	}
+	if spec.Chroot != "" {
+		if err := syscall.Chroot(spec.Chroot); err != nil {
+			return err
+		}
+	}
+	if spec.Dir != "" {
+		if err := syscall.Chdir(spec.Dir); err != nil {
+			return err
+		}
+	}
+	if credential := spec.Credential; credential != nil {
+		groups := make([]int, len(credential.Groups))
+		for idx, group := range credential.Groups {
+			groups[idx] = int(group)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return err
+		}
+		if err := syscall.Setgid(int(credential.Gid)); err != nil {
+			return err
+		}
+		if err := syscall.Setuid(int(credential.Uid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// raiseOpenFilesLimit raises the process manager's own open files limit to 65536, for the process to inherit,
+// unless the spec sets it
+func (pm *ProcessManager) raiseOpenFilesLimit() {
+	for _, rlimit := range pm.spec.Rlimits {
+		if rlimit.Resource == syscall.RLIMIT_NOFILE {
+			return
+		}
+	}
+	if err := pm.setOpenFilesLimit(openFilesLimit, openFilesLimit); err != nil {
+		log.Error("Error setting open files limit", err)
+	}
+}
+
+// procAttributes are ProcAttributes, with the WritableDirs given to the spec's user
+func (pm *ProcessManager) procAttributes() (*syscall.ProcAttr, error) {
+	procattr, err := ProcAttributes(pm.chroot, pm.spec)
+	if err != nil {
+		return nil, err
+	}
+	if credential := procattr.Sys.Credential; credential != nil {
+		if err := giveWritableDirs(pm.chroot, pm.spec.WritableDirs, int(credential.Uid), int(credential.Gid)); err != nil {
+			return nil, fmt.Errorf("Unable to give %v to %s: %v", pm.spec.WritableDirs, pm.spec.User, err)
+		}
+	}
+	return procattr, nil
+}
+
+// ProcAttributes are GetProcAttributes, isolated as the spec says, for anything else run alongside a process started
+// from the chroot dir with the spec
+func ProcAttributes(chroot string, spec ProcessSpec) (*syscall.ProcAttr, error) {
+	procattr := GetProcAttributes()
+	if spec.Chroot {
+		procattr.Sys.Chroot = chroot
+		procattr.Dir = "/"
+		procattr.Env = setEnv(procattr.Env, "HOME", "/")
+	}
+	if spec.MountNamespace {
+		// Unlike Cloneflags, Unshareflags also makes the namespace's mounts private, so none propagate to the agent
+		procattr.Sys.Unshareflags = syscall.CLONE_NEWNS
+	}
+	if spec.User != "" {
+		credential, err := lookupCredential(spec.User)
+		if err != nil {
+			return nil, err
+		}
+		procattr.Sys.Credential = credential
+	}
+	return procattr, nil
+}
+
+// ChrootPath is where an executable at path in the chroot dir is run from, with the spec
+func ChrootPath(chroot string, spec ProcessSpec, path string) string {
+	if spec.Chroot {
+		return path
+	}
+	return filepath.Join(chroot, path)
+}
+
+func (pm *ProcessManager) chrootPath(path string) string {
+	return ChrootPath(pm.chroot, pm.spec, path)
+}
+
+// maybeCreateCgroup makes the cgroup for the process, unless there are no cgroup limits or they can't be applied
+func (pm *ProcessManager) maybeCreateCgroup() error {
+	if pm.spec.Cgroup == nil {
+		return nil
+	}
+	if !cgroupV2Available() {
+		log.Info("Not applying cgroup limits, the cgroup v2 hierarchy isn't mounted or Mesos' containerizer limits the process")
+		return nil
+	}
+	parent := filepath.Join(cgroupV2Root, CGROUP_PARENT)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	// The controllers have to be enabled all the way down to the process's cgroup
+	for _, dir := range []string{cgroupV2Root, parent} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644); err != nil {
+			return fmt.Errorf("Unable to enable the memory and cpu controllers in %s: %v", dir, err)
+		}
+	}
+	dir := filepath.Join(parent, strings.Replace(pm.spec.Cgroup.Name, "/", "_", -1))
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	pm.cgroupDir = dir
+
+	memoryMax := "max"
+	if pm.spec.Cgroup.MemoryMaxBytes > 0 {
+		memoryMax = strconv.FormatInt(pm.spec.Cgroup.MemoryMaxBytes, 10)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(memoryMax), 0644); err != nil {
+		return fmt.Errorf("Unable to set memory.max: %v", err)
+	}
+	cpuMax := fmt.Sprintf("max %d", cgroupCPUPeriod)
+	if pm.spec.Cgroup.CPUs > 0 {
+		cpuMax = fmt.Sprintf("%d %d", int64(pm.spec.Cgroup.CPUs*float64(cgroupCPUPeriod)), cgroupCPUPeriod)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+		return fmt.Errorf("Unable to set cpu.max: %v", err)
+	}
+	log.Infof("Limiting process with cgroup %s to memory.max %s and cpu.max %s", dir, memoryMax, cpuMax)
+	return nil
+}
+
+// removeCgroup removes the process's cgroup once it's gone, which fails if anything it started is still running
+func (pm *ProcessManager) removeCgroup() {
+	if pm.cgroupDir == "" {
+		return
+	}
+	if err := os.Remove(pm.cgroupDir); err != nil {
+		log.Warnf("Unable to remove cgroup %s: %v", pm.cgroupDir, err)
+	}
+}
+
+// cgroupV2Available is true if the cgroup v2 hierarchy is mounted, and the process manager isn't in a cgroup made by
+// Mesos' containerizer
+func cgroupV2Available() bool {
+	if _, err := os.Stat(filepath.Join(cgroupV2Root, "cgroup.controllers")); err != nil {
+		return false
+	}
+	cgroups, err := ioutil.ReadFile("/proc/self/cgroup")
+	return err == nil && !strings.Contains(string(cgroups), "mesos")
+}
+
+func lookupCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, groupId := range groupIds {
+		if group, err := strconv.ParseUint(groupId, 10, 32); err == nil {
+			credential.Groups = append(credential.Groups, uint32(group))
+		}
+	}
+	return credential, nil
+}
+
+// giveWritableDirs makes the dirs in the chroot dir, and gives them to the user, unless the chroot dir says they
+// already have been
+func giveWritableDirs(chroot string, dirs []string, uid int, gid int) error {
+	ownerFile := filepath.Join(chroot, writableDirsOwnerFile)
+	owner := fmt.Sprintf("%d:%d %s", uid, gid, strings.Join(dirs, " "))
+	if given, err := ioutil.ReadFile(ownerFile); err == nil && string(given) == owner {
+		return nil
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(chroot, dir)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		if err := chownTree(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(ownerFile, []byte(owner), 0644)
+}
+
+// chownTree gives everything under dir, which isn't already theirs, to the user
+func chownTree(dir string, uid int, gid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == uid && int(stat.Gid) == gid {
+			return nil
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+func setEnv(env []string, name string, value string) []string {
+	for idx, val := range env {
+		if strings.HasPrefix(val, name+"=") {
+			env[idx] = name + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
@@ -0,0 +1,484 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	metamgr "github.com/basho-labs/riak-mesos/metadata_manager"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AUDIT_LOG_NODE = "AuditLog"
+	// Request bodies and error responses are cut short after this many bytes
+	AUDIT_MAX_BODY     = 64 * 1024
+	AUDIT_MAX_RESPONSE = 1024
+	AUDIT_QUERY_LIMIT  = 100
+	// The oldest entries are removed once there are more than this many
+	AUDIT_MAX_ENTRIES = 10000
+	AUDIT_ANONYMOUS   = "anonymous"
+	// Set by an authenticating proxy in front of the scheduler. Only believed when the request comes from one of the
+	// trusted proxies, otherwise it's recorded as ClaimedActor.
+	AUDIT_USER_HEADER = "X-Remote-User"
+)
+
+const (
+	AUDIT_SUCCESS string = "success"
+	AUDIT_FAILURE string = "failure"
+)
+
+var auditClusterPath = regexp.MustCompile(`^/api/v1/clusters/([^/]+)`)
+
+// AuditEntry records a single mutating API call. Calls which made a new config revision are recorded as a diff
+// between the revisions, rather than with their body.
+type AuditEntry struct {
+	ID           int64
+	Time         time.Time
+	Actor        string
+	ClaimedActor string `json:",omitempty"`
+	RemoteAddr   string
+	Method       string
+	Path         string
+	Query        string `json:",omitempty"`
+	ClusterName  string `json:",omitempty"`
+	Body         string `json:",omitempty"`
+	Diff         string `json:",omitempty"`
+	Status       int
+	Outcome      string
+	Response     string `json:",omitempty"`
+}
+
+// AuditFilter narrows down GET /api/v1/audit. Zero values match everything.
+type AuditFilter struct {
+	ClusterName string
+	Actor       string
+	Since       time.Time
+	// Only entries older than this ID, to page back through the log
+	Before int64
+	Limit  int
+}
+
+// AuditLog is an append-only log of the mutating API calls, kept in Zookeeper with one node per entry. Only the
+// latest AUDIT_MAX_ENTRIES are kept.
+type AuditLog struct {
+	lock   *sync.Mutex
+	sc     *SchedulerCore
+	zkNode *metamgr.ZkNode
+	nextID int64
+	// IDs of the entries in Zookeeper, oldest first
+	ids []int64
+	// AUDIT_MAX_ENTRIES but in tests
+	maxEntries int
+	// Proxies whose AUDIT_USER_HEADER is believed
+	trustedProxies []*net.IPNet
+}
+
+func NewAuditLog(mgr *metamgr.MetadataManager, sc *SchedulerCore, trustedProxies []*net.IPNet) *AuditLog {
+	root := mgr.GetRootNode()
+	root.CreateChildIfNotExists(AUDIT_LOG_NODE)
+	zkNode, err := root.GetChild(AUDIT_LOG_NODE)
+	if err != nil {
+		log.Panic("Unable to open the audit log: ", err)
+	}
+	auditLog := &AuditLog{
+		lock:           &sync.Mutex{},
+		sc:             sc,
+		zkNode:         zkNode,
+		nextID:         1,
+		maxEntries:     AUDIT_MAX_ENTRIES,
+		trustedProxies: trustedProxies,
+	}
+	names, err := zkNode.GetChildNames()
+	if err != nil {
+		log.Panic("Unable to list the audit log: ", err)
+	}
+	for _, name := range names {
+		id, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			log.Warnf("Skipping unknown audit entry %s", name)
+			continue
+		}
+		auditLog.ids = append(auditLog.ids, id)
+		if id >= auditLog.nextID {
+			auditLog.nextID = id + 1
+		}
+	}
+	sort.Sort(int64s(auditLog.ids))
+	auditLog.prune()
+	return auditLog
+}
+
+// Middleware records every mutating request to the API before passing on the response
+func (auditLog *AuditLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAuditedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &AuditEntry{
+			Time:       time.Now(),
+			Actor:      auditLog.Actor(r),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+		}
+		if claimed := r.Header.Get(AUDIT_USER_HEADER); claimed != "" && claimed != entry.Actor {
+			entry.ClaimedActor = claimed
+		}
+		if match := auditClusterPath.FindStringSubmatch(r.URL.Path); match != nil {
+			entry.ClusterName = match[1]
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Warn("Unable to read request body for the audit log: ", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		before := auditLog.configRevision(entry.ClusterName)
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		entry.Status = recorder.statusCode()
+		entry.Outcome = AUDIT_SUCCESS
+		if entry.Status >= 400 {
+			entry.Outcome = AUDIT_FAILURE
+			entry.Response = truncate(strings.TrimSpace(recorder.body.String()), AUDIT_MAX_RESPONSE)
+		}
+		if entry.Diff = auditLog.configDiff(entry.ClusterName, before); entry.Diff == "" {
+			entry.Body = truncate(string(body), AUDIT_MAX_BODY)
+		}
+		auditLog.Append(entry)
+	})
+}
+
+// Append assigns the entry the next ID and stores it
+func (auditLog *AuditLog) Append(entry *AuditEntry) {
+	auditLog.lock.Lock()
+	defer auditLog.lock.Unlock()
+	entry.ID = auditLog.nextID
+	auditLog.nextID = auditLog.nextID + 1
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Error("Unable to encode audit entry: ", err)
+		return
+	}
+	if _, err := auditLog.zkNode.MakeChildWithData(auditEntryName(entry.ID), data, false); err != nil {
+		log.Error("Unable to persist audit entry: ", err)
+		return
+	}
+	auditLog.ids = append(auditLog.ids, entry.ID)
+	auditLog.prune()
+}
+
+// prune removes the oldest entries, beyond maxEntries
+func (auditLog *AuditLog) prune() {
+	for len(auditLog.ids) > auditLog.maxEntries {
+		if child, err := auditLog.zkNode.GetChild(auditEntryName(auditLog.ids[0])); err == nil {
+			child.Delete()
+		}
+		auditLog.ids = auditLog.ids[1:]
+	}
+}
+
+// Query returns the latest entries matching the filter, oldest first. Entries are read newest first, until there
+// are enough of them, or they're older than the filter's Since.
+func (auditLog *AuditLog) Query(filter *AuditFilter) []*AuditEntry {
+	auditLog.lock.Lock()
+	defer auditLog.lock.Unlock()
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = AUDIT_QUERY_LIMIT
+	}
+	matching := []*AuditEntry{}
+	for i := len(auditLog.ids) - 1; i >= 0 && len(matching) < limit; i-- {
+		if filter.Before > 0 && auditLog.ids[i] >= filter.Before {
+			continue
+		}
+		entry := auditLog.entry(auditLog.ids[i])
+		if entry == nil {
+			continue
+		}
+		if entry.Time.Before(filter.Since) {
+			break
+		}
+		if filter.ClusterName != "" && entry.ClusterName != filter.ClusterName {
+			continue
+		}
+		if filter.Actor != "" && entry.Actor != filter.Actor {
+			continue
+		}
+		matching = append(matching, entry)
+	}
+	// Oldest first
+	for i, j := 0, len(matching)-1; i < j; i, j = i+1, j-1 {
+		matching[i], matching[j] = matching[j], matching[i]
+	}
+	return matching
+}
+
+func (auditLog *AuditLog) entry(id int64) *AuditEntry {
+	child, err := auditLog.zkNode.GetChild(auditEntryName(id))
+	if err != nil {
+		log.Warnf("Unable to read audit entry %d: %v", id, err)
+		return nil
+	}
+	entry := &AuditEntry{}
+	if err := json.Unmarshal(child.GetData(), entry); err != nil {
+		log.Warnf("Skipping unreadable audit entry %d: %v", id, err)
+		return nil
+	}
+	return entry
+}
+
+func auditEntryName(id int64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+// configRevision is the cluster's current config revision, 0 if it has none
+func (auditLog *AuditLog) configRevision(clusterName string) int64 {
+	auditLog.sc.lock.Lock()
+	defer auditLog.sc.lock.Unlock()
+	if cluster, assigned := auditLog.sc.schedulerState.Clusters[clusterName]; assigned {
+		return cluster.ConfigRevision
+	}
+	return 0
+}
+
+// configDiff describes what changed since the cluster's config revision from, empty if its revision is the same
+func (auditLog *AuditLog) configDiff(clusterName string, from int64) string {
+	auditLog.sc.lock.Lock()
+	defer auditLog.sc.lock.Unlock()
+	cluster, assigned := auditLog.sc.schedulerState.Clusters[clusterName]
+	if !assigned || cluster.ConfigRevision == from || cluster.CurrentConfigRevision() == nil {
+		return ""
+	}
+	fromRevision := cluster.GetConfigRevision(from)
+	if fromRevision == nil {
+		fromRevision = &ConfigRevision{}
+	}
+	toRevision := cluster.CurrentConfigRevision()
+
+	var diff bytes.Buffer
+	for _, file := range []struct {
+		name     string
+		from, to string
+	}{
+		{"riak.conf", fromRevision.EffectiveRiakConfig(""), toRevision.EffectiveRiakConfig("")},
+		{"advanced.config", fromRevision.AdvancedConfig, toRevision.AdvancedConfig},
+		{"node settings", nodeSettingLines(fromRevision.NodeSettings), nodeSettingLines(toRevision.NodeSettings)},
+		{"variables", settingLines(fromRevision.Variables), settingLines(toRevision.Variables)},
+	} {
+		if fileDiff := lineDiff(file.from, file.to); fileDiff != "" {
+			fmt.Fprintf(&diff, "--- %s\n%s", file.name, fileDiff)
+		}
+	}
+	if diff.Len() == 0 {
+		fmt.Fprintf(&diff, "Config revision %d to %d, with the same contents\n", from, cluster.ConfigRevision)
+	}
+	return diff.String()
+}
+
+// settingLines lists the settings as "key = value" lines, sorted by key
+func settingLines(settings map[string]string) string {
+	lines := []string{}
+	for key, value := range settings {
+		lines = append(lines, fmt.Sprintf("%s = %s", key, value))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func nodeSettingLines(nodeSettings map[string]map[string]string) string {
+	lines := []string{}
+	for nodeID, settings := range nodeSettings {
+		for key, value := range settings {
+			lines = append(lines, fmt.Sprintf("%s: %s = %s", nodeID, key, value))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func parseAuditFilter(r *http.Request) (*AuditFilter, error) {
+	query := r.URL.Query()
+	filter := &AuditFilter{
+		ClusterName: query.Get("cluster"),
+		Actor:       query.Get("actor"),
+	}
+	if since := query.Get("since"); since != "" {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid since, expected an RFC 3339 time: %v", err)
+		}
+		filter.Since = sinceTime
+	}
+	if before := query.Get("before"); before != "" {
+		beforeID, err := strconv.ParseInt(before, 10, 64)
+		if err != nil || beforeID <= 0 {
+			return nil, fmt.Errorf("Invalid before, expected an audit entry ID: %s", before)
+		}
+		filter.Before = beforeID
+	}
+	if limit := query.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt <= 0 {
+			return nil, fmt.Errorf("Invalid limit: %s", limit)
+		}
+		filter.Limit = limitInt
+	}
+	return filter, nil
+}
+
+func isAuditedRequest(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return false
+	}
+	switch r.Method {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Actor is whoever the request says it's from: the basic auth user, or the user a trusted authenticating proxy
+// passed on
+func (auditLog *AuditLog) Actor(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	if user := r.Header.Get(AUDIT_USER_HEADER); user != "" && auditLog.fromTrustedProxy(r) {
+		return user
+	}
+	return AUDIT_ANONYMOUS
+}
+
+func (auditLog *AuditLog) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range auditLog.trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR blocks
+func ParseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	trusted := []*net.IPNet{}
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy = proxy + "/32"
+			} else {
+				proxy = proxy + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %s: %v", proxy, err)
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted, nil
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length] + "..."
+}
+
+// lineDiff describes the change from before to after, line by line, as "-" removed and "+" added lines
+func lineDiff(before string, after string) string {
+	a, b := strings.Split(before, "\n"), strings.Split(after, "\n")
+	// Longest common subsequence lengths of the suffixes of a and b
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff bytes.Buffer
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&diff, "-%s\n", a[i])
+			i = i + 1
+		default:
+			fmt.Fprintf(&diff, "+%s\n", b[j])
+			j = j + 1
+		}
+	}
+	return diff.String()
+}
+
+// auditResponseWriter keeps the status and the start of the body, so the outcome can be audited
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *auditResponseWriter) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *auditResponseWriter) Write(data []byte) (int, error) {
+	if remaining := AUDIT_MAX_RESPONSE - recorder.body.Len(); remaining > 0 {
+		if len(data) < remaining {
+			remaining = len(data)
+		}
+		recorder.body.Write(data[:remaining])
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+func (recorder *auditResponseWriter) statusCode() int {
+	if recorder.status == 0 {
+		return 200
+	}
+	return recorder.status
+}
+
+type int64s []int64
+
+func (a int64s) Len() int           { return len(a) }
+func (a int64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64s) Less(i, j int) bool { return a[i] < a[j] }
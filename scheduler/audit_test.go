@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func auditedTestRouter(sc *SchedulerCore) http.Handler {
+	router := mux.NewRouter()
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/config").HandlerFunc(sc.schedulerHTTPServer.setConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config").HandlerFunc(sc.schedulerHTTPServer.getConfig)
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/config/variables").HandlerFunc(sc.schedulerHTTPServer.setConfigVariables)
+	return sc.auditLog.Middleware(router)
+}
+
+func TestAuditConfigChange(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	cluster.reviseConfig("ring_size = 64\nstorage_backend = bitcask\n", "", "admin", "")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := auditedTestRouter(sc)
+
+	request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config", strings.NewReader("ring_size = 64\nstorage_backend = leveldb\n"))
+	request.SetBasicAuth("alice", "password")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Equal("ring_size = 64\nstorage_backend = leveldb\n", cluster.RiakConfig)
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/config", nil)
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	entries := sc.auditLog.Query(&AuditFilter{})
+	assert.Equal(1, len(entries))
+	assert.Equal("alice", entries[0].Actor)
+	assert.Equal("default", entries[0].ClusterName)
+	assert.Equal(AUDIT_SUCCESS, entries[0].Outcome)
+	assert.Equal("--- riak.conf\n-storage_backend = bitcask\n+storage_backend = leveldb\n", entries[0].Diff)
+	assert.Equal("", entries[0].Body)
+
+	// Every change which makes a config revision is diffed, not only those to the config files
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/config/variables", strings.NewReader(`{"tier": "gold"}`))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	entries = sc.auditLog.Query(&AuditFilter{})
+	assert.Equal(2, len(entries))
+	assert.Equal("--- variables\n-\n+tier = gold\n", entries[1].Diff)
+
+	// The log survives a restart of the scheduler
+	auditLog := NewAuditLog(sc.mgr, sc, nil)
+	assert.Equal(2, len(auditLog.Query(&AuditFilter{ClusterName: "default"})))
+	assert.Equal(0, len(auditLog.Query(&AuditFilter{Actor: "bob"})))
+}
+
+func TestAuditFailedRequest(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	router := auditedTestRouter(sc)
+
+	for i := 0; i < 3; i++ {
+		request, _ := http.NewRequest("POST", "/api/v1/clusters/missing/config", strings.NewReader("ring_size = 64"))
+		request.Header.Set(AUDIT_USER_HEADER, "bob")
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	entries := sc.auditLog.Query(&AuditFilter{Limit: 2})
+	assert.Equal(2, len(entries))
+	assert.Equal(int64(2), entries[0].ID)
+	assert.Equal(int64(3), entries[1].ID)
+	// Only a trusted proxy can say who the request is from
+	assert.Equal(AUDIT_ANONYMOUS, entries[1].Actor)
+	assert.Equal("bob", entries[1].ClaimedActor)
+	assert.Equal(404, entries[1].Status)
+	assert.Equal(AUDIT_FAILURE, entries[1].Outcome)
+	assert.Equal("Cluster missing not found", entries[1].Response)
+}
+
+func TestAuditRetentionAndPaging(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	sc.auditLog.maxEntries = 5
+	router := auditedTestRouter(sc)
+
+	for i := 0; i < 8; i++ {
+		request, _ := http.NewRequest("POST", "/api/v1/clusters/missing/config", strings.NewReader("ring_size = 64"))
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	// Only the latest entries are kept
+	entries := sc.auditLog.Query(&AuditFilter{})
+	assert.Equal(5, len(entries))
+	assert.Equal(int64(4), entries[0].ID)
+	assert.Equal(int64(8), entries[4].ID)
+	children, _ := sc.auditLog.zkNode.GetChildNames()
+	assert.Equal(5, len(children))
+
+	entries = sc.auditLog.Query(&AuditFilter{Limit: 2})
+	assert.Equal(int64(7), entries[0].ID)
+	entries = sc.auditLog.Query(&AuditFilter{Limit: 2, Before: entries[0].ID})
+	assert.Equal(2, len(entries))
+	assert.Equal(int64(5), entries[0].ID)
+	assert.Equal(int64(6), entries[1].ID)
+
+	// A restarted scheduler carries on from the latest entry, and still prunes the oldest
+	auditLog := NewAuditLog(sc.mgr, sc, nil)
+	auditLog.maxEntries = 5
+	auditLog.Append(&AuditEntry{Method: "POST"})
+	entries = auditLog.Query(&AuditFilter{})
+	assert.Equal(5, len(entries))
+	assert.Equal(int64(5), entries[0].ID)
+	assert.Equal(int64(9), entries[4].ID)
+}
+
+func TestAuditTrustedProxies(t *testing.T) {
+	assert := assert.New(t)
+	trusted, err := ParseTrustedProxies("10.0.0.1, 192.168.0.0/16")
+	assert.Nil(err)
+	_, err = ParseTrustedProxies("proxy.example.com")
+	assert.NotNil(err)
+	sc, _, _ := newTestSchedulerCore(false)
+	sc.auditLog.trustedProxies = trusted
+
+	for remoteAddr, actor := range map[string]string{
+		"10.0.0.1:34567":    "bob",
+		"192.168.4.2:34567": "bob",
+		"10.0.0.2:34567":    AUDIT_ANONYMOUS,
+	} {
+		request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set(AUDIT_USER_HEADER, "bob")
+		assert.Equal(actor, sc.auditLog.Actor(request), remoteAddr)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", lineDiff("a\nb", "a\nb"))
+	assert.Equal("+b\n", lineDiff("a\nc", "a\nb\nc"))
+	assert.Equal("-a\n", lineDiff("a\nb", "b"))
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/basho-labs/riak-mesos/common"
 	rexclient "github.com/basho-labs/riak-mesos/riak_explorer"
 	mesos "github.com/mesos/mesos-go/mesosproto"
+	"regexp"
+	"sort"
 	"time"
 )
 
@@ -19,6 +21,28 @@ type FrameworkRiakCluster struct {
 	IsKilled       bool
 	IsRestarting   bool
 	Generation     int64
+	// Ports for new nodes are picked from PortRange when the offer has enough of them
+	PortRange *common.PortRange
+	// Names for spare task ports, in addition to common.STANDARD_PORT_NAMES
+	PortNames       []string
+	VolumeRetention *VolumeRetention
+	// Nil for the executor's defaults
+	HealthCheck *common.HealthCheck `json:",omitempty"`
+	// Nil to ship the logs of nodes to their stdout and stderr
+	LogShipping *common.LogShipping `json:",omitempty"`
+	// Nil for the executor's defaults
+	StopSequence *common.StopSequence `json:",omitempty"`
+	// Nil to run nodes as the executor's user, without any isolation
+	ProcessSpec *common.ProcessSpec `json:",omitempty"`
+	// New nodes only put their volume on a dedicated MOUNT disk, never the root disk or a PATH disk
+	RequireMountDisk bool
+	// Every change to RiakConfig and AdvancedConfig is kept as a numbered revision, ConfigRevision is the current one
+	ConfigRevision  int64
+	ConfigRevisions []*ConfigRevision
+	// Set while a rolling restart applies a config revision, which stops if a restarted node fails
+	RestartConfigRevision int64 `json:",omitempty"`
+	// Why the last rolling restart was stopped, if it was
+	RestartHalted string `json:",omitempty"`
 }
 
 func NewFrameworkRiakCluster(name string) *FrameworkRiakCluster {
@@ -31,35 +55,61 @@ func NewFrameworkRiakCluster(name string) *FrameworkRiakCluster {
 		log.Error("Unable to open up riak.conf: ", err)
 	}
 
-	return &FrameworkRiakCluster{
-		Nodes:          make(map[string]*FrameworkRiakNode),
-		Graveyard:      make(map[string]*FrameworkRiakNode),
-		Name:           name,
-		AdvancedConfig: string(advancedConfig),
-		RiakConfig:     string(riakConfig),
-		IsKilled:       false,
-		IsRestarting:   false,
-		Generation:     0,
+	cluster := &FrameworkRiakCluster{
+		Nodes:        make(map[string]*FrameworkRiakNode),
+		Graveyard:    make(map[string]*FrameworkRiakNode),
+		Name:         name,
+		IsKilled:     false,
+		IsRestarting: false,
+		Generation:   0,
 	}
+	cluster.reviseConfig(string(riakConfig), string(advancedConfig), "", "")
+	return cluster
 }
 
 // --- Resources ---
 
+var portNamePattern = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+// SetPortNames replaces the cluster's own port names. Standard names are skipped, since every node has them already
+func (frc *FrameworkRiakCluster) SetPortNames(names []string) error {
+	portNames := []string{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !portNamePattern.MatchString(name) {
+			return fmt.Errorf("Invalid port name %q, names must be lowercase letters, digits and underscores", name)
+		}
+		if common.IsStandardPortName(name) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		portNames = append(portNames, name)
+	}
+	if len(common.STANDARD_PORT_NAMES)+len(portNames) > PORTS_PER_TASK {
+		return fmt.Errorf("Too many port names, each node only has %d ports", PORTS_PER_TASK)
+	}
+	frc.PortNames = portNames
+	return nil
+}
+
+// AllPortNames lists the standard port names followed by the cluster's own
+func (frc *FrameworkRiakCluster) AllPortNames() []string {
+	names := append([]string{}, common.STANDARD_PORT_NAMES...)
+	return append(names, frc.PortNames...)
+}
+
+// ApplyOffer launches nodes which already have resources reserved on the offering agent. Nodes which
+// still need resources are placed by the SchedulerCore's PlacementStrategy, see placeNodes
 func (frc *FrameworkRiakCluster) ApplyOffer(offerHelper *common.OfferHelper, sc *SchedulerCore) bool {
+	// Nothing is launched in a cluster until all of its tasks have been reconciled
+	if frc.IsReconciling() {
+		return true
+	}
+
 	stateDirty := false
 	clusterNeedsReconciliation := false
 	for _, riakNode := range frc.Nodes {
-		if riakNode.NeedsToBeReconciled() {
-			clusterNeedsReconciliation = true
-			continue
-		}
-
-		// Try to lanch, compatibilityMode is true
-		if riakNode.CanBeScheduled() && sc.compatibilityMode {
-			log.Infof("Adding Riak node for scheduling (compatibilityMode): %+v", riakNode.CurrentID())
-			if riakNode.ApplyReservedOffer(offerHelper, sc) {
-				stateDirty = true
-			}
+		if sc.compatibilityMode {
 			continue
 		}
 
@@ -87,14 +137,6 @@ func (frc *FrameworkRiakCluster) ApplyOffer(offerHelper *common.OfferHelper, sc
 			stateDirty = true
 			continue
 		}
-
-		// New node, needs reservation
-		if riakNode.CanBeScheduled() && !riakNode.HasRequestedReservation() && !sc.compatibilityMode {
-			log.Infof("Adding Riak node for scheduling (no reservations): %+v", riakNode.CurrentID())
-			if riakNode.ApplyUnreservedOffer(offerHelper) {
-				stateDirty = true
-			}
-		}
 	}
 
 	if stateDirty {
@@ -104,6 +146,21 @@ func (frc *FrameworkRiakCluster) ApplyOffer(offerHelper *common.OfferHelper, sc
 	return clusterNeedsReconciliation
 }
 
+// GetNodesToPlace returns the nodes which need to be given resources from a new agent, ordered by SimpleId
+func (frc *FrameworkRiakCluster) GetNodesToPlace(compatibilityMode bool) []*FrameworkRiakNode {
+	nodesToPlace := []*FrameworkRiakNode{}
+	if frc.IsReconciling() {
+		return nodesToPlace
+	}
+	for _, riakNode := range frc.Nodes {
+		if riakNode.CanBeScheduled() && (compatibilityMode || !riakNode.HasRequestedReservation()) {
+			nodesToPlace = append(nodesToPlace, riakNode)
+		}
+	}
+	sort.Sort(nodesBySimpleId(nodesToPlace))
+	return nodesToPlace
+}
+
 // --- State ---
 
 func (frc *FrameworkRiakCluster) RollingRestart() {
@@ -112,6 +169,33 @@ func (frc *FrameworkRiakCluster) RollingRestart() {
 	}
 	frc.IsRestarting = true
 	frc.Generation = frc.Generation + 1
+	frc.RestartHalted = ""
+}
+
+// ApplyConfigRevision restarts the nodes one at a time, to run them with the config revision. Nodes which were
+// started with the revision already are left alone. Like RollingRestart, it does nothing if already restarting.
+func (frc *FrameworkRiakCluster) ApplyConfigRevision(revision int64) {
+	if frc.IsRestarting {
+		return
+	}
+	frc.RollingRestart()
+	frc.RestartConfigRevision = revision
+}
+
+// haltRestart stops a rolling restart which is applying a config revision, when a node restarted with it fails
+func (frc *FrameworkRiakCluster) haltRestart(riakNode *FrameworkRiakNode, status *mesos.TaskStatus, sc *SchedulerCore) {
+	if !frc.IsRestarting || frc.RestartConfigRevision == 0 || riakNode.RestartGeneration < frc.Generation {
+		return
+	}
+	frc.RestartHalted = fmt.Sprintf("Node %s failed with config revision %d: %s %s", riakNode.CurrentID(), riakNode.ConfigRevision, status.GetState(), status.GetMessage())
+	log.Error("Stopping rolling restart. ", frc.RestartHalted)
+	frc.IsRestarting = false
+	frc.RestartConfigRevision = 0
+	sc.events.publishNodeEvent(EVENT_RESTART_PROGRESS, riakNode, map[string]interface{}{
+		"Generation": frc.Generation,
+		"Halted":     true,
+		"Reason":     frc.RestartHalted,
+	})
 }
 
 func (frc *FrameworkRiakCluster) KillNext() {
@@ -126,6 +210,25 @@ func (frc *FrameworkRiakCluster) GetNodes() map[string]*FrameworkRiakNode {
 	return frc.Nodes
 }
 
+// IsReconciling is true while any node of the cluster is waiting on reconciliation
+func (frc *FrameworkRiakCluster) IsReconciling() bool {
+	for _, riakNode := range frc.Nodes {
+		if riakNode.NeedsToBeReconciled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (frc *FrameworkRiakCluster) NeedsOffers() bool {
+	for _, riakNode := range frc.Nodes {
+		if riakNode.CanBeScheduled() || riakNode.NeedsToBeReconciled() {
+			return true
+		}
+	}
+	return false
+}
+
 // --- Values ---
 func (frc *FrameworkRiakCluster) GetNextSimpleId() int {
 	return len(frc.Nodes) + len(frc.Graveyard) + 1
@@ -148,10 +251,27 @@ func (frc *FrameworkRiakCluster) HasNode(riakNodeID string) bool {
 
 func (frc *FrameworkRiakCluster) RemoveNode(riakNode *FrameworkRiakNode) {
 	log.Infof("Removing node: %+v", riakNode.CurrentID())
+	riakNode.RemovedAt = time.Now()
 	frc.Graveyard[riakNode.CurrentID()] = riakNode
 	delete(frc.Nodes, riakNode.CurrentID())
 }
 
+// restartTaskData is the node's TaskData with the cluster's current config revision, health check, log shipping and
+// stop sequence. Its ports are kept, as they're those of the node's task.
+func (frc *FrameworkRiakCluster) restartTaskData(riakNode *FrameworkRiakNode) common.TaskData {
+	taskData := riakNode.TaskData
+	taskData.ConfigRevision = frc.ConfigRevision
+	taskData.Variables = nil
+	if revision := frc.CurrentConfigRevision(); revision != nil {
+		taskData.Variables = revision.Variables
+	}
+	taskData.HealthCheck = frc.HealthCheck
+	taskData.LogShipping = frc.LogShipping
+	taskData.StopSequence = frc.StopSequence
+	taskData.ProcessSpec = frc.ProcessSpec
+	return taskData
+}
+
 func (frc *FrameworkRiakCluster) GetNodesToRestart() (map[string]*FrameworkRiakNode, bool) {
 	nodesToRestart := make(map[string]*FrameworkRiakNode)
 	stateModified := false
@@ -165,6 +285,12 @@ func (frc *FrameworkRiakCluster) GetNodesToRestart() (map[string]*FrameworkRiakN
 	log.Info("Cluster restarting, checking for nodes to restart.")
 
 	for _, riakNode := range frc.Nodes {
+		if frc.RestartConfigRevision != 0 && riakNode.ConfigRevision == frc.RestartConfigRevision &&
+			riakNode.HasRestarted(riakNode.RestartGeneration) && riakNode.RestartGeneration < frc.Generation {
+			// Already running the config being applied
+			riakNode.RestartGeneration = frc.Generation
+			stateModified = true
+		}
 		if riakNode.HasRestarted(frc.Generation) {
 			log.Infof("Found a node that is already restarted: %+v", riakNode.CurrentID())
 			alreadyRestarted = alreadyRestarted + 1
@@ -193,6 +319,7 @@ func (frc *FrameworkRiakCluster) GetNodesToRestart() (map[string]*FrameworkRiakN
 	log.Infof("Finished checking nodes for restarts, currentlyRestarting: %v, alreadyRestarted: %+v, length of nodes: %v", currentlyRestarting, alreadyRestarted, len(frc.Nodes))
 	if alreadyRestarted == len(frc.Nodes) {
 		frc.IsRestarting = false
+		frc.RestartConfigRevision = 0
 		stateModified = true
 	}
 
@@ -215,31 +342,34 @@ func (frc *FrameworkRiakCluster) GetNodesToKillOrRemove() (map[string]*Framework
 	return nodesToKill, nodesToRemove
 }
 
-func (frc *FrameworkRiakCluster) GetNodeTasksToReconcile() []*mesos.TaskStatus {
+// GetNodeTasksToReconcile returns the tasks of pending nodes which are due another explicit reconciliation request
+func (frc *FrameworkRiakCluster) GetNodeTasksToReconcile(now time.Time) []*mesos.TaskStatus {
 	tasksToReconcile := []*mesos.TaskStatus{}
 
 	for _, riakNode := range frc.Nodes {
-		if riakNode.GetTaskStatus() != nil {
-			if riakNode.reconciled == false && time.Since(riakNode.lastAskedToReconcile).Seconds() > 5 {
-				riakNode.lastAskedToReconcile = time.Now()
-				tasksToReconcile = append(tasksToReconcile, riakNode.GetTaskStatus())
-			}
+		if !riakNode.NeedsToBeReconciled() || riakNode.GetTaskStatus() == nil {
+			continue
 		}
+		if nextAttempt := riakNode.Reconciliation.NextAttempt; nextAttempt != nil && now.Before(*nextAttempt) {
+			continue
+		}
+		riakNode.askedToReconcile(now)
+		tasksToReconcile = append(tasksToReconcile, riakNode.GetTaskStatus())
 	}
 
 	return tasksToReconcile
 }
 
-func (frc *FrameworkRiakCluster) HandleNodeStatusUpdate(status *mesos.TaskStatus) {
+func (frc *FrameworkRiakCluster) HandleNodeStatusUpdate(status *mesos.TaskStatus, sc *SchedulerCore) {
 	deadNode, updateForDeadNode := frc.Graveyard[status.TaskId.GetValue()]
 
 	if updateForDeadNode {
-		log.Warnf("Status update is for a node that's already been killed, ignoring. Node: ", deadNode)
+		log.Warnf("Status update is for a node that's already been killed, ignoring. Node: %+v", deadNode.CurrentID())
 		return
 	}
 
 	riakNode, _ := frc.Nodes[status.TaskId.GetValue()]
-	riakNode.reconciled = true
+	riakNode.Reconciled()
 	riakNode.TaskStatus = status
 	riakNode.SlaveID = status.SlaveId
 
@@ -249,41 +379,62 @@ func (frc *FrameworkRiakCluster) HandleNodeStatusUpdate(status *mesos.TaskStatus
 	case mesos.TaskState_TASK_STARTING:
 		riakNode.Start()
 	case mesos.TaskState_TASK_RUNNING:
-		frc.Join(riakNode)
+		if riakNode.updateHealth(status) {
+			sc.events.publishNodeEvent(EVENT_NODE_HEALTH, riakNode, map[string]interface{}{
+				"Healthy": riakNode.Health.Healthy,
+				"Message": riakNode.Health.Message,
+			})
+		}
+		// Health updates of a node which is already running, or is restarting in place, don't join it again
+		if !riakNode.IsRunning() && riakNode.hasRestartedInPlace(status) && frc.Join(riakNode) {
+			sc.events.publishNodeEvent(EVENT_NODE_JOINED, riakNode, nil)
+		}
 	case mesos.TaskState_TASK_FINISHED:
 		riakNode.Finish()
-		if !riakNode.IsRestarting(frc.Generation) {
-			frc.Leave(riakNode)
+		if !riakNode.IsRestarting(frc.Generation) && frc.Leave(riakNode) {
+			sc.events.publishNodeEvent(EVENT_NODE_LEFT, riakNode, nil)
 		}
 	case mesos.TaskState_TASK_FAILED:
 		// frc.Leave(riakNode)
 		riakNode.Fail()
+		frc.haltRestart(riakNode, status, sc)
 	case mesos.TaskState_TASK_KILLED:
-		frc.Leave(riakNode)
+		if frc.Leave(riakNode) {
+			sc.events.publishNodeEvent(EVENT_NODE_LEFT, riakNode, nil)
+		}
 		riakNode.Kill()
 	case mesos.TaskState_TASK_LOST:
 		// frc.Leave(riakNode)
 		riakNode.Lost()
+		frc.haltRestart(riakNode, status, sc)
 	case mesos.TaskState_TASK_ERROR:
 		// frc.Leave(riakNode)
 		riakNode.Error()
+		frc.haltRestart(riakNode, status, sc)
 	default:
-		log.Warn("Received unknown status update: %+v", status)
+		log.Warnf("Received unknown status update: %+v", status)
 	}
+
+	sc.events.publishNodeEvent(EVENT_NODE_STATE, riakNode, map[string]interface{}{
+		"State":        status.GetState().String(),
+		"CurrentState": riakNode.CurrentState,
+		"Message":      status.GetMessage(),
+	})
 }
 
-func (frc *FrameworkRiakCluster) Join(newNode *FrameworkRiakNode) {
+// Join marks the node as running, and joins it to the rest of the cluster. Returns true if it was joined.
+func (frc *FrameworkRiakCluster) Join(newNode *FrameworkRiakNode) bool {
 	if !newNode.CanJoinCluster() {
 		// The node doesn't want to be part of a cluster?
 		log.Infof("Node is now running, but doesn't need to join a cluster right now: %+v", newNode)
 		newNode.Run()
-		return
+		return false
 	}
 
 	if len(frc.Nodes) == 1 {
 		// Cluster of one
 		newNode.Run()
-		return
+		return false
 	}
 
 	joinSuccess := false
@@ -302,12 +453,14 @@ func (frc *FrameworkRiakCluster) Join(newNode *FrameworkRiakNode) {
 		// We're running now, but we can't join the cluster for some reason
 		log.Info("Node is now running, but cannot find a node to join.")
 	}
+	return joinSuccess
 }
 
-func (frc *FrameworkRiakCluster) Leave(leavingNode *FrameworkRiakNode) {
+// Leave removes the node from the rest of the cluster. Returns true if it was removed.
+func (frc *FrameworkRiakCluster) Leave(leavingNode *FrameworkRiakNode) bool {
 	// Cluster of one
 	if len(frc.Nodes) == 1 {
-		return
+		return false
 	}
 
 	leaveSuccess := false
@@ -324,6 +477,7 @@ func (frc *FrameworkRiakCluster) Leave(leavingNode *FrameworkRiakNode) {
 		// We're running now, but we can't join the cluster for some reason
 		log.Warnf("Attempted to remove node from cluster, but was unable to. Cluster Nodes: %+v", frc.Nodes)
 	}
+	return leaveSuccess
 }
 
 // --- Utility ---
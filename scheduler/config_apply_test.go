@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"errors"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/scheduler/process_state"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replyToRestart answers the restart the node's executor was sent, and waits for the scheduler to act on the reply
+func replyToRestart(t *testing.T, sc *SchedulerCore, driver *fakeSchedulerDriver, node *FrameworkRiakNode, replyErr error) common.FrameworkMessage {
+	message, err := common.DeserializeFrameworkMessage(driver.messages[len(driver.messages)-1].Data)
+	assert.Nil(t, err)
+	assert.Equal(t, common.COMMAND_RESTART, message.Command)
+	reply, _ := common.NewFrameworkReply(message, node.CurrentID(), nil, replyErr).Serialize()
+	sc.FrameworkMessage(driver, node.CreateExecutorID(), node.SlaveID, reply)
+	waitForScheduler(t, sc, "the restart reply", func() bool { return !node.restartRequested })
+	return message
+}
+
+// restartTestNode takes a running node through an in-place restart, up to its executor's status update once Riak
+// was restarted
+func restartTestNode(t *testing.T, sc *SchedulerCore, driver *fakeSchedulerDriver, node *FrameworkRiakNode, state mesos.TaskState) {
+	sc.rServer.killTasks()
+	assert.Equal(t, process_state.Restarting, node.DestinationState)
+	// Until the executor accepts the restart, the node is as it was
+	assert.Equal(t, process_state.Started, node.CurrentState)
+	replyToRestart(t, sc, driver, node, nil)
+	assert.Equal(t, process_state.Restarting, node.CurrentState)
+	restartedTestNode(sc, driver, node, state)
+}
+
+// restartedTestNode sends the status update of an executor which restarted Riak in place
+func restartedTestNode(sc *SchedulerCore, driver *fakeSchedulerDriver, node *FrameworkRiakNode, state mesos.TaskState) {
+	status := newTestStatus(node, state)
+	status.Data, _ = (&common.TaskStatusData{RexPort: node.TaskData.HTTPPort, InPlaceRestarts: node.InPlaceRestarts}).Serialize()
+	sc.StatusUpdate(driver, status)
+	driver.reset()
+}
+
+func TestApplyConfigRolling(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	assert.Equal(int64(1), node.ConfigRevision)
+	router := configTestRouter(sc)
+
+	request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config?apply=rolling", strings.NewReader("ring_size = 128\n"))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(202, recorder.Code)
+	assert.True(cluster.IsRestarting)
+	assert.Equal(int64(2), cluster.RestartConfigRevision)
+
+	// A node started with the new config already isn't restarted
+	other := cluster.CreateNode(sc)
+	other.Run()
+	other.ConfigRevision = 2
+
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/config?apply=rolling", strings.NewReader("ring_size = 256\n"))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(409, recorder.Code)
+	assert.Equal(int64(2), cluster.ConfigRevision)
+
+	restartTestNode(t, sc, driver, node, mesos.TaskState_TASK_RUNNING)
+	assert.Equal(int64(2), node.ConfigRevision)
+	assert.Equal(process_state.Started, other.CurrentState)
+	sc.rServer.killTasks()
+	assert.False(cluster.IsRestarting)
+	assert.Equal(int64(0), cluster.RestartConfigRevision)
+	assert.Equal("", cluster.RestartHalted)
+
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/config?apply=immediately", strings.NewReader("ring_size = 256\n"))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+}
+
+func TestApplyConfigHaltsOnFailure(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	events, _ := sc.events.Subscribe(0)
+	router := configTestRouter(sc)
+
+	// The node is running revision 1, rolling back to it still restarts the node with the new revision 3
+	request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config", strings.NewReader("ring_size = 128\n"))
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/config/revisions/1/rollback?apply=rolling", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(202, recorder.Code)
+	assert.True(cluster.IsRestarting)
+
+	restartTestNode(t, sc, driver, node, mesos.TaskState_TASK_FAILED)
+	assert.False(cluster.IsRestarting)
+	assert.Contains(cluster.RestartHalted, node.CurrentID())
+	assert.Equal(int64(3), node.ConfigRevision)
+
+	halted := false
+	for len(events) > 0 {
+		event := <-events
+		if event.Type == EVENT_RESTART_PROGRESS && event.Data["Halted"] == true {
+			halted = true
+			assert.Equal(WEBHOOK_RESTART_HALTED, webhookEvent(event))
+		}
+	}
+	assert.True(halted)
+}
+
+func TestRestartInPlace(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	taskID := node.TaskData.FullyQualifiedNodeName
+	_, configErrors := cluster.SetConfigVariables(map[string]string{"tier": "gold"}, "")
+	assert.Equal(0, len(configErrors))
+	cluster.ApplyConfigRevision(cluster.ConfigRevision)
+
+	sc.rServer.killTasks()
+	assert.Equal(1, len(driver.messages))
+	// The node isn't asked again while its executor hasn't replied
+	sc.rServer.killTasks()
+	assert.Equal(1, len(driver.messages))
+	message := replyToRestart(t, sc, driver, node, nil)
+	assert.Equal(process_state.Restarting, node.CurrentState)
+	assert.Equal(int64(1), node.InPlaceRestarts)
+	taskData := message.TaskData
+	assert.Equal(int64(2), taskData.ConfigRevision)
+	assert.Equal(map[string]string{"tier": "gold"}, taskData.Variables)
+	assert.Equal(node.TaskData.NamedPorts, taskData.NamedPorts)
+
+	// A health update the executor sent before restarting doesn't finish the restart
+	status := newTestStatus(node, mesos.TaskState_TASK_RUNNING)
+	status.Healthy = proto.Bool(true)
+	sc.StatusUpdate(driver, status)
+	assert.Equal(process_state.Restarting, node.CurrentState)
+	assert.True(cluster.IsRestarting)
+
+	restartedTestNode(sc, driver, node, mesos.TaskState_TASK_RUNNING)
+	assert.Equal(process_state.Started, node.CurrentState)
+	assert.Equal(int64(2), node.ConfigRevision)
+	assert.Equal(taskID, node.TaskData.FullyQualifiedNodeName)
+	sc.rServer.killTasks()
+	assert.False(cluster.IsRestarting)
+	// Nothing was launched, the node kept its task
+	assert.Equal(0, len(driver.accepted))
+}
+
+func TestRemoveNodeRestartingInPlace(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	node.RestartInPlace(cluster.restartTaskData(node))
+
+	// The task is finished, but the node stays until the task says it's done
+	node.KillNext()
+	nodesToKill, nodesToRemove := cluster.GetNodesToKillOrRemove()
+	assert.Equal(1, len(nodesToKill))
+	assert.Equal(0, len(nodesToRemove))
+	sc.rServer.killTasks()
+	assert.Equal(1, len(cluster.Nodes))
+	assert.Equal(0, len(cluster.Graveyard))
+
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_FINISHED))
+	assert.Equal(process_state.Shutdown, node.CurrentState)
+	_, nodesToRemove = cluster.GetNodesToKillOrRemove()
+	assert.Equal(1, len(nodesToRemove))
+}
+
+func TestRestartInPlaceFallsBackToFinish(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	cluster.RollingRestart()
+
+	// An executor which couldn't render the new configs keeps Riak running, so its task is finished instead
+	sc.rServer.killTasks()
+	replyToRestart(t, sc, driver, node, errors.New("Unable to render riak.conf"))
+	assert.Equal(process_state.Started, node.CurrentState)
+	assert.Equal(int64(0), node.InPlaceRestarts)
+	assert.Equal(2, len(driver.messages))
+	message, err := common.DeserializeFrameworkMessage(driver.messages[1].Data)
+	assert.Nil(err)
+	assert.Equal(common.COMMAND_FINISH, message.Command)
+	driver.reset()
+
+	// As is the task of one which doesn't reply
+	node.Run()
+	cluster.IsRestarting = false
+	cluster.RollingRestart()
+	sc.rServer.restartTimeout = 10 * time.Millisecond
+	sc.rServer.killTasks()
+	waitForScheduler(t, sc, "the restart to time out", func() bool { return !node.restartRequested })
+	assert.Equal(process_state.Started, node.CurrentState)
+	assert.Equal(2, len(driver.messages))
+	message, err = common.DeserializeFrameworkMessage(driver.messages[1].Data)
+	assert.Nil(err)
+	assert.Equal(common.COMMAND_FINISH, message.Command)
+}
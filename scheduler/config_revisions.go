@@ -0,0 +1,274 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Revisions older than this many are dropped, unless a node was started with them
+const CONFIG_REVISION_HISTORY = 25
+
+// ?apply= options of the config endpoints. Without one, nodes pick up a new config when they next restart.
+const (
+	CONFIG_APPLY_ROLLING string = "rolling" // Restart the nodes one at a time with the new config
+)
+
+// ConfigRevision is a numbered snapshot of a cluster's riak.conf and advanced.config. Revisions are never
+// changed once made; rolling back makes a new revision with the contents of an old one.
+type ConfigRevision struct {
+	Revision       int64
+	Time           time.Time
+	Author         string
+	Message        string `json:",omitempty"`
+	RiakConfig     string
+	AdvancedConfig string
+	// riak.conf settings merged over RiakConfig, for every node and by node ID
+	Settings     map[string]string            `json:",omitempty"`
+	NodeSettings map[string]map[string]string `json:",omitempty"`
+	// {{.Vars.name}} in either template
+	Variables map[string]string `json:",omitempty"`
+}
+
+// ConfigRevisionSummary is a revision as listed by the API, with the nodes which were started with it
+type ConfigRevisionSummary struct {
+	Revision int64
+	Time     time.Time
+	Author   string
+	Message  string `json:",omitempty"`
+	Current  bool
+	Nodes    []string
+}
+
+// ConfigDiff diffs the riak.conf of two revisions with the cluster's settings merged in, and their advanced.config
+type ConfigDiff struct {
+	From           int64
+	To             int64
+	RiakConfig     string
+	AdvancedConfig string
+}
+
+// reviseConfig makes a new revision if either config changed, and returns the current revision
+func (frc *FrameworkRiakCluster) reviseConfig(riakConfig string, advancedConfig string, author string, message string) *ConfigRevision {
+	return frc.revise(func(revision *ConfigRevision) {
+		revision.RiakConfig = riakConfig
+		revision.AdvancedConfig = advancedConfig
+	}, author, message)
+}
+
+// revise makes a new revision by changing a copy of the current one. If nothing changed, the current revision is
+// returned instead.
+func (frc *FrameworkRiakCluster) revise(change func(revision *ConfigRevision), author string, message string) *ConfigRevision {
+	current := frc.GetConfigRevision(frc.ConfigRevision)
+	revision := &ConfigRevision{}
+	if current != nil {
+		revision = current.copyContents()
+	}
+	change(revision)
+	revision.normalize()
+	if current != nil && current.sameContents(revision) {
+		return current
+	}
+	revision.Revision = frc.ConfigRevision + 1
+	revision.Time = time.Now()
+	revision.Author = author
+	revision.Message = message
+	frc.ConfigRevisions = append(frc.ConfigRevisions, revision)
+	frc.ConfigRevision = revision.Revision
+	frc.RiakConfig = revision.RiakConfig
+	frc.AdvancedConfig = revision.AdvancedConfig
+	frc.pruneConfigRevisions()
+	return revision
+}
+
+// configSnapshot is a cluster's config before a change, to undo the change if it can't be persisted. It includes
+// the rolling restart, which ?apply=rolling may have started.
+type configSnapshot struct {
+	configRevision        int64
+	configRevisions       []*ConfigRevision
+	riakConfig            string
+	advancedConfig        string
+	isRestarting          bool
+	generation            int64
+	restartConfigRevision int64
+	restartHalted         string
+}
+
+func (frc *FrameworkRiakCluster) snapshotConfig() *configSnapshot {
+	return &configSnapshot{
+		configRevision:        frc.ConfigRevision,
+		configRevisions:       append([]*ConfigRevision{}, frc.ConfigRevisions...),
+		riakConfig:            frc.RiakConfig,
+		advancedConfig:        frc.AdvancedConfig,
+		isRestarting:          frc.IsRestarting,
+		generation:            frc.Generation,
+		restartConfigRevision: frc.RestartConfigRevision,
+		restartHalted:         frc.RestartHalted,
+	}
+}
+
+// restoreConfig puts the cluster's config back as it was when the snapshot was taken
+func (frc *FrameworkRiakCluster) restoreConfig(snapshot *configSnapshot) {
+	frc.ConfigRevision = snapshot.configRevision
+	frc.ConfigRevisions = snapshot.configRevisions
+	frc.RiakConfig = snapshot.riakConfig
+	frc.AdvancedConfig = snapshot.advancedConfig
+	frc.IsRestarting = snapshot.isRestarting
+	frc.Generation = snapshot.generation
+	frc.RestartConfigRevision = snapshot.restartConfigRevision
+	frc.RestartHalted = snapshot.restartHalted
+}
+
+// RollbackConfig makes a new revision with the configs and settings of an earlier one
+func (frc *FrameworkRiakCluster) RollbackConfig(revision int64, author string) (*ConfigRevision, error) {
+	target := frc.GetConfigRevision(revision)
+	if target == nil {
+		return nil, fmt.Errorf("Config revision %d not found for cluster %s", revision, frc.Name)
+	}
+	return frc.revise(func(configRevision *ConfigRevision) {
+		*configRevision = *target.copyContents()
+	}, author, fmt.Sprintf("Rollback to revision %d", revision)), nil
+}
+
+// CurrentConfigRevision is nil only for clusters which were never given a config
+func (frc *FrameworkRiakCluster) CurrentConfigRevision() *ConfigRevision {
+	return frc.GetConfigRevision(frc.ConfigRevision)
+}
+
+// copyContents copies the configs, settings and variables of the revision, without its number, time, author or message
+func (revision *ConfigRevision) copyContents() *ConfigRevision {
+	copied := &ConfigRevision{
+		RiakConfig:     revision.RiakConfig,
+		AdvancedConfig: revision.AdvancedConfig,
+		Settings:       copySettings(revision.Settings),
+		NodeSettings:   make(map[string]map[string]string),
+		Variables:      copySettings(revision.Variables),
+	}
+	for nodeID, settings := range revision.NodeSettings {
+		copied.NodeSettings[nodeID] = copySettings(settings)
+	}
+	return copied
+}
+
+func (revision *ConfigRevision) sameContents(other *ConfigRevision) bool {
+	return revision.RiakConfig == other.RiakConfig &&
+		revision.AdvancedConfig == other.AdvancedConfig &&
+		reflect.DeepEqual(revision.Settings, other.Settings) &&
+		reflect.DeepEqual(revision.NodeSettings, other.NodeSettings) &&
+		reflect.DeepEqual(revision.Variables, other.Variables)
+}
+
+// normalize drops empty settings and variables, so revisions with and without them compare as the same
+func (revision *ConfigRevision) normalize() {
+	for nodeID, settings := range revision.NodeSettings {
+		if len(settings) == 0 {
+			delete(revision.NodeSettings, nodeID)
+		}
+	}
+	if len(revision.NodeSettings) == 0 {
+		revision.NodeSettings = nil
+	}
+	if len(revision.Settings) == 0 {
+		revision.Settings = nil
+	}
+	if len(revision.Variables) == 0 {
+		revision.Variables = nil
+	}
+}
+
+func copySettings(settings map[string]string) map[string]string {
+	copied := make(map[string]string)
+	for key, value := range settings {
+		copied[key] = value
+	}
+	return copied
+}
+
+// GetConfigRevision returns nil for revisions which were never made or have been pruned
+func (frc *FrameworkRiakCluster) GetConfigRevision(revision int64) *ConfigRevision {
+	for _, configRevision := range frc.ConfigRevisions {
+		if configRevision.Revision == revision {
+			return configRevision
+		}
+	}
+	return nil
+}
+
+func (frc *FrameworkRiakCluster) DiffConfigRevisions(from int64, to int64) (*ConfigDiff, error) {
+	fromRevision, toRevision := frc.GetConfigRevision(from), frc.GetConfigRevision(to)
+	if fromRevision == nil {
+		return nil, fmt.Errorf("Config revision %d not found for cluster %s", from, frc.Name)
+	}
+	if toRevision == nil {
+		return nil, fmt.Errorf("Config revision %d not found for cluster %s", to, frc.Name)
+	}
+	return &ConfigDiff{
+		From:           from,
+		To:             to,
+		RiakConfig:     lineDiff(fromRevision.EffectiveRiakConfig(""), toRevision.EffectiveRiakConfig("")),
+		AdvancedConfig: lineDiff(fromRevision.AdvancedConfig, toRevision.AdvancedConfig),
+	}, nil
+}
+
+// ConfigRevisionSummaries lists the revisions, newest first
+func (frc *FrameworkRiakCluster) ConfigRevisionSummaries() []*ConfigRevisionSummary {
+	nodesByRevision := frc.nodesByConfigRevision()
+	summaries := []*ConfigRevisionSummary{}
+	for i := len(frc.ConfigRevisions) - 1; i >= 0; i-- {
+		revision := frc.ConfigRevisions[i]
+		nodes := nodesByRevision[revision.Revision]
+		if nodes == nil {
+			nodes = []string{}
+		}
+		summaries = append(summaries, &ConfigRevisionSummary{
+			Revision: revision.Revision,
+			Time:     revision.Time,
+			Author:   revision.Author,
+			Message:  revision.Message,
+			Current:  revision.Revision == frc.ConfigRevision,
+			Nodes:    nodes,
+		})
+	}
+	return summaries
+}
+
+// nodesByConfigRevision returns the IDs of the nodes started with each revision
+func (frc *FrameworkRiakCluster) nodesByConfigRevision() map[int64][]string {
+	nodesByRevision := make(map[int64][]string)
+	for _, riakNode := range frc.Nodes {
+		if riakNode.ConfigRevision != 0 {
+			nodesByRevision[riakNode.ConfigRevision] = append(nodesByRevision[riakNode.ConfigRevision], riakNode.CurrentID())
+		}
+	}
+	for _, nodeIDs := range nodesByRevision {
+		sort.Strings(nodeIDs)
+	}
+	return nodesByRevision
+}
+
+func (frc *FrameworkRiakCluster) pruneConfigRevisions() {
+	if len(frc.ConfigRevisions) <= CONFIG_REVISION_HISTORY {
+		return
+	}
+	inUse := frc.nodesByConfigRevision()
+	excess := len(frc.ConfigRevisions) - CONFIG_REVISION_HISTORY
+	kept := []*ConfigRevision{}
+	for _, revision := range frc.ConfigRevisions {
+		if excess > 0 && revision.Revision != frc.ConfigRevision && inUse[revision.Revision] == nil {
+			excess = excess - 1
+			continue
+		}
+		kept = append(kept, revision)
+	}
+	frc.ConfigRevisions = kept
+}
+
+// addConfigRevisions gives clusters made before config revisions a first revision with their current configs
+func (ss *SchedulerState) addConfigRevisions() {
+	for _, cluster := range ss.Clusters {
+		if len(cluster.ConfigRevisions) == 0 {
+			cluster.reviseConfig(cluster.RiakConfig, cluster.AdvancedConfig, "", "")
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/gorilla/mux"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func configTestRouter(sc *SchedulerCore) *mux.Router {
+	schttp := sc.schedulerHTTPServer
+	router := mux.NewRouter()
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/config").HandlerFunc(schttp.setConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config").HandlerFunc(schttp.getConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/revisions").HandlerFunc(schttp.serveConfigRevisions)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/config/revisions/{revision}/rollback").HandlerFunc(schttp.rollbackConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/diff").HandlerFunc(schttp.serveConfigDiff)
+	return router
+}
+
+func TestConfigRevisionsAndRollback(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := configTestRouter(sc)
+	assert.Equal(int64(1), cluster.ConfigRevision)
+
+	request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config?message=leveldb", strings.NewReader("storage_backend = leveldb\n"))
+	request.SetBasicAuth("alice", "password")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(int64(2), cluster.ConfigRevision)
+	assert.Equal("storage_backend = leveldb\n", cluster.RiakConfig)
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/config/diff?from=2&to=1", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	diff := &ConfigDiff{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(diff))
+	assert.Contains(diff.RiakConfig, "-storage_backend = leveldb\n")
+	assert.Equal("", diff.AdvancedConfig)
+
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/config/revisions/1/rollback", nil)
+	request.SetBasicAuth("bob", "password")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Equal(int64(3), cluster.ConfigRevision)
+	assert.Equal(cluster.GetConfigRevision(1).RiakConfig, cluster.RiakConfig)
+
+	// Older revisions can still be fetched
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/config?revision=2", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal("storage_backend = leveldb\n", recorder.Body.String())
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/config/revisions", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	summaries := []*ConfigRevisionSummary{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&summaries))
+	assert.Equal(3, len(summaries))
+	assert.Equal(int64(3), summaries[0].Revision)
+	assert.True(summaries[0].Current)
+	assert.Equal("bob", summaries[0].Author)
+	assert.Equal("Rollback to revision 1", summaries[0].Message)
+	assert.Equal("alice", summaries[1].Author)
+	assert.Equal("leveldb", summaries[1].Message)
+
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/config/revisions/9/rollback", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(404, recorder.Code)
+}
+
+func TestNodeRecordsConfigRevision(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	cluster.reviseConfig("ring_size = 128\n", cluster.AdvancedConfig, "alice", "")
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal(int64(2), node.ConfigRevision)
+	taskData, err := common.DeserializeTaskData(accepted[0].Tasks[0].GetData())
+	assert.Nil(err)
+	assert.Equal(int64(2), taskData.ConfigRevision)
+
+	cluster.reviseConfig("ring_size = 256\n", cluster.AdvancedConfig, "alice", "")
+	summaries := cluster.ConfigRevisionSummaries()
+	assert.Equal([]string{}, summaries[0].Nodes)
+	assert.Equal([]string{node.CurrentID()}, summaries[1].Nodes)
+
+	// Revisions which nodes were started with outlive the history
+	for i := 0; i < CONFIG_REVISION_HISTORY; i++ {
+		cluster.reviseConfig(strings.Repeat("#\n", i), cluster.AdvancedConfig, "alice", "")
+	}
+	assert.Equal(CONFIG_REVISION_HISTORY, len(cluster.ConfigRevisions))
+	assert.NotNil(cluster.GetConfigRevision(2))
+	assert.Nil(cluster.GetConfigRevision(3))
+}
+
+func TestConfigRevisionAddedToOldClusters(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	sc.schedulerState.Clusters["default"] = &FrameworkRiakCluster{
+		Name:       "default",
+		Nodes:      make(map[string]*FrameworkRiakNode),
+		Graveyard:  make(map[string]*FrameworkRiakNode),
+		RiakConfig: "ring_size = 64\n",
+	}
+	sc.schedulerState.Persist()
+
+	cluster := GetSchedulerState(sc.mgr).Clusters["default"]
+	assert.Equal(int64(1), cluster.ConfigRevision)
+	assert.Equal("ring_size = 64\n", cluster.GetConfigRevision(1).RiakConfig)
+}
+
+func TestRestoreConfigSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	cluster := NewFrameworkRiakCluster("default")
+	for i := 1; i < CONFIG_REVISION_HISTORY; i++ {
+		cluster.reviseConfig(strings.Repeat("#\n", i), cluster.AdvancedConfig, "alice", "")
+	}
+	riakConfig := cluster.RiakConfig
+	snapshot := cluster.snapshotConfig()
+
+	// The new revision prunes the oldest, which the snapshot has to bring back
+	revision := cluster.reviseConfig("storage_backend = leveldb\n", "[].\n", "alice", "")
+	cluster.ApplyConfigRevision(revision.Revision)
+	assert.Nil(cluster.GetConfigRevision(1))
+	cluster.restoreConfig(snapshot)
+	assert.False(cluster.IsRestarting)
+	assert.Equal(int64(0), cluster.Generation)
+	assert.Equal(int64(0), cluster.RestartConfigRevision)
+	assert.Equal(int64(CONFIG_REVISION_HISTORY), cluster.ConfigRevision)
+	assert.Equal(CONFIG_REVISION_HISTORY, len(cluster.ConfigRevisions))
+	assert.NotNil(cluster.GetConfigRevision(1))
+	assert.Nil(cluster.GetConfigRevision(CONFIG_REVISION_HISTORY + 1))
+	assert.Equal(riakConfig, cluster.RiakConfig)
+	assert.Equal(cluster.GetConfigRevision(1).AdvancedConfig, cluster.AdvancedConfig)
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Written above the settings which weren't in the riak.conf template already
+const CONFIG_SETTINGS_HEADER = "## Settings from the riak-mesos API"
+
+// ConfigSettings are a cluster's riak.conf settings, as returned by the API
+type ConfigSettings struct {
+	Revision int64
+	Settings map[string]string
+	// Only returned for a node's settings, which are merged over the cluster's
+	NodeSettings map[string]string `json:",omitempty"`
+}
+
+// EffectiveRiakConfig is the riak.conf template with the revision's settings merged in, those of the node last.
+// This is what the node's executor renders.
+func (revision *ConfigRevision) EffectiveRiakConfig(nodeID string) string {
+	settings := copySettings(revision.Settings)
+	for key, value := range revision.NodeSettings[nodeID] {
+		settings[key] = value
+	}
+	return mergeRiakConfigSettings(revision.RiakConfig, settings)
+}
+
+// PatchConfigSettings makes a new revision with the patch applied to the settings of the cluster, or of the node if
+// nodeID is given. Keys patched to nil are removed, reverting to the template or the cluster's settings.
+func (frc *FrameworkRiakCluster) PatchConfigSettings(nodeID string, patch map[string]*string, author string) (*ConfigRevision, ConfigErrors) {
+	configErrors := ConfigErrors{}
+	for _, key := range sortedPatchKeys(patch) {
+		value := patch[key]
+		if !riakConfigKeyPattern.MatchString(key) {
+			configErrors = append(configErrors, &ConfigError{Message: fmt.Sprintf("Invalid key: %q", key)})
+		} else if value != nil && (strings.TrimSpace(*value) == "" || strings.ContainsAny(*value, "\r\n#")) {
+			configErrors = append(configErrors, &ConfigError{Message: fmt.Sprintf("Invalid value for %s: %q", key, *value)})
+		}
+	}
+	if len(configErrors) > 0 {
+		return nil, configErrors
+	}
+
+	current := frc.CurrentConfigRevision()
+	proposed := current.copyContents()
+	settings := proposed.Settings
+	if nodeID != "" {
+		settings = proposed.NodeSettings[nodeID]
+		if settings == nil {
+			settings = make(map[string]string)
+			proposed.NodeSettings[nodeID] = settings
+		}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(settings, key)
+		} else {
+			settings[key] = strings.TrimSpace(*value)
+		}
+	}
+
+	// Every node with settings of its own gets a different riak.conf, which all need to be valid
+	nodeIDs := []string{""}
+	for settingsNodeID := range proposed.NodeSettings {
+		nodeIDs = append(nodeIDs, settingsNodeID)
+	}
+	sort.Strings(nodeIDs)
+	for _, settingsNodeID := range nodeIDs {
+		for _, configError := range ValidateRiakConfig(frc, proposed.EffectiveRiakConfig(settingsNodeID)) {
+			if settingsNodeID != "" {
+				configError.Message = fmt.Sprintf("%s (riak.conf of %s)", configError.Message, settingsNodeID)
+			}
+			configErrors = append(configErrors, configError)
+		}
+	}
+	if len(configErrors) > 0 {
+		return nil, configErrors
+	}
+
+	message := "Patched settings"
+	if nodeID != "" {
+		message = fmt.Sprintf("Patched settings of %s", nodeID)
+	}
+	return frc.revise(func(revision *ConfigRevision) {
+		*revision = *proposed
+	}, author, message), nil
+}
+
+// mergeRiakConfigSettings replaces the value of each setting in the template, or adds the setting at the end if the
+// template doesn't have it
+func mergeRiakConfigSettings(config string, settings map[string]string) string {
+	if len(settings) == 0 {
+		return config
+	}
+	merged := make(map[string]bool)
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		separator := strings.Index(trimmed, "=")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || separator < 0 {
+			continue
+		}
+		key := strings.TrimSpace(trimmed[:separator])
+		if value, assigned := settings[key]; assigned {
+			lines[i] = fmt.Sprintf("%s = %s", key, value)
+			merged[key] = true
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(strings.TrimRight(strings.Join(lines, "\n"), "\n"))
+	keys := []string{}
+	for key := range settings {
+		if !merged[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		buffer.WriteString("\n\n" + CONFIG_SETTINGS_HEADER + "\n")
+		for _, key := range keys {
+			fmt.Fprintf(&buffer, "%s = %s\n", key, settings[key])
+		}
+	} else {
+		buffer.WriteString("\n")
+	}
+	return buffer.String()
+}
+
+func sortedPatchKeys(patch map[string]*string) []string {
+	keys := []string{}
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func settingsTestRouter(sc *SchedulerCore) *mux.Router {
+	schttp := sc.schedulerHTTPServer
+	router := configTestRouter(sc)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/settings").HandlerFunc(schttp.serveConfigSettings)
+	router.Methods("PATCH").Path("/api/v1/clusters/{cluster}/config/settings").HandlerFunc(schttp.patchConfigSettings)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/config").HandlerFunc(schttp.serveNodeConfig)
+	router.Methods("PATCH").Path("/api/v1/clusters/{cluster}/nodes/{node}/config/settings").HandlerFunc(schttp.patchConfigSettings)
+	return router
+}
+
+func TestMergeRiakConfigSettings(t *testing.T) {
+	assert := assert.New(t)
+	config := "## Ring size\nring_size = 64\n\nstorage_backend = bitcask\n"
+	assert.Equal(config, mergeRiakConfigSettings(config, nil))
+	assert.Equal("## Ring size\nring_size = 128\n\nstorage_backend = bitcask\n\n"+CONFIG_SETTINGS_HEADER+"\nanti_entropy = passive\n",
+		mergeRiakConfigSettings(config, map[string]string{"ring_size": "128", "anti_entropy": "passive"}))
+}
+
+func TestPatchConfigSettings(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	cluster.reviseConfig("ring_size = 64\nstorage_backend = bitcask\n", cluster.AdvancedConfig, "", "")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+	router := settingsTestRouter(sc)
+
+	request, _ := http.NewRequest("PATCH", "/api/v1/clusters/default/config/settings", strings.NewReader(`{"storage_backend": "leveldb", "anti_entropy": "passive"}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Equal(int64(3), cluster.ConfigRevision)
+
+	request, _ = http.NewRequest("PATCH", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/config/settings", strings.NewReader(`{"ring_size": "8"}`))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	settings := &ConfigSettings{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(settings))
+	assert.Equal(int64(4), settings.Revision)
+	assert.Equal(map[string]string{"ring_size": "8"}, settings.NodeSettings)
+
+	// The template itself is unchanged, the settings are merged in for each node
+	assert.Equal("ring_size = 64\nstorage_backend = bitcask\n", cluster.RiakConfig)
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/config", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal("ring_size = 8\nstorage_backend = leveldb\n\n"+CONFIG_SETTINGS_HEADER+"\nanti_entropy = passive\n", recorder.Body.String())
+
+	// Removing a setting reverts to the template
+	request, _ = http.NewRequest("PATCH", "/api/v1/clusters/default/config/settings", strings.NewReader(`{"anti_entropy": null, "storage_backend": null}`))
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/config/settings", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	settings = &ConfigSettings{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(settings))
+	assert.Equal(map[string]string{}, settings.Settings)
+	assert.Equal("ring_size = 64\nstorage_backend = bitcask\n", cluster.CurrentConfigRevision().EffectiveRiakConfig(""))
+
+	// The revision the node was started with is still served to it
+	node.ConfigRevision = 3
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/config", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Contains(recorder.Body.String(), "storage_backend = leveldb\n")
+	assert.Contains(recorder.Body.String(), "ring_size = 64\n")
+}
+
+func TestPatchConfigSettingsValidation(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := settingsTestRouter(sc)
+
+	for body, code := range map[string]int{
+		`{"ring size": "64"}`:           400,
+		`{"ring_size": "64\nfoo = 1"}`:  400,
+		`{"ring_size": ""}`:             400,
+		`{"ring_size": "{{.Missing}}"}`: 400,
+		`["ring_size"]`:                 400,
+	} {
+		request, _ := http.NewRequest("PATCH", "/api/v1/clusters/default/config/settings", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(code, recorder.Code, body)
+	}
+	assert.Equal(int64(1), cluster.ConfigRevision)
+
+	request, _ := http.NewRequest("PATCH", "/api/v1/clusters/default/nodes/missing/config/settings", strings.NewReader(`{"ring_size": "8"}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(404, recorder.Code)
+}
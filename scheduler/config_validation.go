@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/basho-labs/riak-mesos/common"
+	"regexp"
+	"strings"
+)
+
+// ConfigError is a problem found in an uploaded config. Line is 0 when it isn't on any particular line.
+type ConfigError struct {
+	Line    int
+	Message string
+}
+
+func (configError *ConfigError) Error() string {
+	if configError.Line == 0 {
+		return configError.Message
+	}
+	return fmt.Sprintf("line %d: %s", configError.Line, configError.Message)
+}
+
+// ConfigErrors is every problem found in a config, one per line of Error()
+type ConfigErrors []*ConfigError
+
+func (configErrors ConfigErrors) Error() string {
+	messages := []string{}
+	for _, configError := range configErrors {
+		messages = append(messages, configError.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// riak.conf keys are dot separated words, as cuttlefish parses them
+var riakConfigKeyPattern = regexp.MustCompile(`^(\\\.|[A-Za-z0-9_-])+(\.(\\\.|[A-Za-z0-9_-])+)*$`)
+
+// ValidateRiakConfig renders the riak.conf template the way the executor will, and checks the result is made up of
+// key = value settings. Line numbers are those of the rendered config, which match the template's unless its
+// actions add or remove lines.
+func ValidateRiakConfig(cluster *FrameworkRiakCluster, config string) ConfigErrors {
+	return validateRiakConfig(cluster, config, currentConfigVariables(cluster))
+}
+
+func validateRiakConfig(cluster *FrameworkRiakCluster, config string, variables map[string]string) ConfigErrors {
+	rendered, err := renderConfigTemplate("riak.conf", config, sampleRiakConfigTemplateData(cluster, variables))
+	if err != nil {
+		return ConfigErrors{err}
+	}
+	configErrors := ConfigErrors{}
+	for i, line := range strings.Split(rendered, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator < 0 {
+			configErrors = append(configErrors, &ConfigError{Line: i + 1, Message: fmt.Sprintf("Expected a key = value setting: %s", line)})
+			continue
+		}
+		key := strings.TrimSpace(line[:separator])
+		value := line[separator+1:]
+		if comment := strings.Index(value, "#"); comment >= 0 {
+			value = value[:comment]
+		}
+		if !riakConfigKeyPattern.MatchString(key) {
+			configErrors = append(configErrors, &ConfigError{Line: i + 1, Message: fmt.Sprintf("Invalid key: %q", key)})
+		} else if strings.TrimSpace(value) == "" {
+			configErrors = append(configErrors, &ConfigError{Line: i + 1, Message: fmt.Sprintf("Missing value for %s", key)})
+		}
+	}
+	return configErrors
+}
+
+// ValidateAdvancedConfig renders the advanced.config template the way the executor will, and checks the result is
+// a single Erlang list, terminated with a dot, as file:consult/1 reads it
+func ValidateAdvancedConfig(cluster *FrameworkRiakCluster, config string) ConfigErrors {
+	return validateAdvancedConfig(cluster, config, currentConfigVariables(cluster))
+}
+
+func validateAdvancedConfig(cluster *FrameworkRiakCluster, config string, variables map[string]string) ConfigErrors {
+	rendered, err := renderConfigTemplate("advanced.config", config, sampleAdvancedConfigTemplateData(cluster, variables))
+	if err != nil {
+		return ConfigErrors{err}
+	}
+	if err := checkErlangConfig(rendered); err != nil {
+		return ConfigErrors{err}
+	}
+	return ConfigErrors{}
+}
+
+// sampleNamedPorts stands in for the ports a node of the cluster will be given
+func sampleNamedPorts(cluster *FrameworkRiakCluster) map[string]int64 {
+	ports := make(map[string]int64)
+	for i, name := range cluster.AllPortNames() {
+		ports[name] = int64(31000 + i)
+	}
+	return ports
+}
+
+// currentConfigVariables are the variables of the cluster's current revision, which new configs are rendered with
+func currentConfigVariables(cluster *FrameworkRiakCluster) map[string]string {
+	if revision := cluster.CurrentConfigRevision(); revision != nil {
+		return revision.Variables
+	}
+	return nil
+}
+
+// sampleTaskTemplateData stands in for the task a node of the cluster will be launched as
+func sampleTaskTemplateData(cluster *FrameworkRiakCluster, variables map[string]string) common.TaskTemplateData {
+	return common.TaskTemplateData{
+		Cpus:          1,
+		Mem:           4096,
+		Disk:          10000,
+		DataDir:       "/var/lib/mesos/slaves/agent/frameworks/riak/executors/executor/runs/latest/root/riak/data",
+		Hostname:      "localhost",
+		ClusterName:   cluster.Name,
+		FrameworkName: "riak",
+		// Agents differ in their attributes, templates use {{index .Attributes "rack"}} to allow for them being missing
+		Attributes: make(map[string]string),
+		Vars:       copySettings(variables),
+	}
+}
+
+func sampleRiakConfigTemplateData(cluster *FrameworkRiakCluster, variables map[string]string) common.RiakConfigTemplateData {
+	ports := sampleNamedPorts(cluster)
+	return common.RiakConfigTemplateData{
+		TaskTemplateData:       sampleTaskTemplateData(cluster, variables),
+		FullyQualifiedNodeName: fmt.Sprintf("%s-1@localhost.", cluster.Name),
+		HTTPPort:               ports["http"],
+		PBPort:                 ports["pb"],
+		HandoffPort:            ports["handoff"],
+		DisterlPort:            ports["disterl"],
+		Ports:                  ports,
+	}
+}
+
+func sampleAdvancedConfigTemplateData(cluster *FrameworkRiakCluster, variables map[string]string) common.AdvancedConfigTemplateData {
+	return common.AdvancedConfigTemplateData{
+		TaskTemplateData: sampleTaskTemplateData(cluster, variables),
+		CEPMDPort:        31100,
+		Ports:            sampleNamedPorts(cluster),
+	}
+}
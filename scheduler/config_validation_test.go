@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShippedConfigsAreValid(t *testing.T) {
+	assert := assert.New(t)
+	cluster := NewFrameworkRiakCluster("default")
+	for _, name := range []string{"riak.golang.conf", "riak_ee.golang.conf"} {
+		config, err := ioutil.ReadFile("../artifacts/data/" + name)
+		assert.Nil(err)
+		assert.Equal(0, len(ValidateRiakConfig(cluster, string(config))), name)
+	}
+	config, err := ioutil.ReadFile("../artifacts/data/advanced.golang.config")
+	assert.Nil(err)
+	assert.Equal(0, len(ValidateAdvancedConfig(cluster, string(config))))
+}
+
+func TestValidateRiakConfig(t *testing.T) {
+	assert := assert.New(t)
+	cluster := NewFrameworkRiakCluster("default")
+
+	configErrors := ValidateRiakConfig(cluster, "ring_size = 64\nlistener.http.internal = 0.0.0.0:{{end}}\n")
+	assert.Equal(1, len(configErrors))
+	assert.Equal(2, configErrors[0].Line)
+
+	configErrors = ValidateRiakConfig(cluster, "ring_size = 64\n\nsearch.solr.port = {{.Ports.nope}}\n")
+	assert.Equal(1, len(configErrors))
+	assert.Equal(3, configErrors[0].Line)
+	assert.Contains(configErrors[0].Message, "nope")
+
+	// Port names of the cluster can be used
+	assert.Nil(cluster.SetPortNames([]string{"nope"}))
+	assert.Equal(0, len(ValidateRiakConfig(cluster, "search.solr.port = {{.Ports.nope}} # comment\n")))
+
+	configErrors = ValidateRiakConfig(cluster, "## comment\nring_size\nstorage..backend = bitcask\nnodename = # comment\n")
+	assert.Equal(3, len(configErrors))
+	assert.Equal("line 2: Expected a key = value setting: ring_size", configErrors[0].Error())
+	assert.Equal("line 3: Invalid key: \"storage..backend\"", configErrors[1].Error())
+	assert.Equal("line 4: Missing value for nodename", configErrors[2].Error())
+}
+
+func TestValidateAdvancedConfig(t *testing.T) {
+	assert := assert.New(t)
+	cluster := NewFrameworkRiakCluster("default")
+	valid := `%% Comment
+[{riak_kv, [{storage_backend, riak_kv_eleveldb_backend}, {ratio, 0.5e-3}, {port, {{.CEPMDPort}}}]},
+ {lager, [{handlers, [{'lager_file_backend', [{file, "log/" "console.log"}, {level, info}]}]},
+          {offset, -1}, {mask, 16#FF}, {char, $\n}, {bin, <<"abc">>}, {map, #{a => [1 | []]}}]}].
+`
+	assert.Equal(0, len(ValidateAdvancedConfig(cluster, valid)))
+
+	for config, line := range map[string]int{
+		"[{riak_kv, []}]":                        1,
+		"[{riak_kv, []}].\n[].":                  2,
+		"[\n{riak_kv, [{dir, \"data}]}].":        2,
+		"[\n\n{riak_kv, [{storage, Backend}]}].": 3,
+		"{riak_kv, []}.":                         1,
+		"[{riak_kv, []},\n].":                    2,
+		"[{riak_kv, {{.Nope}}}].":                1,
+	} {
+		configErrors := ValidateAdvancedConfig(cluster, config)
+		if assert.Equal(1, len(configErrors), config) {
+			assert.Equal(line, configErrors[0].Line, config)
+		}
+	}
+}
+
+func TestInvalidConfigRejected(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := configTestRouter(sc)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/advancedConfig").HandlerFunc(sc.schedulerHTTPServer.setAdvancedConfig)
+
+	request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config", strings.NewReader("ring_size = 64\nnodename = {{.Nodename}}\n"))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+	assert.Contains(recorder.Body.String(), "line 2: ")
+
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/advancedConfig", strings.NewReader("[{riak_kv, []}"))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+	assert.Equal("line 1: Expected , or ], found end of file\n", recorder.Body.String())
+	assert.Equal(int64(1), cluster.ConfigRevision)
+}
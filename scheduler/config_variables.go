@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Variable names have to be usable as {{.Vars.name}} in the templates
+var configVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ConfigVariables are the variables a cluster's config templates are rendered with, as returned by the API
+type ConfigVariables struct {
+	Revision  int64
+	Variables map[string]string
+}
+
+// SetConfigVariables makes a new revision with the cluster's template variables replaced. The configs of the
+// current revision have to render with the new variables.
+func (frc *FrameworkRiakCluster) SetConfigVariables(variables map[string]string, author string) (*ConfigRevision, ConfigErrors) {
+	configErrors := ConfigErrors{}
+	names := []string{}
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !configVariablePattern.MatchString(name) {
+			configErrors = append(configErrors, &ConfigError{Message: fmt.Sprintf("Invalid variable name: %q", name)})
+		} else if strings.ContainsAny(variables[name], "\r\n") {
+			configErrors = append(configErrors, &ConfigError{Message: fmt.Sprintf("Invalid value for %s: %q", name, variables[name])})
+		}
+	}
+	if len(configErrors) > 0 {
+		return nil, configErrors
+	}
+
+	proposed := frc.CurrentConfigRevision().copyContents()
+	proposed.Variables = copySettings(variables)
+	nodeIDs := []string{""}
+	for nodeID := range proposed.NodeSettings {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	for _, nodeID := range nodeIDs {
+		for _, configError := range validateRiakConfig(frc, proposed.EffectiveRiakConfig(nodeID), proposed.Variables) {
+			if nodeID != "" {
+				configError.Message = fmt.Sprintf("%s (riak.conf of %s)", configError.Message, nodeID)
+			}
+			configErrors = append(configErrors, configError)
+		}
+	}
+	if proposed.AdvancedConfig != "" {
+		configErrors = append(configErrors, validateAdvancedConfig(frc, proposed.AdvancedConfig, proposed.Variables)...)
+	}
+	if len(configErrors) > 0 {
+		return nil, configErrors
+	}
+
+	return frc.revise(func(revision *ConfigRevision) {
+		*revision = *proposed
+	}, author, "Set variables"), nil
+}
@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetConfigVariables(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := configTestRouter(sc)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/variables").HandlerFunc(sc.schedulerHTTPServer.serveConfigVariables)
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/config/variables").HandlerFunc(sc.schedulerHTTPServer.setConfigVariables)
+
+	// Templates can't use a variable before it's set
+	config := "ring_size = {{.Vars.ring_size}}\nleveldb.maximum_memory.percent = {{.Mem}}\nplatform_data_dir = {{.DataDir}}\n"
+	request, _ := http.NewRequest("POST", "/api/v1/clusters/default/config", strings.NewReader(config))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/config/variables", strings.NewReader(`{"ring_size": "128"}`))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Equal(int64(2), cluster.ConfigRevision)
+
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/config", strings.NewReader(config))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Equal(int64(3), cluster.ConfigRevision)
+
+	// Nor can a variable the config uses be removed
+	for body, code := range map[string]int{
+		`{}`:                          400,
+		`{"ring size": "128"}`:        400,
+		`{"ring_size": "1\n2"}`:       400,
+		`{"ring_size": 128}`:          400,
+		`{"ring_size": "128"}`:        200,
+		`{"ring_size": "256", "":""}`: 400,
+	} {
+		request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/config/variables", strings.NewReader(body))
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(code, recorder.Code, body)
+	}
+	assert.Equal(int64(3), cluster.ConfigRevision)
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/config/variables", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	variables := &ConfigVariables{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(variables))
+	assert.Equal(&ConfigVariables{Revision: 3, Variables: map[string]string{"ring_size": "128"}}, variables)
+}
+
+func TestTaskDataForTemplates(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	_, configErrors := cluster.SetConfigVariables(map[string]string{"tier": "gold"}, "")
+	assert.Equal(0, len(configErrors))
+	node := cluster.CreateNode(sc)
+
+	offer := newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))
+	offer.Attributes = []*mesos.Attribute{
+		{Name: proto.String("rack"), Type: mesos.Value_TEXT.Enum(), Text: &mesos.Value_Text{Value: proto.String("r1")}},
+		{Name: proto.String("zones"), Type: mesos.Value_SET.Enum(), Set: &mesos.Value_Set{Item: []string{"a", "b"}}},
+		{Name: proto.String("ids"), Type: mesos.Value_RANGES.Enum(), Ranges: &mesos.Value_Ranges{Range: []*mesos.Value_Range{util.NewValueRange(1, 4)}}},
+		{Name: proto.String("weight"), Type: mesos.Value_SCALAR.Enum(), Scalar: &mesos.Value_Scalar{Value: proto.Float64(2.5)}},
+	}
+	sc.ResourceOffers(driver, []*mesos.Offer{offer})
+	accepted := driver.waitForAccepted(t, 1)
+
+	taskData, err := common.DeserializeTaskData(accepted[0].Tasks[0].GetData())
+	assert.Nil(err)
+	assert.Equal(node.Cpus, taskData.Cpus)
+	assert.Equal(node.Mem, taskData.Mem)
+	assert.Equal(node.Disk, taskData.Disk)
+	assert.Equal(map[string]string{"rack": "r1", "zones": "{a,b}", "ids": "[1-4]", "weight": "2.5"}, taskData.Attributes)
+	assert.Equal(map[string]string{"tier": "gold"}, taskData.Variables)
+}
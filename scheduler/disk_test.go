@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// sourcedTestDisk is an unreserved disk resource from a PATH or MOUNT disk
+func sourcedTestDisk(size float64, sourceType string, root string) *mesos.Resource {
+	resource := util.NewScalarResource("disk", size)
+	common.SetDiskSource(resource, &common.DiskSource{Type: sourceType, Root: root})
+	return resource
+}
+
+func TestDiskSourceSurvivesMarshal(t *testing.T) {
+	assert := assert.New(t)
+	resource := sourcedTestDisk(100000, common.DISK_SOURCE_MOUNT, "/mnt/data1")
+	data, err := proto.Marshal(resource)
+	assert.Nil(err)
+	unmarshalled := &mesos.Resource{}
+	assert.Nil(proto.Unmarshal(data, unmarshalled))
+	assert.Equal(&common.DiskSource{Type: common.DISK_SOURCE_MOUNT, Root: "/mnt/data1"}, common.GetDiskSource(unmarshalled))
+	assert.Nil(common.GetDiskSource(util.NewScalarResource("disk", 100)))
+}
+
+func TestMountDiskRequired(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	cluster.RequireMountDisk = true
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	// Plenty of root disk isn't enough
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 100000))})
+	driver.waitForDeclined(t, 1)
+	assert.Nil(node.SlaveID)
+
+	// The whole mount disk is reserved, and the volume is created on it
+	driver.reset()
+	resources := append(unreservedTestResources(4, 4096, 100000), sourcedTestDisk(50000, common.DISK_SOURCE_MOUNT, "/mnt/data1"))
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", resources)})
+	accepted := driver.waitForAccepted(t, 1)
+	mountDisk := &common.DiskSource{Type: common.DISK_SOURCE_MOUNT, Root: "/mnt/data1"}
+	assert.Equal(mountDisk, node.DiskSource)
+	assert.Equal(float64(50000), node.ReservedDisk)
+	for _, resource := range accepted[0].Operations[0].Reserve.Resources {
+		if resource.GetName() == "disk" {
+			assert.Equal(mountDisk, common.GetDiskSource(resource))
+			assert.Equal(float64(50000), resource.GetScalar().GetValue())
+		}
+	}
+	volume := accepted[0].Operations[1].Create.Volumes[0]
+	assert.Equal(mountDisk, common.GetDiskSource(volume))
+	assert.Equal(node.PersistenceID(), volume.Disk.Persistence.GetId())
+
+	// The node launches on the volume on the mount disk
+	driver.reset()
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-3", "slave-1", reservedTestResources(accepted[0].Operations))})
+	accepted = driver.waitForAccepted(t, 1)
+	assert.Equal([]mesos.Offer_Operation_Type{mesos.Offer_Operation_LAUNCH}, operationTypes(accepted[0].Operations))
+	for _, resource := range accepted[0].Operations[0].Launch.TaskInfos[0].Resources {
+		if resource.GetName() == "disk" {
+			assert.Equal(mountDisk, common.GetDiskSource(resource))
+		}
+	}
+}
+
+func TestPathDiskUsedWhenRootDiskIsFull(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	resources := append(unreservedTestResources(4, 4096, 100),
+		sourcedTestDisk(50000, common.DISK_SOURCE_MOUNT, "/mnt/data1"),
+		sourcedTestDisk(10000, common.DISK_SOURCE_PATH, "/var/data"))
+	offerHelper := common.NewOfferHelper(newTestOffer("offer-1", "slave-1", resources))
+	assert.Equal(float64(100), offerHelper.UnreservedResources.Disk)
+	assert.Equal(2, len(offerHelper.UnreservedResources.Disks))
+
+	// Only as much of the PATH disk as the node needs is reserved
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", resources)})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(&common.DiskSource{Type: common.DISK_SOURCE_PATH, Root: "/var/data"}, node.DiskSource)
+	assert.Equal(node.Disk, node.ReservedDisk)
+}
@@ -0,0 +1,360 @@
+package scheduler
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Token kinds of the Erlang term syntax used by advanced.config
+const (
+	ERLANG_TOKEN_ATOM        = "atom"
+	ERLANG_TOKEN_VARIABLE    = "variable"
+	ERLANG_TOKEN_NUMBER      = "number"
+	ERLANG_TOKEN_STRING      = "string"
+	ERLANG_TOKEN_PUNCTUATION = "punctuation"
+	ERLANG_TOKEN_DOT         = "dot" // Terminates a term
+	ERLANG_TOKEN_EOF         = "end of file"
+)
+
+type erlangToken struct {
+	kind  string
+	value string
+	line  int
+}
+
+func (token *erlangToken) String() string {
+	if token.kind == ERLANG_TOKEN_EOF {
+		return token.kind
+	}
+	return fmt.Sprintf("%q", token.value)
+}
+
+// erlangTermParser checks the syntax of Erlang terms, without building them
+type erlangTermParser struct {
+	input  []rune
+	pos    int
+	line   int
+	peeked *erlangToken
+}
+
+// checkErlangConfig checks that config is a single list term, followed by a dot
+func checkErlangConfig(config string) *ConfigError {
+	parser := &erlangTermParser{input: []rune(config), line: 1}
+	token, err := parser.peek()
+	if err != nil {
+		return err
+	}
+	if token.value != "[" {
+		return &ConfigError{Line: token.line, Message: fmt.Sprintf("advanced.config must be a list, found %s", token)}
+	}
+	if err := parser.term(); err != nil {
+		return err
+	}
+	if err := parser.expect(ERLANG_TOKEN_DOT, "."); err != nil {
+		return err
+	}
+	if token, err := parser.next(); err != nil {
+		return err
+	} else if token.kind != ERLANG_TOKEN_EOF {
+		return &ConfigError{Line: token.line, Message: fmt.Sprintf("Unexpected %s after the end of the config", token)}
+	}
+	return nil
+}
+
+func (parser *erlangTermParser) term() *ConfigError {
+	token, err := parser.next()
+	if err != nil {
+		return err
+	}
+	switch token.kind {
+	case ERLANG_TOKEN_ATOM, ERLANG_TOKEN_NUMBER:
+		return nil
+	case ERLANG_TOKEN_STRING:
+		// Adjacent strings are concatenated
+		for {
+			next, err := parser.peek()
+			if err != nil || next.kind != ERLANG_TOKEN_STRING {
+				return err
+			}
+			parser.next()
+		}
+	case ERLANG_TOKEN_VARIABLE:
+		return &ConfigError{Line: token.line, Message: fmt.Sprintf("Variables aren't allowed in terms: %s", token.value)}
+	}
+	switch token.value {
+	case "{":
+		return parser.elements("}")
+	case "[":
+		return parser.list()
+	case "<<":
+		return parser.elements(">>")
+	case "#{":
+		return parser.mapPairs()
+	case "-", "+":
+		return parser.expect(ERLANG_TOKEN_NUMBER, "")
+	}
+	return &ConfigError{Line: token.line, Message: fmt.Sprintf("Expected a term, found %s", token)}
+}
+
+// elements parses comma separated terms up to the closing token
+func (parser *erlangTermParser) elements(closing string) *ConfigError {
+	if token, err := parser.peek(); err != nil {
+		return err
+	} else if token.value == closing {
+		parser.next()
+		return nil
+	}
+	for {
+		if err := parser.term(); err != nil {
+			return err
+		}
+		token, err := parser.next()
+		if err != nil {
+			return err
+		}
+		if token.value == closing {
+			return nil
+		}
+		if token.value != "," {
+			return &ConfigError{Line: token.line, Message: fmt.Sprintf("Expected , or %s, found %s", closing, token)}
+		}
+	}
+}
+
+func (parser *erlangTermParser) list() *ConfigError {
+	if token, err := parser.peek(); err != nil {
+		return err
+	} else if token.value == "]" {
+		parser.next()
+		return nil
+	}
+	for {
+		if err := parser.term(); err != nil {
+			return err
+		}
+		token, err := parser.next()
+		if err != nil {
+			return err
+		}
+		switch token.value {
+		case "]":
+			return nil
+		case ",":
+			continue
+		case "|":
+			if err := parser.term(); err != nil {
+				return err
+			}
+			return parser.expect(ERLANG_TOKEN_PUNCTUATION, "]")
+		}
+		return &ConfigError{Line: token.line, Message: fmt.Sprintf("Expected , or ], found %s", token)}
+	}
+}
+
+func (parser *erlangTermParser) mapPairs() *ConfigError {
+	if token, err := parser.peek(); err != nil {
+		return err
+	} else if token.value == "}" {
+		parser.next()
+		return nil
+	}
+	for {
+		if err := parser.term(); err != nil {
+			return err
+		}
+		if err := parser.expect(ERLANG_TOKEN_PUNCTUATION, "=>"); err != nil {
+			return err
+		}
+		if err := parser.term(); err != nil {
+			return err
+		}
+		token, err := parser.next()
+		if err != nil {
+			return err
+		}
+		if token.value == "}" {
+			return nil
+		}
+		if token.value != "," {
+			return &ConfigError{Line: token.line, Message: fmt.Sprintf("Expected , or }, found %s", token)}
+		}
+	}
+}
+
+// expect takes the next token, which must be of the kind, and have the value if one is given
+func (parser *erlangTermParser) expect(kind string, value string) *ConfigError {
+	token, err := parser.next()
+	if err != nil {
+		return err
+	}
+	if token.kind != kind || (value != "" && token.value != value) {
+		expected := kind
+		if value != "" {
+			expected = value
+		}
+		return &ConfigError{Line: token.line, Message: fmt.Sprintf("Expected %s, found %s", expected, token)}
+	}
+	return nil
+}
+
+func (parser *erlangTermParser) peek() (*erlangToken, *ConfigError) {
+	if parser.peeked == nil {
+		token, err := parser.scan()
+		if err != nil {
+			return nil, err
+		}
+		parser.peeked = token
+	}
+	return parser.peeked, nil
+}
+
+func (parser *erlangTermParser) next() (*erlangToken, *ConfigError) {
+	token, err := parser.peek()
+	parser.peeked = nil
+	return token, err
+}
+
+func (parser *erlangTermParser) at(offset int) rune {
+	if parser.pos+offset >= len(parser.input) {
+		return 0
+	}
+	return parser.input[parser.pos+offset]
+}
+
+func (parser *erlangTermParser) advance() rune {
+	char := parser.input[parser.pos]
+	parser.pos = parser.pos + 1
+	if char == '\n' {
+		parser.line = parser.line + 1
+	}
+	return char
+}
+
+func (parser *erlangTermParser) scan() (*erlangToken, *ConfigError) {
+	// Skip whitespace and comments
+	for parser.pos < len(parser.input) {
+		char := parser.at(0)
+		if char == '%' {
+			for parser.pos < len(parser.input) && parser.at(0) != '\n' {
+				parser.advance()
+			}
+		} else if unicode.IsSpace(char) {
+			parser.advance()
+		} else {
+			break
+		}
+	}
+	line := parser.line
+	if parser.pos >= len(parser.input) {
+		return &erlangToken{kind: ERLANG_TOKEN_EOF, line: line}, nil
+	}
+
+	start := parser.pos
+	char := parser.at(0)
+	switch {
+	case unicode.IsLower(char):
+		for isErlangNameChar(parser.at(0)) {
+			parser.advance()
+		}
+		return &erlangToken{kind: ERLANG_TOKEN_ATOM, value: string(parser.input[start:parser.pos]), line: line}, nil
+	case unicode.IsUpper(char) || char == '_':
+		for isErlangNameChar(parser.at(0)) {
+			parser.advance()
+		}
+		return &erlangToken{kind: ERLANG_TOKEN_VARIABLE, value: string(parser.input[start:parser.pos]), line: line}, nil
+	case unicode.IsDigit(char):
+		return parser.scanNumber(line)
+	case char == '\'' || char == '"':
+		if err := parser.scanQuoted(char, line); err != nil {
+			return nil, err
+		}
+		kind := ERLANG_TOKEN_STRING
+		if char == '\'' {
+			kind = ERLANG_TOKEN_ATOM
+		}
+		return &erlangToken{kind: kind, value: string(parser.input[start:parser.pos]), line: line}, nil
+	case char == '$':
+		parser.advance()
+		if parser.pos >= len(parser.input) {
+			return nil, &ConfigError{Line: line, Message: "Missing character after $"}
+		}
+		if parser.advance() == '\\' && parser.pos < len(parser.input) {
+			parser.advance()
+		}
+		return &erlangToken{kind: ERLANG_TOKEN_NUMBER, value: string(parser.input[start:parser.pos]), line: line}, nil
+	case char == '.':
+		parser.advance()
+		return &erlangToken{kind: ERLANG_TOKEN_DOT, value: ".", line: line}, nil
+	}
+
+	for _, punctuation := range []string{"<<", ">>", "#{", "=>"} {
+		if parser.at(0) == rune(punctuation[0]) && parser.at(1) == rune(punctuation[1]) {
+			parser.advance()
+			parser.advance()
+			return &erlangToken{kind: ERLANG_TOKEN_PUNCTUATION, value: punctuation, line: line}, nil
+		}
+	}
+	switch char {
+	case '{', '}', '[', ']', ',', '|', '-', '+':
+		parser.advance()
+		return &erlangToken{kind: ERLANG_TOKEN_PUNCTUATION, value: string(char), line: line}, nil
+	}
+	return nil, &ConfigError{Line: line, Message: fmt.Sprintf("Unexpected character %q", char)}
+}
+
+// scanNumber scans integers, base#digits integers and floats
+func (parser *erlangTermParser) scanNumber(line int) (*erlangToken, *ConfigError) {
+	start := parser.pos
+	for unicode.IsDigit(parser.at(0)) || parser.at(0) == '_' {
+		parser.advance()
+	}
+	if parser.at(0) == '#' {
+		parser.advance()
+		digits := parser.pos
+		for unicode.IsDigit(parser.at(0)) || unicode.IsLetter(parser.at(0)) {
+			parser.advance()
+		}
+		if parser.pos == digits {
+			return nil, &ConfigError{Line: line, Message: fmt.Sprintf("Missing digits in %s", string(parser.input[start:parser.pos]))}
+		}
+	} else if parser.at(0) == '.' && unicode.IsDigit(parser.at(1)) {
+		// Otherwise the dot ends the term
+		parser.advance()
+		for unicode.IsDigit(parser.at(0)) {
+			parser.advance()
+		}
+		if parser.at(0) == 'e' || parser.at(0) == 'E' {
+			parser.advance()
+			if parser.at(0) == '-' || parser.at(0) == '+' {
+				parser.advance()
+			}
+			if !unicode.IsDigit(parser.at(0)) {
+				return nil, &ConfigError{Line: line, Message: fmt.Sprintf("Missing exponent in %s", string(parser.input[start:parser.pos]))}
+			}
+			for unicode.IsDigit(parser.at(0)) {
+				parser.advance()
+			}
+		}
+	}
+	return &erlangToken{kind: ERLANG_TOKEN_NUMBER, value: string(parser.input[start:parser.pos]), line: line}, nil
+}
+
+func (parser *erlangTermParser) scanQuoted(quote rune, line int) *ConfigError {
+	parser.advance()
+	for parser.pos < len(parser.input) {
+		char := parser.advance()
+		if char == '\\' && parser.pos < len(parser.input) {
+			parser.advance()
+		} else if char == quote {
+			return nil
+		}
+	}
+	if quote == '\'' {
+		return &ConfigError{Line: line, Message: "Unterminated quoted atom"}
+	}
+	return &ConfigError{Line: line, Message: "Unterminated string"}
+}
+
+func isErlangNameChar(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_' || char == '@'
+}
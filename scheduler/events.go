@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event types, see Event.Data for what each one carries
+const (
+	EVENT_NODE_STATE       string = "node_state"       // A status update was applied to a node: State, CurrentState, Message
+	EVENT_OFFER_APPLIED    string = "offer_applied"    // A node was launched on an offer: OfferID, Hostname, Generation
+	EVENT_RESERVATION_MADE string = "reservation_made" // Resources and a volume were reserved for a node: OfferID, Hostname, PersistenceID
+	EVENT_NODE_JOINED      string = "node_joined"      // A node joined its Riak cluster
+	EVENT_NODE_LEFT        string = "node_left"        // A node was removed from its Riak cluster
+	EVENT_RESTART_PROGRESS string = "restart_progress" // A rolling restart moved on: Generation, Restarting or Finished
+	EVENT_CONFIG_CHANGED   string = "config_changed"   // A cluster setting was changed through the API: Setting
+	EVENT_NODE_HEALTH      string = "node_health"      // A running node passed or failed its executor's health check: Healthy, Message
+)
+
+const (
+	// Events kept for subscribers reconnecting with a Last-Event-ID
+	EVENT_HISTORY_SIZE = 256
+	// Events a subscriber can fall behind by before it's disconnected
+	EVENT_SUBSCRIBER_BUFFER = 64
+	// Comments are sent this often on idle streams, so proxies don't time them out
+	EVENT_KEEPALIVE_INTERVAL = 15 * time.Second
+)
+
+type Event struct {
+	ID          int64
+	Type        string
+	Time        time.Time
+	ClusterName string                 `json:",omitempty"`
+	NodeID      string                 `json:",omitempty"`
+	Data        map[string]interface{} `json:",omitempty"`
+}
+
+// writeServerSentEvent writes the event in the Server-Sent Events format
+func (event *Event) writeServerSentEvent(w io.Writer) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// EventBroker fans events out to every subscriber. Publishing never blocks, so it's safe to do while holding the
+// SchedulerCore's lock: a subscriber which falls too far behind is dropped, and can catch up from the history
+// when it reconnects.
+type EventBroker struct {
+	lock        *sync.Mutex
+	nextID      int64
+	history     []*Event
+	subscribers map[chan *Event]bool
+	// Listeners are called synchronously by Publish, so they see events in order
+	listeners []func(*Event)
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		lock:        &sync.Mutex{},
+		nextID:      1,
+		history:     []*Event{},
+		subscribers: make(map[chan *Event]bool),
+	}
+}
+
+func (broker *EventBroker) Publish(eventType string, clusterName string, nodeID string, data map[string]interface{}) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	event := &Event{
+		ID:          broker.nextID,
+		Type:        eventType,
+		Time:        time.Now(),
+		ClusterName: clusterName,
+		NodeID:      nodeID,
+		Data:        data,
+	}
+	broker.nextID = broker.nextID + 1
+
+	broker.history = append(broker.history, event)
+	if len(broker.history) > EVENT_HISTORY_SIZE {
+		broker.history = broker.history[len(broker.history)-EVENT_HISTORY_SIZE:]
+	}
+
+	for _, listener := range broker.listeners {
+		listener(event)
+	}
+
+	for subscriber := range broker.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Warn("Event subscriber fell behind, disconnecting it")
+			delete(broker.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// AddListener registers a function to be called with every event. It mustn't block or publish events itself.
+func (broker *EventBroker) AddListener(listener func(*Event)) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.listeners = append(broker.listeners, listener)
+}
+
+// Subscribe returns a channel of new events, along with the events after lastEventID which are still in the
+// history. The channel is closed if the subscriber falls behind.
+func (broker *EventBroker) Subscribe(lastEventID int64) (chan *Event, []*Event) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	missed := []*Event{}
+	if lastEventID > 0 {
+		for _, event := range broker.history {
+			if event.ID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+	}
+	subscriber := make(chan *Event, EVENT_SUBSCRIBER_BUFFER)
+	broker.subscribers[subscriber] = true
+	return subscriber, missed
+}
+
+func (broker *EventBroker) Unsubscribe(subscriber chan *Event) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	if broker.subscribers[subscriber] {
+		delete(broker.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// publishNodeEvent is a shorthand for events about a single node
+func (broker *EventBroker) publishNodeEvent(eventType string, riakNode *FrameworkRiakNode, data map[string]interface{}) {
+	broker.Publish(eventType, riakNode.ClusterName, riakNode.CurrentID(), data)
+}
@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"bufio"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventBrokerReplaysMissedEvents(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewEventBroker()
+	for i := 0; i < 3; i++ {
+		broker.Publish(EVENT_CONFIG_CHANGED, "default", "", nil)
+	}
+
+	events, missed := broker.Subscribe(1)
+	assert.Equal(2, len(missed))
+	assert.Equal(int64(2), missed[0].ID)
+
+	broker.Publish(EVENT_NODE_JOINED, "default", "node-1", nil)
+	event := <-events
+	assert.Equal(int64(4), event.ID)
+	assert.Equal(EVENT_NODE_JOINED, event.Type)
+
+	// A subscriber which falls behind is disconnected rather than blocking the scheduler
+	for i := 0; i <= EVENT_SUBSCRIBER_BUFFER; i++ {
+		broker.Publish(EVENT_CONFIG_CHANGED, "default", "", nil)
+	}
+	count := 0
+	for range events {
+		count = count + 1
+	}
+	assert.Equal(EVENT_SUBSCRIBER_BUFFER, count)
+	broker.Unsubscribe(events)
+}
+
+func TestNodeEventsPublished(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	events, _ := sc.events.Subscribe(0)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", reservedTestResources(accepted[0].Operations))})
+	driver.waitForAccepted(t, 2)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+
+	types := []string{}
+	for len(events) > 0 {
+		event := <-events
+		assert.Equal("default", event.ClusterName)
+		types = append(types, event.Type)
+	}
+	assert.Equal([]string{EVENT_RESERVATION_MADE, EVENT_OFFER_APPLIED, EVENT_NODE_STATE}, types)
+}
+
+func TestServeEvents(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	server := httptest.NewServer(http.HandlerFunc(sc.schedulerHTTPServer.serveEvents))
+	defer server.Close()
+	sc.events.Publish(EVENT_CONFIG_CHANGED, "default", "", map[string]interface{}{"Setting": "config"})
+	sc.events.Publish(EVENT_CONFIG_CHANGED, "default", "", map[string]interface{}{"Setting": "portRange"})
+
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(err)
+	defer response.Body.Close()
+	assert.Equal("text/event-stream", response.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(response.Body)
+	lines := []string{}
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		assert.Nil(err)
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	assert.Equal("id: 2", lines[0])
+	assert.Equal("event: "+EVENT_CONFIG_CHANGED, lines[1])
+	assert.Contains(lines[2], `"Setting":"portRange"`)
+}
@@ -2,6 +2,14 @@
 
 package scheduler
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 //ExecutorArgs specifies empty arg list for erlang executor
 func ExecutorArgs(_currentID string) []string {
 	return []string{}
@@ -16,3 +24,48 @@ func ExecutorShell() bool {
 func ExecutorValue() string {
 	return "./riak_mesos_executor/bin/ermf-executor"
 }
+
+var mustacheTagPattern = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+//renderConfigTemplate fills in {{name}} tags with lowercased field names, like the erlang executor does
+func renderConfigTemplate(name string, config string, data interface{}) (string, *ConfigError) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", &ConfigError{Message: err.Error()}
+	}
+	fields := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return "", &ConfigError{Message: err.Error()}
+	}
+	values := make(map[string]interface{})
+	for field, value := range fields {
+		values[strings.ToLower(field)] = value
+	}
+
+	var renderErr *ConfigError
+	rendered := mustacheTagPattern.ReplaceAllStringFunc(config, func(tag string) string {
+		key := mustacheTagPattern.FindStringSubmatch(tag)[1]
+		var value interface{} = values
+		for _, part := range strings.Split(key, ".") {
+			if nested, ok := value.(map[string]interface{}); ok {
+				value, ok = nested[part]
+				if ok {
+					continue
+				}
+			}
+			value = nil
+			break
+		}
+		if value == nil && renderErr == nil {
+			line := strings.Count(config[:strings.Index(config, tag)], "\n") + 1
+			renderErr = &ConfigError{Line: line, Message: fmt.Sprintf("Unknown tag in %s: %s", name, tag)}
+		}
+		return fmt.Sprint(value)
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+	return rendered, nil
+}
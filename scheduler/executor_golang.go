@@ -2,6 +2,13 @@
 
 package scheduler
 
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
 //ExecutorArgs specifies the arg list for golang executor
 func ExecutorArgs(currentID string) []string {
 	return []string{ExecutorValue(), "-logtostderr=true", "-taskinfo", currentID}
@@ -16,3 +23,28 @@ func ExecutorShell() bool {
 func ExecutorValue() string {
 	return "./executor_linux_amd64"
 }
+
+// Template errors start with "template: <name>:<line>:" or "template: <name>:<line>:<column>:"
+var templateErrorPattern = regexp.MustCompile(`^template: [^:]*:(\d+):(?:\d+:)?\s*(.*)$`)
+
+//renderConfigTemplate renders a config as a Go template, like the golang executor does
+func renderConfigTemplate(name string, config string, data interface{}) (string, *ConfigError) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(config)
+	if err != nil {
+		return "", templateError(err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", templateError(err)
+	}
+	return rendered.String(), nil
+}
+
+func templateError(err error) *ConfigError {
+	match := templateErrorPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return &ConfigError{Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(match[1])
+	return &ConfigError{Line: line, Message: match[2]}
+}
@@ -0,0 +1,258 @@
+package scheduler
+
+import (
+	metamgr "github.com/basho-labs/riak-mesos/metadata_manager"
+	"github.com/golang/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSchedulerDriver implements sched.SchedulerDriver, and records the calls the scheduler makes
+type fakeSchedulerDriver struct {
+	lock       *sync.Mutex
+	accepted   []*fakeAccept
+	declined   []*fakeAccept
+	killed     []*mesos.TaskID
+	messages   []*fakeFrameworkMessage
+	reconciled [][]*mesos.TaskStatus
+	revived    int
+}
+
+// fakeAccept is a single AcceptOffers, LaunchTasks or DeclineOffer call
+type fakeAccept struct {
+	OfferIDs   []*mesos.OfferID
+	Operations []*mesos.Offer_Operation
+	Tasks      []*mesos.TaskInfo
+	Filters    *mesos.Filters
+}
+
+type fakeFrameworkMessage struct {
+	ExecutorID *mesos.ExecutorID
+	SlaveID    *mesos.SlaveID
+	Data       string
+}
+
+func newFakeSchedulerDriver() *fakeSchedulerDriver {
+	return &fakeSchedulerDriver{
+		lock: &sync.Mutex{},
+	}
+}
+
+func (driver *fakeSchedulerDriver) Start() (mesos.Status, error) {
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) Stop(failover bool) (mesos.Status, error) {
+	return mesos.Status_DRIVER_STOPPED, nil
+}
+func (driver *fakeSchedulerDriver) Abort() (mesos.Status, error) {
+	return mesos.Status_DRIVER_ABORTED, nil
+}
+func (driver *fakeSchedulerDriver) Join() (mesos.Status, error) {
+	return mesos.Status_DRIVER_STOPPED, nil
+}
+func (driver *fakeSchedulerDriver) Run() (mesos.Status, error) {
+	return mesos.Status_DRIVER_STOPPED, nil
+}
+func (driver *fakeSchedulerDriver) RequestResources(requests []*mesos.Request) (mesos.Status, error) {
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) AcceptOffers(offerIDs []*mesos.OfferID, operations []*mesos.Offer_Operation, filters *mesos.Filters) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.accepted = append(driver.accepted, &fakeAccept{OfferIDs: offerIDs, Operations: operations, Filters: filters})
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) LaunchTasks(offerIDs []*mesos.OfferID, tasks []*mesos.TaskInfo, filters *mesos.Filters) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.accepted = append(driver.accepted, &fakeAccept{OfferIDs: offerIDs, Tasks: tasks, Filters: filters})
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) KillTask(taskID *mesos.TaskID) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.killed = append(driver.killed, taskID)
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) DeclineOffer(offerID *mesos.OfferID, filters *mesos.Filters) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.declined = append(driver.declined, &fakeAccept{OfferIDs: []*mesos.OfferID{offerID}, Filters: filters})
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) ReviveOffers() (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.revived = driver.revived + 1
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) SendFrameworkMessage(executorID *mesos.ExecutorID, slaveID *mesos.SlaveID, data string) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.messages = append(driver.messages, &fakeFrameworkMessage{ExecutorID: executorID, SlaveID: slaveID, Data: data})
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+func (driver *fakeSchedulerDriver) ReconcileTasks(statuses []*mesos.TaskStatus) (mesos.Status, error) {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.reconciled = append(driver.reconciled, statuses)
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+
+// Offers are accepted and declined asynchronously by SchedulerCore.ResourceOffers, so wait for them to show up
+func (driver *fakeSchedulerDriver) waitFor(t *testing.T, what string, done func() bool) {
+	for i := 0; i < 500; i++ {
+		driver.lock.Lock()
+		isDone := done()
+		driver.lock.Unlock()
+		if isDone {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+// waitForScheduler waits for work which is done in the background with the scheduler's lock held
+func waitForScheduler(t *testing.T, sc *SchedulerCore, what string, done func() bool) {
+	for i := 0; i < 500; i++ {
+		sc.lock.Lock()
+		isDone := done()
+		sc.lock.Unlock()
+		if isDone {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func (driver *fakeSchedulerDriver) waitForAccepted(t *testing.T, count int) []*fakeAccept {
+	driver.waitFor(t, "accepted offers", func() bool { return len(driver.accepted) >= count })
+	return driver.accepted
+}
+
+func (driver *fakeSchedulerDriver) waitForDeclined(t *testing.T, count int) []*fakeAccept {
+	driver.waitFor(t, "declined offers", func() bool { return len(driver.declined) >= count })
+	return driver.declined
+}
+
+func (driver *fakeSchedulerDriver) waitForRevived(t *testing.T, count int) {
+	driver.waitFor(t, "revived offers", func() bool { return driver.revived >= count })
+}
+
+func (driver *fakeSchedulerDriver) reset() {
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.accepted = nil
+	driver.declined = nil
+	driver.killed = nil
+	driver.messages = nil
+	driver.reconciled = nil
+	driver.revived = 0
+}
+
+// newTestSchedulerCore builds a SchedulerCore backed by a FakeZkConnection and a fakeSchedulerDriver,
+// without binding any ports
+func newTestSchedulerCore(compatibilityMode bool) (*SchedulerCore, *fakeSchedulerDriver, *metamgr.FakeZkConnection) {
+	zkConn := metamgr.NewFakeZkConnection()
+	mgr := metamgr.NewMetadataManagerWithConnector("riak", []string{"zk:2181"}, zkConn.Connector())
+	driver := newFakeSchedulerDriver()
+
+	sc := &SchedulerCore{
+		lock:               &sync.Mutex{},
+		mgr:                mgr,
+		zookeepers:         []string{"zk:2181"},
+		frameworkName:      "riak",
+		frameworkRole:      "riak",
+		nodeCpus:           "1.0",
+		nodeMem:            "1024",
+		nodeDisk:           "2048",
+		schedulerState:     GetSchedulerState(mgr),
+		mesosAuthPrincipal: "riak",
+		compatibilityMode:  compatibilityMode,
+
+		refuseSeconds:     DEFAULT_REFUSE_SECONDS,
+		idleRefuseSeconds: DEFAULT_IDLE_REFUSE_SECONDS,
+		driver:            driver,
+		placementStrategy: &spreadStrategy{},
+		reservationGC:     NewReservationGC(DEFAULT_VOLUME_GRACE_PERIOD, false),
+		events:            NewEventBroker(),
+		nodeRequests:      NewNodeRequests(),
+	}
+	sc.schedulerHTTPServer = &SchedulerHTTPServer{
+		sc:       sc,
+		hostURI:  "http://scheduler:8080/static/riak_mesos_executor.tar.gz",
+		riakURI:  "http://scheduler:8080/static/riak-bin.tar.gz",
+		cepmdURI: "http://scheduler:8080/static/cepmd_linux_amd64",
+		URI:      "http://scheduler:8080",
+	}
+	sc.rServer = newReconciliationServer(driver, sc)
+	sc.webhooks = newWebhookDispatcher(sc)
+	sc.events.AddListener(sc.webhooks.enqueue)
+	sc.auditLog = NewAuditLog(mgr, sc, nil)
+
+	return sc, driver, zkConn
+}
+
+func newTestOffer(id string, slaveID string, resources []*mesos.Resource) *mesos.Offer {
+	return &mesos.Offer{
+		Id:          util.NewOfferID(id),
+		FrameworkId: util.NewFrameworkID("riak-framework"),
+		SlaveId:     util.NewSlaveID(slaveID),
+		Hostname:    proto.String(slaveID + ".example.com"),
+		Resources:   resources,
+	}
+}
+
+func unreservedTestResources(cpus float64, mem float64, disk float64) []*mesos.Resource {
+	return []*mesos.Resource{
+		util.NewScalarResource("cpus", cpus),
+		util.NewScalarResource("mem", mem),
+		util.NewScalarResource("disk", disk),
+		util.NewRangesResource("ports", []*mesos.Value_Range{util.NewValueRange(31000, 31099)}),
+	}
+}
+
+// reservedTestResources is what Mesos offers back after the given RESERVE and CREATE operations have been
+// applied: the reserved cpus, mem and ports, the persistent volume in place of the reserved disk, plus some
+// unreserved resources for the executor
+func reservedTestResources(operations []*mesos.Offer_Operation) []*mesos.Resource {
+	resources := []*mesos.Resource{
+		util.NewScalarResource("cpus", 1),
+		util.NewScalarResource("mem", 512),
+		util.NewRangesResource("ports", []*mesos.Value_Range{util.NewValueRange(32000, 32099)}),
+	}
+	for _, operation := range operations {
+		switch operation.GetType() {
+		case mesos.Offer_Operation_RESERVE:
+			for _, resource := range operation.Reserve.Resources {
+				if resource.GetName() != "disk" {
+					resources = append(resources, resource)
+				}
+			}
+		case mesos.Offer_Operation_CREATE:
+			resources = append(resources, operation.Create.Volumes...)
+		}
+	}
+	return resources
+}
+
+func operationTypes(operations []*mesos.Offer_Operation) []mesos.Offer_Operation_Type {
+	types := []mesos.Offer_Operation_Type{}
+	for _, operation := range operations {
+		types = append(types, operation.GetType())
+	}
+	return types
+}
+
+func newTestStatus(node *FrameworkRiakNode, state mesos.TaskState) *mesos.TaskStatus {
+	return &mesos.TaskStatus{
+		TaskId:  node.CreateTaskID(),
+		State:   state.Enum(),
+		SlaveId: node.SlaveID,
+	}
+}
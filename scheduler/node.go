@@ -9,7 +9,6 @@ import (
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	util "github.com/mesos/mesos-go/mesosutil"
 	"github.com/satori/go.uuid"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -25,10 +24,6 @@ const (
 )
 
 type FrameworkRiakNode struct {
-	// This is super hacky, we're relying on the following to be NOT serialized, and defaults. FIX THIS. Somehow..
-	reconciled           bool      `json:"-"`
-	lastAskedToReconcile time.Time `json:"-"`
-
 	SimpleId          int
 	DestinationState  process_state.ProcessState
 	CurrentState      process_state.ProcessState
@@ -36,6 +31,7 @@ type FrameworkRiakNode struct {
 	Generation        int
 	SlaveID           *mesos.SlaveID
 	Hostname          string
+	AgentURL          string `json:",omitempty"`
 	TaskData          common.TaskData
 	FrameworkName     string
 	ClusterName       string
@@ -44,10 +40,31 @@ type FrameworkRiakNode struct {
 	Cpus              float64
 	Mem               float64
 	Disk              float64
+	DiskSource        *common.DiskSource
+	ReservedDisk      float64
 	Ports             int
+	AssignedPorts     []int64
 	UUID              string
 	ContainerPath     string
 	RestartGeneration int64
+	RemovedAt         time.Time
+	VolumePurged      bool
+	Reconciliation    NodeReconciliation
+	// The cluster's config revision when the node was last launched
+	ConfigRevision int64
+	// Nil until the node's executor reports on its health
+	Health *NodeHealth `json:",omitempty"`
+	// How many times the node's executor was asked to restart Riak in its current task
+	InPlaceRestarts int64 `json:",omitempty"`
+	// While waiting for the executor to accept an in-place restart
+	restartRequested bool
+}
+
+// NodeHealth is what the node's executor last said about the health of its Riak node
+type NodeHealth struct {
+	Healthy bool
+	Message string `json:",omitempty"`
+	Since   time.Time
 }
 
 func NewFrameworkRiakNode(sc *SchedulerCore, clusterName string, restartGeneration int64, simpleId int) *FrameworkRiakNode {
@@ -68,7 +85,7 @@ func NewFrameworkRiakNode(sc *SchedulerCore, clusterName string, restartGenerati
 		DestinationState:  process_state.Started,
 		CurrentState:      process_state.Unknown,
 		Generation:        0,
-		reconciled:        false,
+		Reconciliation:    NodeReconciliation{Status: RECONCILIATION_RECONCILED},
 		FrameworkName:     sc.frameworkName,
 		Role:              &sc.frameworkRole,
 		Principal:         &sc.mesosAuthPrincipal,
@@ -112,52 +129,140 @@ func (frn *FrameworkRiakNode) CreateExecutorID() *mesos.ExecutorID {
 
 // --- Resources ---
 
-func (frn *FrameworkRiakNode) ApplyUnreservedOffer(offerHelper *common.OfferHelper) bool {
-	if !offerHelper.CanFitUnreserved(frn.Cpus+CPUS_PER_EXECUTOR, frn.Mem+MEM_PER_EXECUTOR, frn.Disk, frn.Ports) {
+// CanFitUnreserved is true if the offer has enough unreserved resources to run (or reserve for) this node and its executor
+func (frn *FrameworkRiakNode) CanFitUnreserved(offerHelper *common.OfferHelper, sc *SchedulerCore) bool {
+	if !offerHelper.CanFitUnreserved(frn.Cpus+CPUS_PER_EXECUTOR, frn.Mem+MEM_PER_EXECUTOR, 0, frn.Ports) {
+		return false
+	}
+	_, _, fits := frn.selectDisk(offerHelper, sc)
+	return fits
+}
+
+// selectDisk picks the disk the node's volume goes on. Without reservations, only the root disk can be used.
+func (frn *FrameworkRiakNode) selectDisk(offerHelper *common.OfferHelper, sc *SchedulerCore) (*common.DiskSource, float64, bool) {
+	if sc.compatibilityMode {
+		return nil, frn.Disk, offerHelper.CanFitUnreserved(0, 0, frn.Disk, 0)
+	}
+	requireMount := false
+	if cluster, assigned := sc.schedulerState.Clusters[frn.ClusterName]; assigned {
+		requireMount = cluster.RequireMountDisk
+	}
+	return offerHelper.SelectUnreservedDisk(frn.Disk, requireMount)
+}
+
+// volumeSize is how much disk was reserved for the node, which is the whole disk when it's on a MOUNT disk
+func (frn *FrameworkRiakNode) volumeSize() float64 {
+	if frn.ReservedDisk > 0 {
+		return frn.ReservedDisk
+	}
+	return frn.Disk
+}
+
+// ApplyNewOffer gives the node resources on an agent it hasn't reserved anything on
+func (frn *FrameworkRiakNode) ApplyNewOffer(offerHelper *common.OfferHelper, sc *SchedulerCore) bool {
+	if sc.compatibilityMode {
+		return frn.ApplyReservedOffer(offerHelper, sc)
+	}
+	return frn.ApplyUnreservedOffer(offerHelper, sc)
+}
+
+// selectPorts prefers the ports the node had last time, so that they don't change when it relaunches
+func (frn *FrameworkRiakNode) selectPorts(offerHelper *common.OfferHelper, sc *SchedulerCore) []int64 {
+	var portRange *common.PortRange
+	if cluster, assigned := sc.schedulerState.Clusters[frn.ClusterName]; assigned {
+		portRange = cluster.PortRange
+	}
+	return offerHelper.SelectUnreservedPorts(frn.Ports, frn.AssignedPorts, portRange)
+}
+
+func (frn *FrameworkRiakNode) ApplyUnreservedOffer(offerHelper *common.OfferHelper, sc *SchedulerCore) bool {
+	if !offerHelper.CanFitUnreserved(frn.Cpus+CPUS_PER_EXECUTOR, frn.Mem+MEM_PER_EXECUTOR, 0, frn.Ports) {
+		return false
+	}
+	diskSource, disk, fits := frn.selectDisk(offerHelper, sc)
+	if !fits {
+		return false
+	}
+	ports := frn.selectPorts(offerHelper, sc)
+	if ports == nil {
 		return false
 	}
 
 	log.Infof("Found a new offer for a node. OfferID: %+v, NodeID: %+v", offerHelper.OfferIDStr, frn.CurrentID())
 
-	// Remove the ports and executor requirements from offerHelper, but don't reserve
-	_ = offerHelper.ApplyUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, frn.Ports)
+	// Remove the executor requirements from offerHelper, but don't reserve
+	_, _ = offerHelper.ApplyUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, nil)
 
-	// Create reservation + volumes, add to offerHelper
-	offerHelper.MakeReservation(frn.Cpus, frn.Mem, frn.Disk, 0, *frn.Principal, *frn.Role)
-	offerHelper.MakeVolume(frn.Disk, *frn.Principal, *frn.Role, frn.PersistenceID(), frn.ContainerPath)
+	// Create reservation (including the ports) + volumes, add to offerHelper
+	if err := offerHelper.MakeReservation(frn.Cpus, frn.Mem, disk, diskSource, ports, *frn.Principal, *frn.Role); err != nil {
+		log.Error("Unable to reserve resources for a node: ", err)
+		return false
+	}
+	offerHelper.MakeVolume(disk, diskSource, *frn.Principal, *frn.Role, frn.PersistenceID(), frn.ContainerPath)
 
 	// Update state
 	frn.SlaveID = offerHelper.MesosOffer.SlaveId
 	frn.Hostname = offerHelper.MesosOffer.GetHostname()
+	frn.AssignedPorts = ports
+	frn.DiskSource = diskSource
+	frn.ReservedDisk = disk
 	frn.CurrentState = process_state.Reserved
+	sc.events.publishNodeEvent(EVENT_RESERVATION_MADE, frn, map[string]interface{}{
+		"OfferID":       offerHelper.OfferIDStr,
+		"Hostname":      frn.Hostname,
+		"PersistenceID": frn.PersistenceID(),
+	})
 	return true
 }
 
 func (frn *FrameworkRiakNode) ApplyReservedOffer(offerHelper *common.OfferHelper, sc *SchedulerCore) bool {
-	taskAsk := []*mesos.Resource{}
-	execAsk := []*mesos.Resource{}
+	var taskAsk, execAsk, portsAsk []*mesos.Resource
+	var err error
+	assignedPorts := frn.AssignedPorts
 	if sc.compatibilityMode {
-		if !offerHelper.CanFitUnreserved(frn.Cpus+CPUS_PER_EXECUTOR, frn.Mem+MEM_PER_EXECUTOR, frn.Disk, frn.Ports) {
+		if !frn.CanFitUnreserved(offerHelper, sc) {
 			return false
 		}
-		taskAsk = offerHelper.ApplyUnreserved(frn.Cpus, frn.Mem, frn.Disk, frn.Ports)
-		execAsk = offerHelper.ApplyUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, 0)
+		if assignedPorts = frn.selectPorts(offerHelper, sc); assignedPorts == nil {
+			return false
+		}
+		taskAsk, err = offerHelper.ApplyUnreserved(frn.Cpus, frn.Mem, frn.Disk, assignedPorts)
+	} else if len(frn.AssignedPorts) == frn.Ports && offerHelper.HasReservedPorts(frn.AssignedPorts) {
+		if !offerHelper.CanFitReserved(frn.Cpus, frn.Mem, frn.volumeSize(), frn.DiskSource, frn.Ports) ||
+			!offerHelper.CanFitUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, 0) {
+			return false
+		}
+		taskAsk, err = offerHelper.ApplyReserved(frn.Cpus, frn.Mem, frn.volumeSize(), frn.DiskSource, frn.AssignedPorts, *frn.Principal, *frn.Role, frn.PersistenceID(), frn.ContainerPath)
 	} else {
-		if !offerHelper.CanFitReserved(frn.Cpus, frn.Mem, frn.Disk, 0) ||
+		// Reservations made before ports were reserved along with the node, take them unreserved
+		if !offerHelper.CanFitReserved(frn.Cpus, frn.Mem, frn.volumeSize(), frn.DiskSource, 0) ||
 			!offerHelper.CanFitUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, frn.Ports) {
 			return false
 		}
-		taskAsk = offerHelper.ApplyReserved(frn.Cpus, frn.Mem, frn.Disk, 0, *frn.Principal, *frn.Role, frn.PersistenceID(), frn.ContainerPath)
-		taskAsk = append(taskAsk, offerHelper.ApplyUnreserved(0, 0, 0, frn.Ports)...)
-		execAsk = offerHelper.ApplyUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, 0)
+		if assignedPorts = frn.selectPorts(offerHelper, sc); assignedPorts == nil {
+			return false
+		}
+		if portsAsk, err = offerHelper.ApplyUnreserved(0, 0, 0, assignedPorts); err == nil {
+			taskAsk, err = offerHelper.ApplyReserved(frn.Cpus, frn.Mem, frn.volumeSize(), frn.DiskSource, nil, *frn.Principal, *frn.Role, frn.PersistenceID(), frn.ContainerPath)
+			taskAsk = append(taskAsk, portsAsk...)
+		}
 	}
+	if err != nil {
+		log.Error("Unable to apply an offer to a node: ", err)
+		return false
+	}
+	frn.AssignedPorts = assignedPorts
+	execAsk, _ = offerHelper.ApplyUnreserved(CPUS_PER_EXECUTOR, MEM_PER_EXECUTOR, 0, nil)
 
 	log.Infof("Found an offer for a launchable node. OfferID: %+v, NodeID: %+v", offerHelper.OfferIDStr, frn.CurrentID())
 
 	frn.SlaveID = offerHelper.MesosOffer.SlaveId
 	frn.Hostname = offerHelper.MesosOffer.GetHostname()
+	frn.AgentURL = offerHelper.AgentURL()
 	frn.Generation = frn.Generation + 1
 	frn.TaskStatus = nil
+	frn.Health = nil
+	frn.InPlaceRestarts = 0
 	frn.CurrentState = process_state.Starting
 
 	taskId := frn.CreateTaskID()
@@ -165,7 +270,28 @@ func (frn *FrameworkRiakNode) ApplyReservedOffer(offerHelper *common.OfferHelper
 	if !strings.Contains(frn.Hostname, ".") {
 		nodename = nodename + "."
 	}
-	ports := common.PortIterator(taskAsk)
+	ports := []int64{}
+	for port := range common.PortIterator(taskAsk) {
+		ports = append(ports, port)
+	}
+	portNames := common.STANDARD_PORT_NAMES
+	var variables map[string]string
+	var healthCheck *common.HealthCheck
+	var logShipping *common.LogShipping
+	var stopSequence *common.StopSequence
+	var processSpec *common.ProcessSpec
+	if cluster, assigned := sc.schedulerState.Clusters[frn.ClusterName]; assigned {
+		portNames = cluster.AllPortNames()
+		healthCheck = cluster.HealthCheck
+		logShipping = cluster.LogShipping
+		stopSequence = cluster.StopSequence
+		processSpec = cluster.ProcessSpec
+		frn.ConfigRevision = cluster.ConfigRevision
+		if revision := cluster.CurrentConfigRevision(); revision != nil {
+			variables = revision.Variables
+		}
+	}
+	namedPorts := common.AssignNamedPorts(portNames, ports, frn.TaskData.NamedPorts)
 
 	taskData := common.TaskData{
 		FullyQualifiedNodeName: nodename,
@@ -174,10 +300,21 @@ func (frn *FrameworkRiakNode) ApplyReservedOffer(offerHelper *common.OfferHelper
 		FrameworkName:  sc.frameworkName,
 		URI:            sc.schedulerHTTPServer.GetURI(),
 		ClusterName:    frn.ClusterName,
-		UseSuperChroot: os.Getenv("USE_SUPER_CHROOT") != "false",
-		HTTPPort:       <-ports,
-		PBPort:         <-ports,
-		DisterlPort:    <-ports,
+		HTTPPort:       namedPorts["http"],
+		PBPort:         namedPorts["pb"],
+		DisterlPort:    namedPorts["disterl"],
+		HandoffPort:    namedPorts["handoff"],
+		NamedPorts:     namedPorts,
+		ConfigRevision: frn.ConfigRevision,
+		Cpus:           frn.Cpus,
+		Mem:            frn.Mem,
+		Disk:           frn.volumeSize(),
+		Attributes:     offerHelper.Attributes(),
+		Variables:      variables,
+		HealthCheck:    healthCheck,
+		LogShipping:    logShipping,
+		StopSequence:   stopSequence,
+		ProcessSpec:    processSpec,
 	}
 	frn.TaskData = taskData
 
@@ -221,6 +358,11 @@ func (frn *FrameworkRiakNode) ApplyReservedOffer(offerHelper *common.OfferHelper
 	}
 
 	offerHelper.TasksToLaunch = append(offerHelper.TasksToLaunch, taskInfo)
+	sc.events.publishNodeEvent(EVENT_OFFER_APPLIED, frn, map[string]interface{}{
+		"OfferID":    offerHelper.OfferIDStr,
+		"Hostname":   frn.Hostname,
+		"Generation": frn.Generation,
+	})
 
 	return true
 }
@@ -255,7 +397,7 @@ func (frn *FrameworkRiakNode) HasRequestedReservation() bool {
 }
 
 func (frn *FrameworkRiakNode) NeedsToBeReconciled() bool {
-	return !frn.reconciled &&
+	return frn.Reconciliation.Status == RECONCILIATION_PENDING &&
 		frn.CurrentState != process_state.Unknown &&
 		frn.CurrentState != process_state.Reserved
 }
@@ -306,16 +448,23 @@ func (frn *FrameworkRiakNode) CanBeScheduled() bool {
 func (frn *FrameworkRiakNode) CanBeKilled() bool {
 	return frn.DestinationState == process_state.Shutdown &&
 		(frn.CurrentState == process_state.Starting ||
-			frn.CurrentState == process_state.Started)
+			frn.CurrentState == process_state.Started ||
+			frn.CurrentState == process_state.Restarting)
 }
+
+// CanBeRemoved is false while the node's task still runs, including while Riak restarts in place
 func (frn *FrameworkRiakNode) CanBeRemoved() bool {
 	return frn.DestinationState == process_state.Shutdown &&
-		frn.CurrentState != process_state.Started
+		frn.CurrentState != process_state.Started &&
+		frn.CurrentState != process_state.Restarting
 }
 func (frn *FrameworkRiakNode) CanJoinCluster() bool {
 	return frn.CurrentState == process_state.Starting &&
 		frn.DestinationState == process_state.Started
 }
+func (frn *FrameworkRiakNode) IsRunning() bool {
+	return frn.CurrentState == process_state.Started
+}
 func (frn *FrameworkRiakNode) CanBeJoined() bool {
 	return frn.CurrentState == process_state.Started &&
 		frn.DestinationState == process_state.Started
@@ -330,7 +479,7 @@ func (frn *FrameworkRiakNode) HasRestarted(generation int64) bool {
 }
 func (frn *FrameworkRiakNode) IsRestarting(generation int64) bool {
 	return (frn.DestinationState == process_state.Started || frn.DestinationState == process_state.Restarting) &&
-		frn.CurrentState != process_state.Started &&
+		(frn.CurrentState != process_state.Started || frn.restartRequested) &&
 		frn.RestartGeneration >= generation
 }
 
@@ -340,9 +489,68 @@ func (frn *FrameworkRiakNode) Restart(generation int64) {
 	frn.DestinationState = process_state.Restarting
 }
 
+// updateHealth records the health the status update carries, if any. Returns true if the node's health changed.
+func (frn *FrameworkRiakNode) updateHealth(status *mesos.TaskStatus) bool {
+	if status.Healthy == nil {
+		return false
+	}
+	if frn.Health != nil && frn.Health.Healthy == status.GetHealthy() {
+		frn.Health.Message = status.GetMessage()
+		return false
+	}
+	frn.Health = &NodeHealth{
+		Healthy: status.GetHealthy(),
+		Message: status.GetMessage(),
+		Since:   time.Now(),
+	}
+	return true
+}
+
+// RestartInPlace records that the node's executor was asked to restart Riak with the taskData, in the same task
+func (frn *FrameworkRiakNode) RestartInPlace(taskData common.TaskData) {
+	frn.InPlaceRestarts = frn.InPlaceRestarts + 1
+	frn.TaskData = taskData
+	frn.ConfigRevision = taskData.ConfigRevision
+	frn.CurrentState = process_state.Restarting
+}
+
+// hasRestartedInPlace is false for the TASK_RUNNING updates the executor sent before its last in-place restart
+func (frn *FrameworkRiakNode) hasRestartedInPlace(status *mesos.TaskStatus) bool {
+	if frn.InPlaceRestarts == 0 {
+		return true
+	}
+	statusData, err := common.DeserializeTaskStatusData(status.GetData())
+	if err != nil {
+		return false
+	}
+	return statusData.InPlaceRestarts >= frn.InPlaceRestarts
+}
+
+func (frn *FrameworkRiakNode) markUnreconciled() {
+	if frn.GetTaskStatus() == nil {
+		frn.Reconciliation = NodeReconciliation{Status: RECONCILIATION_RECONCILED}
+		return
+	}
+	frn.Reconciliation = NodeReconciliation{Status: RECONCILIATION_PENDING}
+}
+
+// askedToReconcile records an explicit reconciliation request for the node's task, and backs off the next one
+func (frn *FrameworkRiakNode) askedToReconcile(now time.Time) {
+	frn.Reconciliation.Attempts = frn.Reconciliation.Attempts + 1
+	nextAttempt := now.Add(reconciliationBackoff(frn.Reconciliation.Attempts))
+	frn.Reconciliation.LastAsked = &now
+	frn.Reconciliation.NextAttempt = &nextAttempt
+}
+
+func (frn *FrameworkRiakNode) Reconciled() {
+	frn.Reconciliation = NodeReconciliation{Status: RECONCILIATION_RECONCILED}
+}
+
 func (frn *FrameworkRiakNode) Unreserve() {
 	frn.CurrentState = process_state.Unknown
 	frn.SlaveID = nil
+	frn.DiskSource = nil
+	frn.ReservedDisk = 0
 }
 func (frn *FrameworkRiakNode) KillNext() {
 	frn.DestinationState = process_state.Shutdown
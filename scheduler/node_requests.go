@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
+	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/satori/go.uuid"
+	"sync"
+	"time"
+)
+
+// How long the API waits for a node's executor to answer a framework message
+const NODE_REQUEST_TIMEOUT = 30 * time.Second
+
+var errNodeRequestTimeout = errors.New("Timed out waiting for the executor to reply")
+
+// NodeRequests keeps track of the framework messages sent to executors which are waiting for a reply
+type NodeRequests struct {
+	lock    *sync.Mutex
+	pending map[string]chan common.FrameworkReply
+}
+
+func NewNodeRequests() *NodeRequests {
+	return &NodeRequests{
+		lock:    &sync.Mutex{},
+		pending: make(map[string]chan common.FrameworkReply),
+	}
+}
+
+// NodeRequest is a framework message sent to an executor, whose reply can be waited for
+type NodeRequest struct {
+	requests *NodeRequests
+	ID       string
+	replies  chan common.FrameworkReply
+}
+
+// Send sends the command to the node's executor, with a new request ID for its reply
+func (requests *NodeRequests) Send(driver sched.SchedulerDriver, riakNode *FrameworkRiakNode, message common.FrameworkMessage) (*NodeRequest, error) {
+	message.Version = common.FRAMEWORK_MESSAGE_VERSION
+	message.RequestID = uuid.NewV4().String()
+	data, err := message.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	request := &NodeRequest{
+		requests: requests,
+		ID:       message.RequestID,
+		replies:  make(chan common.FrameworkReply, 1),
+	}
+	requests.lock.Lock()
+	requests.pending[request.ID] = request.replies
+	requests.lock.Unlock()
+
+	log.Infof("Sending %s to node %s, request %s", message.Command, riakNode.CurrentID(), request.ID)
+	if _, err := driver.SendFrameworkMessage(riakNode.CreateExecutorID(), riakNode.SlaveID, data); err != nil {
+		request.forget()
+		return nil, err
+	}
+	return request, nil
+}
+
+// Reply hands the reply to the request waiting for it, returns false if none is, e.g. because it timed out
+func (requests *NodeRequests) Reply(reply common.FrameworkReply) bool {
+	requests.lock.Lock()
+	defer requests.lock.Unlock()
+	replies, waiting := requests.pending[reply.RequestID]
+	if !waiting {
+		return false
+	}
+	delete(requests.pending, reply.RequestID)
+	replies <- reply
+	return true
+}
+
+// Wait waits up to timeout for the executor's reply, and returns the error the executor replied with if any.
+// Must not be called with the scheduler's lock held, as replies are delivered with it held.
+func (request *NodeRequest) Wait(timeout time.Duration) (common.FrameworkReply, error) {
+	select {
+	case reply := <-request.replies:
+		if reply.Error != "" {
+			return reply, fmt.Errorf("%s failed: %s", reply.Command, reply.Error)
+		}
+		return reply, nil
+	case <-time.After(timeout):
+		request.forget()
+		return common.FrameworkReply{}, errNodeRequestTimeout
+	}
+}
+
+func (request *NodeRequest) forget() {
+	request.requests.lock.Lock()
+	defer request.requests.lock.Unlock()
+	delete(request.requests.pending, request.ID)
+}
@@ -0,0 +1,234 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func nodeRequestsTestRouter(sc *SchedulerCore) *mux.Router {
+	schttp := sc.schedulerHTTPServer
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/diskUsage").HandlerFunc(schttp.nodeDiskUsage)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/diagnostics").HandlerFunc(schttp.nodeDiagnostics)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/nodes/{node}/config/reload").HandlerFunc(schttp.reloadNodeConfig)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/nodes/{node}/admin").HandlerFunc(schttp.nodeAdmin)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/debug").HandlerFunc(schttp.nodeDebug)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/logs/{file}").HandlerFunc(schttp.nodeLog)
+	return router
+}
+
+// serveNodeRequest serves the request in the background, and answers the message it sends to the executor with
+// the reply made by the executor func
+func serveNodeRequest(t *testing.T, sc *SchedulerCore, driver *fakeSchedulerDriver, request *http.Request, executor func(common.FrameworkMessage) *common.FrameworkReply) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	done := make(chan interface{})
+	go func() {
+		nodeRequestsTestRouter(sc).ServeHTTP(recorder, request)
+		close(done)
+	}()
+	driver.waitFor(t, "framework message", func() bool { return len(driver.messages) > 0 })
+	message, err := common.DeserializeFrameworkMessage(driver.messages[0].Data)
+	assert.Nil(t, err)
+	assert.Equal(t, common.FRAMEWORK_MESSAGE_VERSION, message.Version)
+	assert.NotEqual(t, "", message.RequestID)
+	reply, _ := executor(message).Serialize()
+	sc.FrameworkMessage(driver, driver.messages[0].ExecutorID, driver.messages[0].SlaveID, reply)
+	<-done
+	driver.reset()
+	return recorder
+}
+
+func TestNodeRequests(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+
+	request, _ := http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/diskUsage", nil)
+	recorder := serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.COMMAND_REPORT_DISK_USAGE, message.Command)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.DiskUsage{Path: "root/riak/data", UsedBytes: 42}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+	usage := &common.DiskUsage{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(usage))
+	assert.Equal(uint64(42), usage.UsedBytes)
+
+	// The current config revision is rendered, with the node's ports
+	_, configErrors := cluster.SetConfigVariables(map[string]string{"tier": "gold"}, "")
+	assert.Equal(0, len(configErrors))
+	request, _ = http.NewRequest("POST", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/config/reload", nil)
+	recorder = serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.COMMAND_RELOAD_CONFIG, message.Command)
+		assert.Equal(int64(2), message.TaskData.ConfigRevision)
+		assert.Equal(node.TaskData.HTTPPort, message.TaskData.HTTPPort)
+		return common.NewFrameworkReply(message, node.CurrentID(), nil, nil)
+	})
+	assert.Equal(200, recorder.Code)
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/diagnostics", nil)
+	recorder = serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		return common.NewFrameworkReply(message, node.CurrentID(), nil, errors.New("riak is down"))
+	})
+	assert.Equal(500, recorder.Code)
+	assert.Contains(recorder.Body.String(), "riak is down")
+
+	// Replies nothing waits for are ignored
+	reply, _ := (&common.FrameworkReply{RequestID: "unknown"}).Serialize()
+	sc.FrameworkMessage(driver, nil, nil, reply)
+	assert.Equal(0, len(sc.nodeRequests.pending))
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/nodes/unknown/diskUsage", nil)
+	recorder = httptest.NewRecorder()
+	nodeRequestsTestRouter(sc).ServeHTTP(recorder, request)
+	assert.Equal(404, recorder.Code)
+	assert.Equal(0, len(driver.messages))
+}
+
+func TestFrameworkMessageVersions(t *testing.T) {
+	assert := assert.New(t)
+
+	message, err := common.DeserializeFrameworkMessage("finish")
+	assert.Nil(err)
+	assert.Equal(common.COMMAND_FINISH, message.Command)
+
+	_, err = common.DeserializeFrameworkMessage(`{"Version": 2, "Command": "finish"}`)
+	assert.NotNil(err)
+}
+
+func TestNodeAdmin(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	path := "/api/v1/clusters/default/nodes/" + node.CurrentID() + "/admin"
+
+	// Subcommands which aren't allowed never reach the executor
+	for _, body := range []string{
+		`{"Args": []}`,
+		`{"Args": ["leave"]}`,
+		`{"Args": ["cluster", "commit"]}`,
+		`{"Args": ["cluster"]}`,
+		`{"Args": ["transfer-limit", "4"]}`,
+		`{"Args": ["transfer-limit", "riak@10.0.0.1", "4"]}`,
+		`{"Args": ["status"], "TimeoutSeconds": -1}`,
+		`["status"]`,
+	} {
+		request, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		nodeRequestsTestRouter(sc).ServeHTTP(recorder, request)
+		assert.Equal(400, recorder.Code, body)
+	}
+	assert.Equal(0, len(driver.messages))
+
+	request, _ := http.NewRequest("POST", path, strings.NewReader(`{"Args": ["cluster", "status"], "TimeoutSeconds": 5}`))
+	recorder := serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.COMMAND_RUN_RIAK_ADMIN, message.Command)
+		assert.Equal([]string{"cluster", "status"}, message.Args)
+		assert.Equal(int64(5), message.TimeoutSeconds)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.CommandOutput{Stdout: "---- Cluster Status ----", ExitCode: 1}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+	output := &common.CommandOutput{}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(output))
+	assert.Equal(&common.CommandOutput{Stdout: "---- Cluster Status ----", ExitCode: 1}, output)
+
+	// The executor's default timeout is filled in, which the request waits for on top of NODE_REQUEST_TIMEOUT
+	request, _ = http.NewRequest("POST", path, strings.NewReader(`{"Args": ["transfer-limit"]}`))
+	recorder = serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.DEFAULT_RIAK_ADMIN_TIMEOUT_SECONDS, message.TimeoutSeconds)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.CommandOutput{}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+}
+
+func TestNodeDebug(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	assert.Equal("http://"+node.Hostname+":5051", node.AgentURL)
+
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/files/download.json", r.URL.Path)
+		if r.URL.Query().Get("path") != "/sandbox/riak-debug.tar.gz" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte("bundle"))
+	}))
+	defer agent.Close()
+	node.AgentURL = agent.URL
+
+	request, _ := http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/debug?timeout=120", nil)
+	recorder := serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.COMMAND_RUN_RIAK_DEBUG, message.Command)
+		assert.Equal(int64(120), message.TimeoutSeconds)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.DebugBundle{Path: "/sandbox/riak-debug.tar.gz", Size: 6}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+	assert.Equal("bundle", recorder.Body.String())
+	assert.Contains(recorder.Header().Get("Content-Disposition"), node.CurrentID()+"-riak-debug.tar.gz")
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/debug", nil)
+	recorder = serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.DEFAULT_RIAK_DEBUG_TIMEOUT_SECONDS, message.TimeoutSeconds)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.DebugBundle{Path: "/sandbox/riak-debug.tar.gz", Size: 6}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/default/nodes/"+node.CurrentID()+"/debug?timeout=forever", nil)
+	recorder = httptest.NewRecorder()
+	nodeRequestsTestRouter(sc).ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+}
+
+func TestNodeLog(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	path := "/api/v1/clusters/default/nodes/" + node.CurrentID() + "/logs/"
+
+	for uri, code := range map[string]int{
+		path + "riak.conf":                404,
+		path + "console.log?tail=0":       400,
+		path + "console.log?tail=ten":     400,
+		path + "console.log?tail=1000000": 400,
+	} {
+		request, _ := http.NewRequest("GET", uri, nil)
+		recorder := httptest.NewRecorder()
+		nodeRequestsTestRouter(sc).ServeHTTP(recorder, request)
+		assert.Equal(code, recorder.Code, uri)
+	}
+	assert.Equal(0, len(driver.messages))
+
+	request, _ := http.NewRequest("GET", path+"error.log?tail=2", nil)
+	recorder := serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.COMMAND_TAIL_LOG, message.Command)
+		assert.Equal([]string{"error.log"}, message.Args)
+		assert.Equal(2, message.Lines)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.LogTail{File: "error.log", Lines: []string{"first", "second"}}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+	assert.Equal("first\nsecond\n", recorder.Body.String())
+
+	request, _ = http.NewRequest("GET", path+"crash.log", nil)
+	recorder = serveNodeRequest(t, sc, driver, request, func(message common.FrameworkMessage) *common.FrameworkReply {
+		assert.Equal(common.DEFAULT_LOG_TAIL_LINES, message.Lines)
+		return common.NewFrameworkReply(message, node.CurrentID(), &common.LogTail{File: "crash.log", Lines: []string{}}, nil)
+	})
+	assert.Equal(200, recorder.Code)
+	assert.Equal("", recorder.Body.String())
+}
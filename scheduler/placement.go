@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
+	"sort"
+)
+
+const (
+	PLACEMENT_SPREAD string = "spread"
+	PLACEMENT_PACK   string = "pack"
+)
+
+// PlacementStrategy decides which agent a node which needs new resources is placed on. It sees every
+// offer from the current batch, so it can compare agents against each other rather than taking
+// whichever offer happens to come first.
+type PlacementStrategy interface {
+	Name() string
+	// Rank orders the offers the node fits on, most preferred first
+	Rank(riakNode *FrameworkRiakNode, offerHelpers []*common.OfferHelper, placements *nodePlacements) []*common.OfferHelper
+}
+
+func NewPlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case PLACEMENT_SPREAD:
+		return &spreadStrategy{}, nil
+	case PLACEMENT_PACK:
+		return &packStrategy{}, nil
+	}
+	return nil, fmt.Errorf("Unknown placement strategy: %s", name)
+}
+
+// spreadStrategy prefers the agents running the fewest nodes of the same cluster, then the fewest nodes
+// overall, then the agent with the most free resources. This keeps a single agent failure from taking out
+// more of a cluster than it has to.
+type spreadStrategy struct{}
+
+func (strategy *spreadStrategy) Name() string {
+	return PLACEMENT_SPREAD
+}
+
+func (strategy *spreadStrategy) Rank(riakNode *FrameworkRiakNode, offerHelpers []*common.OfferHelper, placements *nodePlacements) []*common.OfferHelper {
+	return rankOffers(offerHelpers, func(a *common.OfferHelper, b *common.OfferHelper) bool {
+		aCluster, bCluster := placements.clusterNodes(riakNode, a), placements.clusterNodes(riakNode, b)
+		if aCluster != bCluster {
+			return aCluster < bCluster
+		}
+		aTotal, bTotal := placements.frameworkNodes(riakNode, a), placements.frameworkNodes(riakNode, b)
+		if aTotal != bTotal {
+			return aTotal < bTotal
+		}
+		if a.UnreservedResources.Cpus != b.UnreservedResources.Cpus {
+			return a.UnreservedResources.Cpus > b.UnreservedResources.Cpus
+		}
+		if a.UnreservedResources.Mem != b.UnreservedResources.Mem {
+			return a.UnreservedResources.Mem > b.UnreservedResources.Mem
+		}
+		return a.OfferIDStr < b.OfferIDStr
+	})
+}
+
+// packStrategy prefers agents already running nodes of this framework, then the offer with the least free
+// resources the node still fits in. This leaves whole agents free for other frameworks.
+type packStrategy struct{}
+
+func (strategy *packStrategy) Name() string {
+	return PLACEMENT_PACK
+}
+
+func (strategy *packStrategy) Rank(riakNode *FrameworkRiakNode, offerHelpers []*common.OfferHelper, placements *nodePlacements) []*common.OfferHelper {
+	return rankOffers(offerHelpers, func(a *common.OfferHelper, b *common.OfferHelper) bool {
+		aTotal, bTotal := placements.frameworkNodes(riakNode, a), placements.frameworkNodes(riakNode, b)
+		if aTotal != bTotal {
+			return aTotal > bTotal
+		}
+		if a.UnreservedResources.Cpus != b.UnreservedResources.Cpus {
+			return a.UnreservedResources.Cpus < b.UnreservedResources.Cpus
+		}
+		if a.UnreservedResources.Mem != b.UnreservedResources.Mem {
+			return a.UnreservedResources.Mem < b.UnreservedResources.Mem
+		}
+		return a.OfferIDStr < b.OfferIDStr
+	})
+}
+
+// nodePlacements looks up which agents the framework's nodes are currently on
+type nodePlacements struct {
+	clusters map[string]*FrameworkRiakCluster
+}
+
+// clusterNodes counts the other nodes of riakNode's cluster on the offering agent
+func (placements *nodePlacements) clusterNodes(riakNode *FrameworkRiakNode, offerHelper *common.OfferHelper) int {
+	cluster, assigned := placements.clusters[riakNode.ClusterName]
+	if !assigned {
+		return 0
+	}
+	return countNodesOnAgent(cluster, riakNode, offerHelper)
+}
+
+// frameworkNodes counts the other nodes of every cluster on the offering agent
+func (placements *nodePlacements) frameworkNodes(riakNode *FrameworkRiakNode, offerHelper *common.OfferHelper) int {
+	count := 0
+	for _, cluster := range placements.clusters {
+		count = count + countNodesOnAgent(cluster, riakNode, offerHelper)
+	}
+	return count
+}
+
+func countNodesOnAgent(cluster *FrameworkRiakCluster, riakNode *FrameworkRiakNode, offerHelper *common.OfferHelper) int {
+	count := 0
+	for _, otherNode := range cluster.Nodes {
+		if otherNode == riakNode || otherNode.SlaveID == nil {
+			continue
+		}
+		if otherNode.SlaveID.GetValue() == offerHelper.MesosOffer.SlaveId.GetValue() {
+			count = count + 1
+		}
+	}
+	return count
+}
+
+// placeNodes gives every node which needs new resources the best offer it fits on, according to the
+// placement strategy. Nodes which fit nowhere wait for the next batch of offers.
+func (sc *SchedulerCore) placeNodes(offerHelpers []*common.OfferHelper) {
+	stateDirty := false
+	placements := &nodePlacements{clusters: sc.schedulerState.Clusters}
+
+	for _, riakNode := range sc.getNodesToPlace() {
+		candidates := []*common.OfferHelper{}
+		for _, offerHelper := range offerHelpers {
+			if riakNode.CanFitUnreserved(offerHelper, sc) {
+				candidates = append(candidates, offerHelper)
+			}
+		}
+
+		for _, offerHelper := range sc.placementStrategy.Rank(riakNode, candidates, placements) {
+			log.Infof("Placing Riak node (%s strategy) on %s: %+v", sc.placementStrategy.Name(), offerHelper.MesosOffer.GetHostname(), riakNode.CurrentID())
+			if riakNode.ApplyNewOffer(offerHelper, sc) {
+				stateDirty = true
+				break
+			}
+		}
+	}
+
+	if stateDirty {
+		sc.schedulerState.Persist()
+	}
+}
+
+// getNodesToPlace returns the nodes of every cluster which need new resources, ordered by cluster name
+func (sc *SchedulerCore) getNodesToPlace() []*FrameworkRiakNode {
+	clusterNames := []string{}
+	for clusterName := range sc.schedulerState.Clusters {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	sort.Strings(clusterNames)
+
+	nodesToPlace := []*FrameworkRiakNode{}
+	for _, clusterName := range clusterNames {
+		nodesToPlace = append(nodesToPlace, sc.schedulerState.Clusters[clusterName].GetNodesToPlace(sc.compatibilityMode)...)
+	}
+	return nodesToPlace
+}
+
+func rankOffers(offerHelpers []*common.OfferHelper, less func(a *common.OfferHelper, b *common.OfferHelper) bool) []*common.OfferHelper {
+	ranked := make([]*common.OfferHelper, len(offerHelpers))
+	copy(ranked, offerHelpers)
+	sort.Stable(&offerRanking{offerHelpers: ranked, less: less})
+	return ranked
+}
+
+type offerRanking struct {
+	offerHelpers []*common.OfferHelper
+	less         func(a *common.OfferHelper, b *common.OfferHelper) bool
+}
+
+func (ranking *offerRanking) Len() int { return len(ranking.offerHelpers) }
+func (ranking *offerRanking) Swap(i, j int) {
+	ranking.offerHelpers[i], ranking.offerHelpers[j] = ranking.offerHelpers[j], ranking.offerHelpers[i]
+}
+func (ranking *offerRanking) Less(i, j int) bool {
+	return ranking.less(ranking.offerHelpers[i], ranking.offerHelpers[j])
+}
+
+type nodesBySimpleId []*FrameworkRiakNode
+
+func (a nodesBySimpleId) Len() int           { return len(a) }
+func (a nodesBySimpleId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a nodesBySimpleId) Less(i, j int) bool { return a[i].SimpleId < a[j].SimpleId }
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func placedSlaves(cluster *FrameworkRiakCluster) map[string]int {
+	slaves := make(map[string]int)
+	for _, node := range cluster.Nodes {
+		slaves[node.SlaveID.GetValue()] = slaves[node.SlaveID.GetValue()] + 1
+	}
+	return slaves
+}
+
+func threeTestOffers() []*mesos.Offer {
+	return []*mesos.Offer{
+		newTestOffer("offer-1", "slave-1", unreservedTestResources(8, 8192, 20000)),
+		newTestOffer("offer-2", "slave-2", unreservedTestResources(8, 8192, 20000)),
+		newTestOffer("offer-3", "slave-3", unreservedTestResources(8, 8192, 20000)),
+	}
+}
+
+func TestSpreadPlacement(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	for i := 0; i < 3; i++ {
+		cluster.CreateNode(sc)
+	}
+
+	sc.ResourceOffers(driver, threeTestOffers())
+	driver.waitForAccepted(t, 3)
+	assert.Equal(map[string]int{"slave-1": 1, "slave-2": 1, "slave-3": 1}, placedSlaves(cluster))
+}
+
+func TestSpreadPlacementAvoidsOccupiedAgents(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(8, 8192, 20000))})
+	driver.waitForAccepted(t, 1)
+
+	// slave-1 has more free resources, but already runs a node of this cluster
+	driver.reset()
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{
+		newTestOffer("offer-2", "slave-1", unreservedTestResources(16, 16384, 40000)),
+		newTestOffer("offer-3", "slave-2", unreservedTestResources(4, 4096, 10000)),
+	})
+	driver.waitForAccepted(t, 1)
+	assert.Equal("slave-2", node.SlaveID.GetValue())
+}
+
+func TestPackPlacement(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	sc.placementStrategy = &packStrategy{}
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	for i := 0; i < 3; i++ {
+		cluster.CreateNode(sc)
+	}
+
+	sc.ResourceOffers(driver, threeTestOffers())
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal(map[string]int{"slave-1": 3}, placedSlaves(cluster))
+	assert.Equal(3, len(accepted[0].Tasks))
+	driver.waitForDeclined(t, 2)
+}
+
+func TestPackPlacementPrefersTightestFit(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	sc.placementStrategy = &packStrategy{}
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{
+		newTestOffer("offer-1", "slave-1", unreservedTestResources(16, 16384, 40000)),
+		newTestOffer("offer-2", "slave-2", unreservedTestResources(2, 2048, 10000)),
+	})
+	driver.waitForAccepted(t, 1)
+	assert.Equal("slave-2", node.SlaveID.GetValue())
+}
+
+func TestUnknownPlacementStrategy(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewPlacementStrategy("random")
+	assert.NotNil(err)
+	strategy, err := NewPlacementStrategy(PLACEMENT_PACK)
+	assert.Nil(err)
+	assert.Equal(PLACEMENT_PACK, strategy.Name())
+}
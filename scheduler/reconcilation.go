@@ -2,69 +2,181 @@ package scheduler
 
 import (
 	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/scheduler/process_state"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	sched "github.com/mesos/mesos-go/scheduler"
-	"sync/atomic"
+	"sort"
 	"time"
 )
 
+// Reconciliation phases. After (re)registering, explicit reconciliation asks Mesos about every task we know of,
+// retrying with a backoff until each one has been answered. Implicit reconciliation then has Mesos tell us about
+// every task it knows of, and is repeated every IMPLICIT_RECONCILIATION_INTERVAL.
+const (
+	RECONCILIATION_PHASE_DISABLED string = "disabled" // Not registered with a master
+	RECONCILIATION_PHASE_EXPLICIT string = "explicit"
+	RECONCILIATION_PHASE_IMPLICIT string = "implicit"
+)
+
+// Reconciliation statuses of a node's task
+const (
+	RECONCILIATION_PENDING    string = "pending"    // Waiting on Mesos for the status of the task
+	RECONCILIATION_RECONCILED string = "reconciled" // The task's status is known, or there is no task
+)
+
+const (
+	RECONCILIATION_BASE_BACKOFF      = 5 * time.Second
+	RECONCILIATION_MAX_BACKOFF       = 5 * time.Minute
+	IMPLICIT_RECONCILIATION_INTERVAL = 10 * time.Minute
+	// Killing, removing and restarting nodes is checked for at least this often
+	RECONCILIATION_LOOP_INTERVAL = 5 * time.Second
+)
+
+// NodeReconciliation is where a node's task is at in reconciliation. It's reset to pending whenever the
+// scheduler state is loaded or the framework registers, since the task may have changed in the meantime.
+type NodeReconciliation struct {
+	Status      string
+	Attempts    int        `json:",omitempty"`
+	LastAsked   *time.Time `json:",omitempty"`
+	NextAttempt *time.Time `json:",omitempty"`
+}
+
+// ReconciliationStatus is reported by the API
+type ReconciliationStatus struct {
+	Phase        string
+	PhaseStarted time.Time
+	LastImplicit *time.Time `json:",omitempty"`
+	// IDs of the nodes still waiting on reconciliation, by cluster
+	PendingNodes map[string][]string
+}
+
 func newReconciliationServer(driver sched.SchedulerDriver, sc *SchedulerCore) *ReconcilationServer {
-	rs := &ReconcilationServer{
-		enabled: atomic.Value{},
-		driver:  driver,
-		sc:      sc,
+	return &ReconcilationServer{
+		driver:         driver,
+		sc:             sc,
+		wakeup:         make(chan struct{}, 1),
+		phase:          RECONCILIATION_PHASE_DISABLED,
+		phaseStarted:   time.Now(),
+		restartTimeout: NODE_REQUEST_TIMEOUT,
 	}
-	rs.enabled.Store(false)
-	go rs.loop()
-	return rs
 }
 
+// ReconcilationServer runs reconciliation, and kills, removes and restarts nodes once their cluster is
+// reconciled. Everything other than wakeup is guarded by the SchedulerCore's lock.
 type ReconcilationServer struct {
-	driver  sched.SchedulerDriver
-	enabled atomic.Value
-	sc      *SchedulerCore
+	driver       sched.SchedulerDriver
+	sc           *SchedulerCore
+	wakeup       chan struct{}
+	phase        string
+	phaseStarted time.Time
+	lastImplicit time.Time
+	// How long an executor gets to accept an in-place restart, before its task is finished instead
+	restartTimeout time.Duration
 }
 
-func (rServer *ReconcilationServer) enable() {
-	log.Info("Reconcilation process enabled")
-	rServer.enabled.Store(true)
+// start begins explicit reconciliation of every known task, called on (re)registration
+func (rServer *ReconcilationServer) start() {
+	log.Info("Reconcilation process started")
+	rServer.setPhase(RECONCILIATION_PHASE_EXPLICIT)
+	rServer.sc.schedulerState.markUnreconciled()
+	rServer.wake()
 }
 
 func (rServer *ReconcilationServer) disable() {
 	log.Info("Reconcilation process disabled")
-	rServer.enabled.Store(false)
+	rServer.setPhase(RECONCILIATION_PHASE_DISABLED)
+}
+
+func (rServer *ReconcilationServer) setPhase(phase string) {
+	rServer.phase = phase
+	rServer.phaseStarted = time.Now()
 }
+
+// wake runs the loop now rather than at the next interval. It doesn't block, so it can be called from
+// scheduler callbacks.
+func (rServer *ReconcilationServer) wake() {
+	select {
+	case rServer.wakeup <- struct{}{}:
+	default:
+	}
+}
+
 func (rServer *ReconcilationServer) reconcile() {
-	// Get Tasks to Reconcile
-	if rServer.enabled.Load().(bool) == true {
-		rServer.sc.lock.Lock()
-		defer rServer.sc.lock.Unlock()
-		if !rServer.reconcileTasks() {
-			rServer.killTasks()
-		}
+	rServer.sc.lock.Lock()
+	defer rServer.sc.lock.Unlock()
+	if rServer.phase == RECONCILIATION_PHASE_DISABLED {
+		return
 	}
+	rServer.reconcileTasks(time.Now())
+	rServer.killTasks()
 }
+
 func (rServer *ReconcilationServer) loop() {
-	rServer.reconcile()
-	ticker := time.Tick(time.Second * 5)
-	for range ticker {
+	for {
 		rServer.reconcile()
+		select {
+		case <-rServer.wakeup:
+		case <-time.After(RECONCILIATION_LOOP_INTERVAL):
+		}
 	}
 }
 
+// finishRiakNode sends the bare "finish" rather than a versioned message, as executors which predate versioned
+// messages would drop one
 func (rServer *ReconcilationServer) finishRiakNode(riakNode *FrameworkRiakNode) bool {
 	log.Infof("Finishing node: %+v", riakNode.CurrentID())
-	status, err := rServer.driver.SendFrameworkMessage(riakNode.CreateExecutorID(), riakNode.SlaveID, "finish")
+	status, err := rServer.driver.SendFrameworkMessage(riakNode.CreateExecutorID(), riakNode.SlaveID, common.COMMAND_FINISH)
 	if status != mesos.Status_DRIVER_RUNNING {
 		log.Fatal("Driver not running, while trying to send framework message")
 	}
 	if err != nil {
-		log.Warnf("Failed to send framework message: ", err)
+		log.Warn("Failed to send framework message: ", err)
 		return false
 	}
 	return true
 }
 
+// restartRiakNode asks the executor of a running node to restart Riak in the same task, which is much quicker than
+// finishing the task and waiting for an offer to launch it again. Returns false if the node has to be relaunched.
+func (rServer *ReconcilationServer) restartRiakNode(cluster *FrameworkRiakCluster, riakNode *FrameworkRiakNode) bool {
+	if !riakNode.IsRunning() {
+		return false
+	}
+	log.Infof("Restarting node in place: %+v", riakNode.CurrentID())
+	taskData := cluster.restartTaskData(riakNode)
+	request, err := rServer.sc.nodeRequests.Send(rServer.driver, riakNode, common.FrameworkMessage{Command: common.COMMAND_RESTART, TaskData: &taskData})
+	if err != nil {
+		log.Warn("Failed to send framework message: ", err)
+		return false
+	}
+	riakNode.restartRequested = true
+	go rServer.awaitRestart(riakNode, taskData, request)
+	return true
+}
+
+// awaitRestart waits for the executor to accept the in-place restart. If it doesn't, the node's task is finished
+// and the node relaunched, as for executors which can't restart Riak in place.
+func (rServer *ReconcilationServer) awaitRestart(riakNode *FrameworkRiakNode, taskData common.TaskData, request *NodeRequest) {
+	_, err := request.Wait(rServer.restartTimeout)
+	rServer.sc.lock.Lock()
+	defer rServer.sc.lock.Unlock()
+	riakNode.restartRequested = false
+	// The node may have been killed or restarted another way meanwhile
+	if riakNode.DestinationState != process_state.Restarting || !riakNode.IsRunning() {
+		return
+	}
+	if err != nil {
+		log.Warnf("Node %s wasn't restarted in place, finishing its task: %v", riakNode.CurrentID(), err)
+		if !rServer.finishRiakNode(riakNode) {
+			rServer.killRiakNode(riakNode)
+		}
+		return
+	}
+	riakNode.RestartInPlace(taskData)
+	rServer.sc.schedulerState.Persist()
+}
+
 func (rServer *ReconcilationServer) killRiakNode(riakNode *FrameworkRiakNode) bool {
 	log.Infof("Killing node: %+v", riakNode.CurrentID())
 	status, err := rServer.driver.KillTask(riakNode.CreateTaskID())
@@ -72,7 +184,7 @@ func (rServer *ReconcilationServer) killRiakNode(riakNode *FrameworkRiakNode) bo
 		log.Fatal("Driver not running, while trying to kill tasks")
 	}
 	if err != nil {
-		log.Warnf("Failed to kill tasks: ", err)
+		log.Warn("Failed to kill tasks: ", err)
 		return false
 	}
 	return true
@@ -82,6 +194,11 @@ func (rServer *ReconcilationServer) killTasks() {
 	// Get Tasks to Kill
 	stateDirty := false
 	for _, cluster := range rServer.sc.schedulerState.Clusters {
+		// The nodes of a cluster which is still being reconciled may not be where we think they are
+		if cluster.IsReconciling() {
+			continue
+		}
+
 		nodesToKill, nodesToRemove := cluster.GetNodesToKillOrRemove()
 		for _, riakNode := range nodesToKill {
 			if !rServer.finishRiakNode(riakNode) {
@@ -94,6 +211,8 @@ func (rServer *ReconcilationServer) killTasks() {
 				cluster.RemoveNode(riakNode)
 			}
 			stateDirty = true
+			// Removed nodes can leave reservations behind, which only get cleaned up when they're offered to us
+			rServer.sc.reviveOffers()
 		}
 
 		if cluster.CanBeRemoved() {
@@ -102,12 +221,23 @@ func (rServer *ReconcilationServer) killTasks() {
 			stateDirty = true
 		}
 
+		wasRestarting := cluster.IsRestarting
 		nodesToRestart, stateModified := cluster.GetNodesToRestart()
 		for _, riakNode := range nodesToRestart {
-			if !rServer.finishRiakNode(riakNode) {
+			rServer.sc.events.publishNodeEvent(EVENT_RESTART_PROGRESS, riakNode, map[string]interface{}{
+				"Generation": cluster.Generation,
+				"Restarting": riakNode.CurrentID(),
+			})
+			if !rServer.restartRiakNode(cluster, riakNode) && !rServer.finishRiakNode(riakNode) {
 				rServer.killRiakNode(riakNode)
 			}
 		}
+		if wasRestarting && !cluster.IsRestarting {
+			rServer.sc.events.Publish(EVENT_RESTART_PROGRESS, cluster.Name, "", map[string]interface{}{
+				"Generation": cluster.Generation,
+				"Finished":   true,
+			})
+		}
 		if stateModified {
 			stateDirty = true
 		}
@@ -117,16 +247,83 @@ func (rServer *ReconcilationServer) killTasks() {
 	}
 }
 
-func (rServer *ReconcilationServer) reconcileTasks() bool {
-	tasksReconcilable := false
-	for _, cluster := range rServer.sc.schedulerState.Clusters {
-		tasksToReconcile := cluster.GetNodeTasksToReconcile()
+func (rServer *ReconcilationServer) reconcileTasks(now time.Time) {
+	switch rServer.phase {
+	case RECONCILIATION_PHASE_EXPLICIT:
+		tasksToReconcile := []*mesos.TaskStatus{}
+		for _, cluster := range rServer.sc.schedulerState.Clusters {
+			tasksToReconcile = append(tasksToReconcile, cluster.GetNodeTasksToReconcile(now)...)
+		}
 		if len(tasksToReconcile) != 0 {
-			tasksReconcilable = true
 			log.Debug("Reconciling tasks: ", tasksToReconcile)
 			rServer.driver.ReconcileTasks(tasksToReconcile)
 		}
+		if len(rServer.pendingNodes()) == 0 {
+			log.Infof("Explicit reconciliation finished after %v, starting implicit reconciliation", now.Sub(rServer.phaseStarted))
+			rServer.setPhase(RECONCILIATION_PHASE_IMPLICIT)
+			rServer.reconcileImplicitly(now)
+		}
+	case RECONCILIATION_PHASE_IMPLICIT:
+		if now.Sub(rServer.lastImplicit) >= IMPLICIT_RECONCILIATION_INTERVAL {
+			rServer.reconcileImplicitly(now)
+		}
+	}
+}
+
+func (rServer *ReconcilationServer) reconcileImplicitly(now time.Time) {
+	log.Info("Reconciling all tasks implicitly")
+	rServer.lastImplicit = now
+	rServer.driver.ReconcileTasks([]*mesos.TaskStatus{})
+}
+
+func (rServer *ReconcilationServer) pendingNodes() map[string][]string {
+	pendingNodes := make(map[string][]string)
+	for _, cluster := range rServer.sc.schedulerState.Clusters {
+		nodeIDs := []string{}
+		for _, riakNode := range cluster.Nodes {
+			if riakNode.NeedsToBeReconciled() {
+				nodeIDs = append(nodeIDs, riakNode.CurrentID())
+			}
+		}
+		if len(nodeIDs) > 0 {
+			sort.Strings(nodeIDs)
+			pendingNodes[cluster.Name] = nodeIDs
+		}
 	}
+	return pendingNodes
+}
 
-	return tasksReconcilable
+func (rServer *ReconcilationServer) Status() *ReconciliationStatus {
+	status := &ReconciliationStatus{
+		Phase:        rServer.phase,
+		PhaseStarted: rServer.phaseStarted,
+		PendingNodes: rServer.pendingNodes(),
+	}
+	if !rServer.lastImplicit.IsZero() {
+		lastImplicit := rServer.lastImplicit
+		status.LastImplicit = &lastImplicit
+	}
+	return status
+}
+
+// reconciliationBackoff doubles the wait between explicit reconciliation requests for a task, up to a maximum
+func reconciliationBackoff(attempts int) time.Duration {
+	backoff := RECONCILIATION_BASE_BACKOFF
+	for i := 1; i < attempts && backoff < RECONCILIATION_MAX_BACKOFF; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > RECONCILIATION_MAX_BACKOFF {
+		return RECONCILIATION_MAX_BACKOFF
+	}
+	return backoff
+}
+
+// markUnreconciled sets every node with a task back to pending, since nothing we know about the task can be
+// trusted until Mesos confirms it
+func (ss *SchedulerState) markUnreconciled() {
+	for _, cluster := range ss.Clusters {
+		for _, riakNode := range cluster.Nodes {
+			riakNode.markUnreconciled()
+		}
+	}
 }
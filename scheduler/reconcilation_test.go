@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// runningTestNode launches a node in compatibility mode and reports it running
+func runningTestNode(t *testing.T, sc *SchedulerCore, driver *fakeSchedulerDriver, cluster *FrameworkRiakCluster) *FrameworkRiakNode {
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-"+cluster.Name, "slave-"+cluster.Name, unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+	driver.reset()
+	return node
+}
+
+func TestExplicitThenImplicitReconciliation(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	assert.Equal(RECONCILIATION_PHASE_DISABLED, sc.rServer.Status().Phase)
+
+	sc.Registered(driver, util.NewFrameworkID("riak-framework"), &mesos.MasterInfo{})
+	assert.Equal(RECONCILIATION_PHASE_EXPLICIT, sc.rServer.Status().Phase)
+	assert.Equal(RECONCILIATION_PENDING, node.Reconciliation.Status)
+	assert.Equal([]string{node.CurrentID()}, sc.rServer.Status().PendingNodes["default"])
+
+	// The task is asked about again only once its backoff has passed, and the backoff doubles each time
+	now := time.Now()
+	sc.rServer.reconcileTasks(now)
+	sc.rServer.reconcileTasks(now.Add(time.Second))
+	assert.Equal(1, len(driver.reconciled))
+	assert.Equal(node.CurrentID(), driver.reconciled[0][0].TaskId.GetValue())
+	sc.rServer.reconcileTasks(now.Add(RECONCILIATION_BASE_BACKOFF))
+	assert.Equal(2, len(driver.reconciled))
+	assert.Equal(now.Add(3*RECONCILIATION_BASE_BACKOFF), *node.Reconciliation.NextAttempt)
+
+	// Once every task has been heard from, the framework moves on to implicit reconciliation
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+	assert.Equal(RECONCILIATION_RECONCILED, node.Reconciliation.Status)
+	sc.rServer.reconcileTasks(now)
+	assert.Equal(RECONCILIATION_PHASE_IMPLICIT, sc.rServer.Status().Phase)
+	assert.Equal(3, len(driver.reconciled))
+	assert.Equal(0, len(driver.reconciled[2]))
+
+	sc.rServer.reconcileTasks(now.Add(time.Minute))
+	assert.Equal(3, len(driver.reconciled))
+	sc.rServer.reconcileTasks(now.Add(IMPLICIT_RECONCILIATION_INTERVAL))
+	assert.Equal(4, len(driver.reconciled))
+}
+
+func TestReconciliationBlocksLaunchesPerCluster(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	reconciling := NewFrameworkRiakCluster("reconciling")
+	sc.schedulerState.Clusters[reconciling.Name] = reconciling
+	runningTestNode(t, sc, driver, reconciling)
+	reconciled := NewFrameworkRiakCluster("reconciled")
+	sc.schedulerState.Clusters[reconciled.Name] = reconciled
+	sc.Registered(driver, util.NewFrameworkID("riak-framework"), &mesos.MasterInfo{})
+
+	blockedNode := reconciling.CreateNode(sc)
+	newNode := reconciled.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{
+		newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000)),
+		newTestOffer("offer-2", "slave-2", unreservedTestResources(4, 4096, 10000)),
+	})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(1, newNode.Generation)
+	assert.Equal(0, blockedNode.Generation)
+	assert.True(reconciling.IsReconciling())
+	assert.False(reconciled.IsReconciling())
+}
+
+func TestReconciliationBackoff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(RECONCILIATION_BASE_BACKOFF, reconciliationBackoff(1))
+	assert.Equal(2*RECONCILIATION_BASE_BACKOFF, reconciliationBackoff(2))
+	assert.Equal(RECONCILIATION_MAX_BACKOFF, reconciliationBackoff(20))
+}
@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/common"
+	"time"
+)
+
+const (
+	DEFAULT_VOLUME_GRACE_PERIOD = 24 * time.Hour
+)
+
+// Who a persistent volume belongs to, going by its persistence ID
+const (
+	VOLUME_OWNER_NODE      string = "node"      // A node which hasn't been removed
+	VOLUME_OWNER_GRAVEYARD string = "graveyard" // A removed node, or a node of a removed cluster
+	VOLUME_OWNER_UNKNOWN   string = "unknown"   // Nothing in the scheduler state
+)
+
+// ReservationGC reclaims the reservations and persistent volumes offered back to us which no node can use.
+// Volumes are only destroyed once they've gone unclaimed for the grace period, so that a brief inconsistency
+// in the scheduler state can't wipe data. With dryRun set, nothing is reclaimed, it's only reported.
+type ReservationGC struct {
+	gracePeriod   time.Duration
+	dryRun        bool
+	orphanedSince map[string]time.Time
+	agents        map[string]*AgentReservations
+}
+
+// AgentReservations is what was last offered back to us as reserved on an agent, along with the volumes of
+// the nodes currently running there
+type AgentReservations struct {
+	SlaveID        string
+	Hostname       string
+	LastOffered    time.Time
+	Unused         common.ResourceGroup
+	Volumes        []*VolumeReservation
+	WouldUnreserve bool
+}
+
+type VolumeReservation struct {
+	PersistenceID string
+	Disk          float64
+	Owner         string
+	ClusterName   string `json:",omitempty"`
+	NodeID        string `json:",omitempty"`
+	InUse         bool
+	UntilPurged   bool
+	OrphanedSince *time.Time `json:",omitempty"`
+	ReclaimAfter  *time.Time `json:",omitempty"`
+	WouldDestroy  bool
+}
+
+type ReservationReport struct {
+	GracePeriod string
+	DryRun      bool
+	Agents      map[string]*AgentReservations
+}
+
+func NewReservationGC(gracePeriod time.Duration, dryRun bool) *ReservationGC {
+	return &ReservationGC{
+		gracePeriod:   gracePeriod,
+		dryRun:        dryRun,
+		orphanedSince: make(map[string]time.Time),
+		agents:        make(map[string]*AgentReservations),
+	}
+}
+
+// Collect looks at the reservations left over on an offer once nodes have been launched on it. Orphaned
+// volumes past their grace period are destroyed, and if nothing on the offer is in use or being kept, the
+// rest of the reserved resources are unreserved.
+func (gc *ReservationGC) Collect(offerHelper *common.OfferHelper, ss *SchedulerState) {
+	now := time.Now()
+	agent := &AgentReservations{
+		SlaveID:     offerHelper.MesosOffer.SlaveId.GetValue(),
+		Hostname:    offerHelper.MesosOffer.GetHostname(),
+		LastOffered: now,
+		Unused:      *offerHelper.ReservedResources,
+		Volumes:     []*VolumeReservation{},
+	}
+	keepReservations := len(offerHelper.TasksToLaunch) > 0
+
+	for _, volume := range offerHelper.ReservedVolumes() {
+		reservation := gc.checkVolume(volume.Disk.Persistence.GetId(), volume.Scalar.GetValue(), ss, now)
+		agent.Volumes = append(agent.Volumes, reservation)
+		if !reservation.WouldDestroy {
+			keepReservations = true
+			continue
+		}
+		if !gc.dryRun {
+			offerHelper.DestroyVolume(volume)
+			delete(gc.orphanedSince, reservation.PersistenceID)
+		}
+	}
+
+	agent.WouldUnreserve = !keepReservations && offerHelper.OfferHasReservations()
+	if agent.WouldUnreserve && !gc.dryRun {
+		offerHelper.UnreserveResources()
+	}
+	gc.agents[agent.SlaveID] = agent
+}
+
+func (gc *ReservationGC) checkVolume(persistenceID string, disk float64, ss *SchedulerState, now time.Time) *VolumeReservation {
+	reservation := &VolumeReservation{
+		PersistenceID: persistenceID,
+		Disk:          disk,
+		Owner:         VOLUME_OWNER_UNKNOWN,
+	}
+	node, cluster, owner := ss.findNodeByPersistenceID(persistenceID)
+	if node != nil {
+		reservation.Owner = owner
+		reservation.ClusterName = node.ClusterName
+		reservation.NodeID = node.CurrentID()
+	}
+	if reservation.Owner == VOLUME_OWNER_NODE {
+		reservation.InUse = true
+		delete(gc.orphanedSince, persistenceID)
+		return reservation
+	}
+
+	orphanedSince, assigned := gc.orphanedSince[persistenceID]
+	if !assigned {
+		log.Warnf("Found a persistent volume which doesn't belong to any node (owner: %s): %s", reservation.Owner, persistenceID)
+		orphanedSince = now
+		gc.orphanedSince[persistenceID] = orphanedSince
+	}
+	reservation.OrphanedSince = &orphanedSince
+
+	// Volumes of removed nodes follow their cluster's retention policy
+	reclaimAfter := orphanedSince.Add(gc.gracePeriod)
+	if reservation.Owner == VOLUME_OWNER_GRAVEYARD {
+		var untilPurged bool
+		reclaimAfter, untilPurged = cluster.VolumeReclaimTime(node, reclaimAfter)
+		if untilPurged {
+			reservation.UntilPurged = true
+			return reservation
+		}
+	}
+	reservation.ReclaimAfter = &reclaimAfter
+	reservation.WouldDestroy = !now.Before(reclaimAfter)
+	return reservation
+}
+
+// Report merges what was last offered from each agent with the volumes of the nodes running on them
+func (gc *ReservationGC) Report(ss *SchedulerState) *ReservationReport {
+	report := &ReservationReport{
+		GracePeriod: gc.gracePeriod.String(),
+		DryRun:      gc.dryRun,
+		Agents:      make(map[string]*AgentReservations),
+	}
+	for slaveID, offered := range gc.agents {
+		agent := *offered
+		agent.Volumes = append([]*VolumeReservation{}, offered.Volumes...)
+		report.Agents[slaveID] = &agent
+	}
+
+	for _, cluster := range ss.Clusters {
+		for _, node := range cluster.Nodes {
+			if !node.HasRequestedReservation() || node.SlaveID == nil {
+				continue
+			}
+			agent, assigned := report.Agents[node.SlaveID.GetValue()]
+			if !assigned {
+				agent = &AgentReservations{
+					SlaveID:  node.SlaveID.GetValue(),
+					Hostname: node.Hostname,
+					Volumes:  []*VolumeReservation{},
+				}
+				report.Agents[agent.SlaveID] = agent
+			}
+			if agent.hasVolume(node.PersistenceID()) {
+				continue
+			}
+			agent.Volumes = append(agent.Volumes, &VolumeReservation{
+				PersistenceID: node.PersistenceID(),
+				Disk:          node.volumeSize(),
+				Owner:         VOLUME_OWNER_NODE,
+				ClusterName:   node.ClusterName,
+				NodeID:        node.CurrentID(),
+				InUse:         true,
+			})
+		}
+	}
+	return report
+}
+
+func (agent *AgentReservations) hasVolume(persistenceID string) bool {
+	for _, volume := range agent.Volumes {
+		if volume.PersistenceID == persistenceID {
+			return true
+		}
+	}
+	return false
+}
+
+// findNodeByPersistenceID looks through every cluster and graveyard for the node a volume was created for
+func (ss *SchedulerState) findNodeByPersistenceID(persistenceID string) (*FrameworkRiakNode, *FrameworkRiakCluster, string) {
+	for _, cluster := range ss.Clusters {
+		for _, node := range cluster.Nodes {
+			if node.PersistenceID() == persistenceID {
+				return node, cluster, VOLUME_OWNER_NODE
+			}
+		}
+	}
+	for _, clusters := range []map[string]*FrameworkRiakCluster{ss.Clusters, ss.Graveyard} {
+		for _, cluster := range clusters {
+			for _, node := range cluster.Nodes {
+				if node.PersistenceID() == persistenceID {
+					return node, cluster, VOLUME_OWNER_GRAVEYARD
+				}
+			}
+			for _, node := range cluster.Graveyard {
+				if node.PersistenceID() == persistenceID {
+					return node, cluster, VOLUME_OWNER_GRAVEYARD
+				}
+			}
+		}
+	}
+	return nil, nil, VOLUME_OWNER_UNKNOWN
+}
@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// orphanedVolumeOffer reserves resources and a volume for a node, then removes the node, and returns what
+// Mesos would offer back for it
+func orphanedVolumeOffer(t *testing.T, sc *SchedulerCore, driver *fakeSchedulerDriver) (*FrameworkRiakNode, []*mesos.Resource) {
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	reserved := reservedTestResources(accepted[0].Operations)
+
+	delete(cluster.Nodes, node.CurrentID())
+	cluster.Graveyard[node.CurrentID()] = node
+	driver.reset()
+	return node, reserved
+}
+
+func TestRemovedNodeVolumeKeptForGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	node, reserved := orphanedVolumeOffer(t, sc, driver)
+
+	// Within the grace period, nothing is reclaimed
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", reserved)})
+	driver.waitForDeclined(t, 1)
+	assert.Equal(0, len(driver.accepted))
+	report := sc.reservationGC.Report(sc.schedulerState)
+	volume := report.Agents["slave-1"].Volumes[0]
+	assert.Equal(node.PersistenceID(), volume.PersistenceID)
+	assert.Equal(VOLUME_OWNER_GRAVEYARD, volume.Owner)
+	assert.False(volume.WouldDestroy)
+	assert.False(report.Agents["slave-1"].WouldUnreserve)
+
+	// Once it has passed, the volume is destroyed and the rest unreserved
+	sc.reservationGC.orphanedSince[node.PersistenceID()] = time.Now().Add(-DEFAULT_VOLUME_GRACE_PERIOD)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-3", "slave-1", reserved)})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal([]mesos.Offer_Operation_Type{mesos.Offer_Operation_DESTROY, mesos.Offer_Operation_UNRESERVE}, operationTypes(accepted[0].Operations))
+	assert.Equal(node.PersistenceID(), accepted[0].Operations[0].Destroy.Volumes[0].Disk.Persistence.GetId())
+	unreserved := map[string]bool{}
+	for _, resource := range accepted[0].Operations[1].Unreserve.Resources {
+		unreserved[resource.GetName()] = true
+	}
+	assert.Equal(map[string]bool{"cpus": true, "mem": true, "disk": true, "ports": true}, unreserved)
+}
+
+func TestReservationGCDryRun(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	sc.reservationGC = NewReservationGC(0, true)
+	_, reserved := orphanedVolumeOffer(t, sc, driver)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", reserved)})
+	driver.waitForDeclined(t, 1)
+	assert.Equal(0, len(driver.accepted))
+	report := sc.reservationGC.Report(sc.schedulerState)
+	assert.True(report.DryRun)
+	assert.True(report.Agents["slave-1"].Volumes[0].WouldDestroy)
+	assert.True(report.Agents["slave-1"].WouldUnreserve)
+}
+
+func TestReservationReportIncludesRunningNodes(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+
+	report := sc.reservationGC.Report(sc.schedulerState)
+	volume := report.Agents["slave-1"].Volumes[0]
+	assert.Equal(node.PersistenceID(), volume.PersistenceID)
+	assert.Equal(node.CurrentID(), volume.NodeID)
+	assert.True(volume.InUse)
+	assert.False(volume.WouldDestroy)
+}
+
+func TestVolumeRetentionPolicies(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	node, reserved := orphanedVolumeOffer(t, sc, driver)
+	cluster := sc.schedulerState.Clusters["default"]
+	node.RemovedAt = time.Now().Add(-2 * time.Hour)
+
+	cluster.VolumeRetention = &VolumeRetention{Policy: VOLUME_RETENTION_KEEP, Hours: 3}
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", reserved)})
+	driver.waitForDeclined(t, 1)
+	assert.Equal(1, len(cluster.RetainedVolumes(DEFAULT_VOLUME_GRACE_PERIOD)))
+
+	cluster.VolumeRetention = &VolumeRetention{Policy: VOLUME_RETENTION_UNTIL_PURGED}
+	sc.reservationGC.orphanedSince[node.PersistenceID()] = time.Now().Add(-2 * DEFAULT_VOLUME_GRACE_PERIOD)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-3", "slave-1", reserved)})
+	driver.waitForDeclined(t, 2)
+	assert.True(sc.reservationGC.Report(sc.schedulerState).Agents["slave-1"].Volumes[0].UntilPurged)
+
+	assert.Nil(cluster.PurgeVolume(node.PersistenceID()))
+	assert.Equal(0, len(cluster.RetainedVolumes(DEFAULT_VOLUME_GRACE_PERIOD)))
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-4", "slave-1", reserved)})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal(mesos.Offer_Operation_DESTROY, accepted[0].Operations[0].GetType())
+	assert.NotNil(cluster.PurgeVolume(node.PersistenceID()))
+
+	assert.NotNil((&VolumeRetention{Policy: VOLUME_RETENTION_KEEP}).Validate())
+	assert.NotNil((&VolumeRetention{Policy: "forever"}).Validate())
+}
+
+func TestReattachVolume(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	deadNode, reserved := orphanedVolumeOffer(t, sc, driver)
+	cluster := sc.schedulerState.Clusters["default"]
+	cluster.VolumeRetention = &VolumeRetention{Policy: VOLUME_RETENTION_DESTROY}
+
+	node, err := cluster.ReattachVolume(sc, deadNode.PersistenceID())
+	assert.Nil(err)
+	assert.NotEqual(deadNode.CurrentID(), node.CurrentID())
+	assert.Equal(deadNode.PersistenceID(), node.PersistenceID())
+	_, err = cluster.ReattachVolume(sc, deadNode.PersistenceID())
+	assert.NotNil(err)
+
+	// The new node launches on the old volume, which isn't destroyed in spite of the retention policy
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", reserved)})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal([]mesos.Offer_Operation_Type{mesos.Offer_Operation_LAUNCH}, operationTypes(accepted[0].Operations))
+	assert.Equal(node.CurrentID(), accepted[0].Operations[0].Launch.TaskInfos[0].TaskId.GetValue())
+	assert.Equal(deadNode.AssignedPorts, node.AssignedPorts)
+}
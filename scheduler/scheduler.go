@@ -14,12 +14,19 @@ import (
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 )
 
 const (
 	OFFER_INTERVAL float64 = 5
 )
 
+// Defaults for how long Mesos should hold back resources from an agent after we decline an offer from it
+const (
+	DEFAULT_REFUSE_SECONDS      float64 = 30
+	DEFAULT_IDLE_REFUSE_SECONDS float64 = 300
+)
+
 type SchedulerCore struct {
 	lock                *sync.Mutex
 	schedulerHTTPServer *SchedulerHTTPServer
@@ -39,6 +46,19 @@ type SchedulerCore struct {
 	mesosAuthPrincipal  string
 	mesosAuthSecretFile string
 	compatibilityMode   bool
+	// Offers nothing can use are declined for refuseSeconds while nodes are waiting to be scheduled, and for
+	// idleRefuseSeconds when no node needs resources at all. The vendored driver can't suppress offers, so while
+	// offersIdle Mesos still sends offers once those filters expire, and reviveOffers only clears the filters.
+	refuseSeconds     float64
+	idleRefuseSeconds float64
+	offersIdle        bool
+	driver            sched.SchedulerDriver
+	placementStrategy PlacementStrategy
+	reservationGC     *ReservationGC
+	events            *EventBroker
+	webhooks          *WebhookDispatcher
+	auditLog          *AuditLog
+	nodeRequests      *NodeRequests
 }
 
 func NewSchedulerCore(
@@ -54,7 +74,22 @@ func NewSchedulerCore(
 	authProvider string,
 	mesosAuthPrincipal string,
 	mesosAuthSecretFile string,
-	useReservations bool) *SchedulerCore {
+	useReservations bool,
+	refuseSeconds float64,
+	idleRefuseSeconds float64,
+	placementStrategy string,
+	volumeGracePeriod time.Duration,
+	reservationGCDryRun bool,
+	auditTrustedProxies string) *SchedulerCore {
+
+	strategy, err := NewPlacementStrategy(placementStrategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	trustedProxies, err := ParseTrustedProxies(auditTrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	mgr := metamgr.NewMetadataManager(frameworkName, zookeepers)
 	ss := GetSchedulerState(mgr)
@@ -79,7 +114,17 @@ func NewSchedulerCore(
 		mesosAuthPrincipal:  mesosAuthPrincipal,
 		mesosAuthSecretFile: mesosAuthSecretFile,
 		compatibilityMode:   !useReservations,
+
+		refuseSeconds:     refuseSeconds,
+		idleRefuseSeconds: idleRefuseSeconds,
+		placementStrategy: strategy,
+		reservationGC:     NewReservationGC(volumeGracePeriod, reservationGCDryRun),
+		events:            NewEventBroker(),
+		nodeRequests:      NewNodeRequests(),
 	}
+	scheduler.webhooks = newWebhookDispatcher(scheduler)
+	scheduler.events.AddListener(scheduler.webhooks.enqueue)
+	scheduler.auditLog = NewAuditLog(mgr, scheduler, trustedProxies)
 	scheduler.schedulerHTTPServer = ServeExecutorArtifact(scheduler, schedulerHostname)
 	return scheduler
 }
@@ -159,7 +204,10 @@ func (sc *SchedulerCore) Run(mesosMaster string) {
 	if err != nil {
 		log.Error("Unable to create a SchedulerDriver ", err.Error())
 	}
+	sc.driver = driver
 	sc.rServer = newReconciliationServer(driver, sc)
+	go sc.rServer.loop()
+	go sc.webhooks.loop()
 
 	sc.mgr.SetupFramework(sc.schedulerHTTPServer.URI)
 
@@ -178,7 +226,7 @@ func (sc *SchedulerCore) Registered(driver sched.SchedulerDriver, frameworkId *m
 	if err := sc.schedulerState.Persist(); err != nil {
 		log.Error("Unable to persist framework ID after startup")
 	}
-	sc.rServer.enable()
+	sc.rServer.start()
 }
 
 func (sc *SchedulerCore) Reregistered(driver sched.SchedulerDriver, masterInfo *mesos.MasterInfo) {
@@ -186,12 +234,13 @@ func (sc *SchedulerCore) Reregistered(driver sched.SchedulerDriver, masterInfo *
 	defer sc.lock.Unlock()
 	log.Error("Framework reregistered")
 	log.Info("Master Info: ", masterInfo)
-	sc.rServer.enable()
+	sc.rServer.start()
 }
 func (sc *SchedulerCore) Disconnected(sched.SchedulerDriver) {
 	sc.lock.Lock()
 	defer sc.lock.Unlock()
 	log.Error("Framework disconnected")
+	sc.rServer.disable()
 }
 
 func (sc *SchedulerCore) ResourceOffers(driver sched.SchedulerDriver, offers []*mesos.Offer) {
@@ -200,38 +249,95 @@ func (sc *SchedulerCore) ResourceOffers(driver sched.SchedulerDriver, offers []*
 
 	operations := sc.createOperationsForOffers(offers)
 
-	// Attempt to reserve resources and/or launch nodes
+	if !sc.needsOffers() {
+		if !sc.offersIdle {
+			log.Info("No nodes need resources, declining offers for longer")
+		}
+		sc.offersIdle = true
+	}
+	refuseSeconds := sc.refuseSeconds
+	if sc.offersIdle {
+		refuseSeconds = sc.idleRefuseSeconds
+	}
+
+	// Attempt to reserve resources and/or launch nodes, decline everything else
 	for _, offer := range offers {
 		offerOperations := operations[*offer.Id.Value]
 
-		if offerOperations == nil {
-			offerOperations = []*mesos.Offer_Operation{}
+		if len(offerOperations) == 0 {
+			go sc.declineOffer(driver, offer, refuseSeconds)
+			continue
 		}
 
 		go sc.acceptOffer(driver, offer, offerOperations)
 	}
 }
 
+// needsOffers is true while any node is waiting on resources, or could be once it has been reconciled
+func (sc *SchedulerCore) needsOffers() bool {
+	for _, cluster := range sc.schedulerState.Clusters {
+		if cluster.NeedsOffers() {
+			return true
+		}
+	}
+	return false
+}
+
+// reviveOffers removes the filters set by declineOffer, so Mesos sends us everything again.
+// Driver calls block on the driver's event lock, which is held during scheduler callbacks, so this is done asynchronously
+func (sc *SchedulerCore) reviveOffers() {
+	sc.offersIdle = false
+	if sc.driver == nil {
+		return
+	}
+	go func(driver sched.SchedulerDriver) {
+		log.Info("Reviving offers")
+		status, err := driver.ReviveOffers()
+		if status != mesos.Status_DRIVER_RUNNING {
+			log.Error("Driver not running, while trying to revive offers")
+		}
+		if err != nil {
+			log.Error("Failed to revive offers: ", err)
+		}
+	}(sc.driver)
+}
+
+// maybeReviveOffers revives offers if they were declined for longer while idle, but a node has since become schedulable
+func (sc *SchedulerCore) maybeReviveOffers() {
+	if sc.offersIdle && sc.needsOffers() {
+		sc.reviveOffers()
+	}
+}
+
 func (sc *SchedulerCore) createOperationsForOffers(offers []*mesos.Offer) map[string][]*mesos.Offer_Operation {
 	operations := make(map[string][]*mesos.Offer_Operation)
 
-	// Populate operations
+	offerHelpers := []*common.OfferHelper{}
+	needsReconciliation := make(map[string]bool)
+
+	// Launch nodes which already have resources reserved on the offering agents
 	for _, offer := range offers {
-		needsReconciliation := false
 		offerHelper := common.NewOfferHelper(offer)
 		log.Infof("Got offer with these resources: %s", offerHelper.String())
 
 		for _, cluster := range sc.schedulerState.Clusters {
 			if cluster.ApplyOffer(offerHelper, sc) {
-				needsReconciliation = true
+				needsReconciliation[offerHelper.OfferIDStr] = true
 			}
 		}
+		offerHelpers = append(offerHelpers, offerHelper)
+	}
+
+	// Place the nodes which still need resources across the whole batch of offers
+	sc.placeNodes(offerHelpers)
 
-		if !needsReconciliation {
-			offerHelper.MaybeUnreserve()
+	// Populate operations
+	for _, offerHelper := range offerHelpers {
+		if !needsReconciliation[offerHelper.OfferIDStr] {
+			sc.reservationGC.Collect(offerHelper, sc.schedulerState)
 		}
 
-		operations[*offer.Id.Value] = offerHelper.Operations()
+		operations[offerHelper.OfferIDStr] = offerHelper.Operations()
 	}
 
 	return operations
@@ -263,6 +369,18 @@ func (sc *SchedulerCore) acceptOffer(driver sched.SchedulerDriver, offer *mesos.
 	}
 }
 
+func (sc *SchedulerCore) declineOffer(driver sched.SchedulerDriver, offer *mesos.Offer, refuseSeconds float64) {
+	log.Infof("Declining OfferID: %+v, refusing resources from this agent for %v seconds", *offer.Id.Value, refuseSeconds)
+
+	status, err := driver.DeclineOffer(offer.Id, &mesos.Filters{RefuseSeconds: proto.Float64(refuseSeconds)})
+	if status != mesos.Status_DRIVER_RUNNING {
+		log.Fatal("Driver not running, while trying to decline offers")
+	}
+	if err != nil {
+		log.Error("Failed to decline offer: ", err)
+	}
+}
+
 func (sc *SchedulerCore) StatusUpdate(driver sched.SchedulerDriver, status *mesos.TaskStatus) {
 	sc.lock.Lock()
 	defer sc.lock.Unlock()
@@ -273,13 +391,15 @@ func (sc *SchedulerCore) StatusUpdate(driver sched.SchedulerDriver, status *meso
 	for _, cluster := range sc.schedulerState.Clusters {
 		if cluster.HasNode(status.TaskId.GetValue()) {
 			foundNode = true
-			cluster.HandleNodeStatusUpdate(status)
+			cluster.HandleNodeStatusUpdate(status, sc)
 			break
 		}
 	}
 
 	if foundNode {
 		sc.schedulerState.Persist()
+		sc.maybeReviveOffers()
+		sc.rServer.wake()
 	}
 
 	if !foundNode {
@@ -306,7 +426,14 @@ func (sc *SchedulerCore) OfferRescinded(driver sched.SchedulerDriver, offerID *m
 func (sc *SchedulerCore) FrameworkMessage(driver sched.SchedulerDriver, executorID *mesos.ExecutorID, slaveID *mesos.SlaveID, message string) {
 	sc.lock.Lock()
 	defer sc.lock.Unlock()
-	log.Info("Got unknown framework message %v")
+	reply, err := common.DeserializeFrameworkReply(message)
+	if err != nil || reply.RequestID == "" {
+		log.Infof("Got unknown framework message %v", message)
+		return
+	}
+	if !sc.nodeRequests.Reply(reply) {
+		log.Infof("Got reply to %s from %s, nothing is waiting for it any more: %v", reply.Command, reply.TaskID, message)
+	}
 }
 
 // TODO: Write handler
@@ -0,0 +1,496 @@
+package scheduler
+
+import (
+	"github.com/basho-labs/riak-mesos/common"
+	"github.com/basho-labs/riak-mesos/scheduler/process_state"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReserveAndLaunch(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	// Unreserved resources get reserved, along with a persistent volume
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal("offer-1", accepted[0].OfferIDs[0].GetValue())
+	assert.Equal([]mesos.Offer_Operation_Type{mesos.Offer_Operation_RESERVE, mesos.Offer_Operation_CREATE}, operationTypes(accepted[0].Operations))
+	assert.Equal(process_state.Reserved, node.CurrentState)
+	assert.Equal("slave-1", node.SlaveID.GetValue())
+
+	// The reservation comes back with the volume, and the node is launched on it
+	driver.reset()
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", reservedTestResources(accepted[0].Operations))})
+	accepted = driver.waitForAccepted(t, 1)
+	assert.Equal([]mesos.Offer_Operation_Type{mesos.Offer_Operation_LAUNCH}, operationTypes(accepted[0].Operations))
+	tasks := accepted[0].Operations[0].Launch.TaskInfos
+	assert.Equal(1, len(tasks))
+	assert.Equal(node.CurrentID(), tasks[0].TaskId.GetValue())
+	assert.Equal(process_state.Starting, node.CurrentState)
+	assert.Equal(1, node.Generation)
+	assert.NotEqual(0, node.TaskData.HTTPPort)
+	assert.Equal("riak-default-1@slave-1.example.com", node.TaskData.FullyQualifiedNodeName)
+}
+
+func TestCompatibilityModeLaunch(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal(0, len(accepted[0].Operations))
+	assert.Equal(1, len(accepted[0].Tasks))
+	assert.Equal(process_state.Starting, node.CurrentState)
+}
+
+func TestOfferTooSmall(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(0.5, 4096, 10000))})
+	declined := driver.waitForDeclined(t, 1)
+	assert.Equal("offer-1", declined[0].OfferIDs[0].GetValue())
+	assert.Equal(DEFAULT_REFUSE_SECONDS, declined[0].Filters.GetRefuseSeconds())
+	assert.Equal(0, len(driver.accepted))
+	assert.Equal(process_state.Unknown, node.CurrentState)
+	assert.False(sc.offersIdle)
+}
+
+func TestIdleDeclineAndReviveOffers(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+
+	// Nothing to schedule, so the offer is declined for longer
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	declined := driver.waitForDeclined(t, 1)
+	assert.Equal(DEFAULT_IDLE_REFUSE_SECONDS, declined[0].Filters.GetRefuseSeconds())
+	assert.True(sc.offersIdle)
+
+	// A new node needs resources
+	node := cluster.CreateNode(sc)
+	sc.reviveOffers()
+	driver.waitForRevived(t, 1)
+	assert.False(sc.offersIdle)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+
+	// Once everything is running, offers are declined for longer again...
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-3", "slave-2", unreservedTestResources(4, 4096, 10000))})
+	declined = driver.waitForDeclined(t, 2)
+	assert.Equal(DEFAULT_IDLE_REFUSE_SECONDS, declined[1].Filters.GetRefuseSeconds())
+	assert.True(sc.offersIdle)
+
+	// ...until a node fails and has to be rescheduled
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_FAILED))
+	driver.waitForRevived(t, 2)
+	assert.False(sc.offersIdle)
+}
+
+func TestStatusUpdates(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_STARTING))
+	assert.Equal(process_state.Starting, node.CurrentState)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+	assert.Equal(process_state.Started, node.CurrentState)
+	assert.False(node.NeedsToBeReconciled())
+
+	// A lost node is relaunched on the next offer
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_LOST))
+	assert.Equal(process_state.Failed, node.CurrentState)
+	assert.True(node.CanBeScheduled())
+
+	driver.reset()
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-2", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal(1, len(accepted[0].Tasks))
+	assert.Equal(2, node.Generation)
+	assert.Equal("slave-2", node.SlaveID.GetValue())
+}
+
+func TestRemoveNode(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+
+	node.KillNext()
+	sc.rServer.killTasks()
+	assert.Equal(1, len(driver.messages))
+	// Executors which predate versioned messages only understand the bare command
+	assert.Equal("finish", driver.messages[0].Data)
+	message, err := common.DeserializeFrameworkMessage(driver.messages[0].Data)
+	assert.Nil(err)
+	assert.Equal(common.COMMAND_FINISH, message.Command)
+	assert.Equal("", message.RequestID)
+	assert.Equal(node.ExecutorID(), driver.messages[0].ExecutorID.GetValue())
+
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_FINISHED))
+	assert.Equal(process_state.Shutdown, node.CurrentState)
+	sc.rServer.killTasks()
+	assert.Equal(0, len(cluster.Nodes))
+	assert.Equal(node, cluster.Graveyard[node.CurrentID()])
+}
+
+func TestStateSurvivesFailover(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, zkConn := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_RUNNING))
+
+	// A new scheduler reading the same Zookeeper sees the running node, but has to reconcile it first
+	zkConn.Close()
+	sc.mgr.CreateConnection()
+	ss := GetSchedulerState(sc.mgr)
+	restoredNode := ss.Clusters["default"].Nodes[node.CurrentID()]
+	assert.Equal(process_state.Started, restoredNode.CurrentState)
+	assert.Equal(node.TaskData, restoredNode.TaskData)
+	assert.True(restoredNode.NeedsToBeReconciled())
+	assert.False(restoredNode.CanBeScheduled())
+}
+
+func TestPortsReservedWithNode(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	accepted := driver.waitForAccepted(t, 1)
+	assert.Equal([]int64{31000, 31001, 31002, 31003, 31004, 31005, 31006, 31007, 31008, 31009}, node.AssignedPorts)
+	reservedPorts := []int64{}
+	for _, resource := range accepted[0].Operations[0].Reserve.Resources {
+		if resource.GetName() == "ports" {
+			assert.NotNil(resource.Reservation)
+			reservedPorts = append(reservedPorts, common.RangesToArray(resource.GetRanges().GetRange())...)
+		}
+	}
+	assert.Equal(node.AssignedPorts, reservedPorts)
+
+	// Every launch on the reservation uses the same ports
+	reserved := reservedTestResources(accepted[0].Operations)
+	for generation := 1; generation <= 2; generation++ {
+		driver.reset()
+		sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-launch", "slave-1", reserved)})
+		driver.waitForAccepted(t, 1)
+		assert.Equal(generation, node.Generation)
+		assert.Equal(int64(31000), node.TaskData.HTTPPort)
+		assert.Equal(int64(31001), node.TaskData.PBPort)
+		assert.Equal(int64(31002), node.TaskData.DisterlPort)
+		sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_FAILED))
+	}
+}
+
+func TestPortsPreferredAfterRelaunch(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	cluster.PortRange = &common.PortRange{Begin: 31050, End: 31099}
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(int64(31050), node.TaskData.HTTPPort)
+	ports := node.AssignedPorts
+
+	// The cluster range changes, but the node keeps its ports on another agent as long as they're offered
+	cluster.PortRange = &common.PortRange{Begin: 31000, End: 31049}
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_LOST))
+	driver.reset()
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-2", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(ports, node.AssignedPorts)
+	assert.Equal(int64(31050), node.TaskData.HTTPPort)
+}
+
+func TestNamedPorts(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	assert.Nil(cluster.SetPortNames([]string{"http", "metrics"}))
+	assert.Equal([]string{"metrics"}, cluster.PortNames)
+	assert.NotNil(cluster.SetPortNames([]string{"Bad-Name"}))
+	assert.NotNil(cluster.SetPortNames([]string{"a", "b", "c", "d", "e"}))
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := cluster.CreateNode(sc)
+
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(map[string]int64{
+		"http":     31000,
+		"pb":       31001,
+		"disterl":  31002,
+		"handoff":  31003,
+		"solr":     31004,
+		"solr_jmx": 31005,
+		"metrics":  31006,
+	}, node.TaskData.NamedPorts)
+	assert.Equal(int64(31003), node.TaskData.HandoffPort)
+
+	// Names keep their ports when others are added or removed
+	assert.Nil(cluster.SetPortNames([]string{"search_admin"}))
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_LOST))
+	driver.reset()
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-2", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(int64(31002), node.TaskData.NamedPorts["disterl"])
+	assert.Equal(int64(31006), node.TaskData.NamedPorts["search_admin"])
+	_, assigned := node.TaskData.NamedPorts["metrics"]
+	assert.False(assigned)
+}
+
+func TestHealthCheckPassedToExecutor(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := mux.NewRouter()
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/healthCheck").HandlerFunc(sc.schedulerHTTPServer.setHealthCheck)
+
+	request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/healthCheck", strings.NewReader(`{"StartTimeoutSeconds": 0}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+	assert.Nil(cluster.HealthCheck)
+
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/healthCheck", strings.NewReader(`{"StartTimeoutSeconds": 300, "FailureAction": "reboot"}`))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/healthCheck", strings.NewReader(`{"StartTimeoutSeconds": 300, "MaxFailures": 5, "FailureAction": "fail"}`))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(&common.HealthCheck{StartTimeoutSeconds: 300, MaxFailures: 5, FailureAction: common.HEALTH_CHECK_ACTION_FAIL}, node.TaskData.HealthCheck)
+}
+
+func TestSetClusterSetting(t *testing.T) {
+	assert := assert.New(t)
+	sc, _, _ := newTestSchedulerCore(false)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := mux.NewRouter()
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/portRange").HandlerFunc(sc.schedulerHTTPServer.setPortRange)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/portRange").HandlerFunc(sc.schedulerHTTPServer.getPortRange)
+
+	for body, code := range map[string]int{
+		`{"Begin": 32000, "End": 31000}`: 400,
+		`{"Begin": 0, "End": 31000}`:     400,
+		`{"Begin": 31000`:                400,
+		`{"Begin": 31000, "End": 32000}`: 200,
+	} {
+		request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/portRange", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(code, recorder.Code, body)
+	}
+	assert.Equal(&common.PortRange{Begin: 31000, End: 32000}, cluster.PortRange)
+
+	request, _ := http.NewRequest("GET", "/api/v1/clusters/default/portRange", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Equal("{\"Begin\":31000,\"End\":32000}\n", recorder.Body.String())
+
+	// An empty body resets the setting
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/portRange", strings.NewReader(""))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Nil(cluster.PortRange)
+
+	request, _ = http.NewRequest("GET", "/api/v1/clusters/missing/portRange", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(404, recorder.Code)
+}
+
+func TestLogShippingPassedToExecutor(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := mux.NewRouter()
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/logShipping").HandlerFunc(sc.schedulerHTTPServer.setLogShipping)
+
+	for body, code := range map[string]int{
+		`{"Destination": "kafka"}`:  400,
+		`{"Destination": "syslog"}`: 400,
+		`{"Destination": "syslog", "SyslogAddress": "logs.example.com:514"}`: 400,
+		`{"Destination": "stdout", "SyslogAddress": "udp://logs:514"}`:       400,
+		`{"Destination": "none"}`: 200,
+		`{"Destination": "syslog", "SyslogAddress": "udp://logs.example.com:514"}`: 200,
+	} {
+		request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/logShipping", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(code, recorder.Code, body)
+	}
+	request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/logShipping", strings.NewReader(`{"Destination": "syslog", "SyslogAddress": "tcp://logs.example.com:514"}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+
+	node := cluster.CreateNode(sc)
+	sc.ResourceOffers(driver, []*mesos.Offer{newTestOffer("offer-1", "slave-1", unreservedTestResources(4, 4096, 10000))})
+	driver.waitForAccepted(t, 1)
+	assert.Equal(&common.LogShipping{Destination: common.LOG_SHIPPING_SYSLOG, SyslogAddress: "tcp://logs.example.com:514"}, node.TaskData.LogShipping)
+
+	// Back to the default
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/logShipping", strings.NewReader(""))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+	assert.Nil(cluster.LogShipping)
+	assert.Nil(cluster.restartTaskData(node).LogShipping)
+}
+
+func TestStopSequencePassedToExecutor(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := mux.NewRouter()
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/stopSequence").HandlerFunc(sc.schedulerHTTPServer.setStopSequence)
+
+	request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/stopSequence", strings.NewReader(`{"SigtermTimeoutSeconds": -1}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(400, recorder.Code)
+	assert.Nil(cluster.StopSequence)
+
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/stopSequence", strings.NewReader(`{"StopCommandTimeoutSeconds": 600, "SigtermTimeoutSeconds": 60}`))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+
+	node := runningTestNode(t, sc, driver, cluster)
+	stopSequence := &common.StopSequence{StopCommandTimeoutSeconds: 600, SigtermTimeoutSeconds: 60}
+	assert.Equal(stopSequence, node.TaskData.StopSequence)
+
+	// Nodes restarted in place pick up changes too
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/stopSequence", strings.NewReader(`{"StopCommandTimeoutSeconds": 60}`))
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(&common.StopSequence{StopCommandTimeoutSeconds: 60}, cluster.restartTaskData(node).StopSequence)
+}
+
+func TestProcessSpecPassedToExecutor(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	router := mux.NewRouter()
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/processSpec").HandlerFunc(sc.schedulerHTTPServer.setProcessSpec)
+
+	for _, body := range []string{
+		`{"NoFileLimit": -2}`,
+		`{"Isolation": "jail"}`,
+		`{"User": "riak/../root"}`,
+		`{"OOMScoreAdj": 1001}`,
+		`{"CgroupCPUs": -1}`,
+	} {
+		request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/processSpec", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(400, recorder.Code, body)
+	}
+	assert.Nil(cluster.ProcessSpec)
+
+	request, _ := http.NewRequest("PUT", "/api/v1/clusters/default/processSpec", strings.NewReader(`{"NoFileLimit": 262144, "MemlockBytes": -1, "Isolation": "mount-namespace", "User": "riak", "OOMScoreAdj": -500}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(200, recorder.Code)
+
+	node := runningTestNode(t, sc, driver, cluster)
+	oomScoreAdj := -500
+	processSpec := &common.ProcessSpec{
+		NoFileLimit:  262144,
+		MemlockBytes: common.RLIMIT_UNLIMITED,
+		Isolation:    common.PROCESS_ISOLATION_MOUNT_NAMESPACE,
+		User:         "riak",
+		OOMScoreAdj:  &oomScoreAdj,
+	}
+	assert.Equal(processSpec, node.TaskData.ProcessSpec)
+
+	// Nodes restarted in place pick up changes too, and an empty body goes back to the executor's defaults
+	request, _ = http.NewRequest("PUT", "/api/v1/clusters/default/processSpec", strings.NewReader(""))
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Nil(cluster.restartTaskData(node).ProcessSpec)
+}
+
+func TestNodeHealth(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	node := runningTestNode(t, sc, driver, cluster)
+	assert.Nil(node.Health)
+	events, _ := sc.events.Subscribe(0)
+
+	status := newTestStatus(node, mesos.TaskState_TASK_RUNNING)
+	status.Healthy = proto.Bool(false)
+	status.Message = proto.String("riak_kv has no vnodes running yet")
+	node.Restart(cluster.Generation + 1)
+	sc.StatusUpdate(driver, status)
+	assert.False(node.Health.Healthy)
+	assert.Equal("riak_kv has no vnodes running yet", node.Health.Message)
+	// Still on its way to being restarted
+	assert.Equal(process_state.Restarting, node.DestinationState)
+
+	healthEvents := 0
+	for len(events) > 0 {
+		if event := <-events; event.Type == EVENT_NODE_HEALTH {
+			healthEvents = healthEvents + 1
+			assert.Equal(false, event.Data["Healthy"])
+		}
+	}
+	assert.Equal(1, healthEvents)
+
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes").HandlerFunc(sc.schedulerHTTPServer.serveNodes)
+	request, _ := http.NewRequest("GET", "/api/v1/clusters/default/nodes", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Contains(recorder.Body.String(), `"Healthy":false`)
+}
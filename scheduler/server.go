@@ -5,15 +5,20 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/basho-labs/riak-mesos/artifacts"
+	"github.com/basho-labs/riak-mesos/common"
 	rexclient "github.com/basho-labs/riak-mesos/riak_explorer"
 	"github.com/elazarl/go-bindata-assetfs"
 	"github.com/gorilla/mux"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"os"
+	"reflect"
 	"strconv"
+	"time"
 )
 
 type SchedulerHTTPServer struct {
@@ -48,6 +53,7 @@ func (schttp *SchedulerHTTPServer) createCluster(w http.ResponseWriter, r *http.
 		cluster := NewFrameworkRiakCluster(clusterName)
 		schttp.sc.schedulerState.Clusters[clusterName] = cluster
 		schttp.sc.schedulerState.Persist()
+		schttp.sc.reviveOffers()
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(cluster)
 	}
@@ -107,17 +113,515 @@ func (schttp *SchedulerHTTPServer) setConfig(w http.ResponseWriter, r *http.Requ
 		fmt.Fprintln(w, "Unable to read file: ", err)
 		return
 	}
-	cluster.RiakConfig = string(data)
+	if configErrors := ValidateRiakConfig(cluster, string(data)); len(configErrors) > 0 {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, configErrors)
+		return
+	}
+	apply, ok := checkConfigApply(w, r, cluster)
+	if !ok {
+		return
+	}
+	snapshot := cluster.snapshotConfig()
+	revision := cluster.reviseConfig(string(data), cluster.AdvancedConfig, schttp.sc.auditLog.Actor(r), r.URL.Query().Get("message"))
+	if apply == CONFIG_APPLY_ROLLING {
+		cluster.ApplyConfigRevision(revision.Revision)
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		cluster.restoreConfig(snapshot)
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.events.Publish(EVENT_CONFIG_CHANGED, clusterName, "", map[string]interface{}{"Setting": "config", "Revision": revision.Revision, "Apply": apply})
+	if apply == CONFIG_APPLY_ROLLING {
+		w.WriteHeader(202)
+	} else {
+		w.WriteHeader(200)
+	}
+	fmt.Fprintf(w, "Success!")
+}
+func (schttp *SchedulerHTTPServer) getConfig(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+
+	} else if revision, err := requestedConfigRevision(cluster, r, cluster.ConfigRevision); err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+	} else {
+		w.WriteHeader(200)
+		fmt.Fprint(w, revision.RiakConfig)
+	}
+}
+
+func (schttp *SchedulerHTTPServer) setAdvancedConfig(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to read file: ", err)
+		return
+	}
+	if configErrors := ValidateAdvancedConfig(cluster, string(data)); len(configErrors) > 0 {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, configErrors)
+		return
+	}
+	apply, ok := checkConfigApply(w, r, cluster)
+	if !ok {
+		return
+	}
+	snapshot := cluster.snapshotConfig()
+	revision := cluster.reviseConfig(cluster.RiakConfig, string(data), schttp.sc.auditLog.Actor(r), r.URL.Query().Get("message"))
+	if apply == CONFIG_APPLY_ROLLING {
+		cluster.ApplyConfigRevision(revision.Revision)
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		cluster.restoreConfig(snapshot)
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.events.Publish(EVENT_CONFIG_CHANGED, clusterName, "", map[string]interface{}{"Setting": "advancedConfig", "Revision": revision.Revision, "Apply": apply})
+	if apply == CONFIG_APPLY_ROLLING {
+		w.WriteHeader(202)
+	} else {
+		w.WriteHeader(200)
+	}
+	fmt.Fprint(w, "Success!")
+}
+
+func (schttp *SchedulerHTTPServer) getAdvancedConfig(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	revision, err := requestedConfigRevision(cluster, r, cluster.ConfigRevision)
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, revision.AdvancedConfig)
+}
+
+// checkConfigApply reads ?apply=, writing the error response if the config can't be applied that way
+func checkConfigApply(w http.ResponseWriter, r *http.Request, cluster *FrameworkRiakCluster) (string, bool) {
+	apply := r.URL.Query().Get("apply")
+	switch apply {
+	case "":
+		return apply, true
+	case CONFIG_APPLY_ROLLING:
+		if cluster.IsRestarting {
+			w.WriteHeader(409)
+			fmt.Fprintf(w, "Cluster %s is already restarting", cluster.Name)
+			return apply, false
+		}
+		return apply, true
+	}
+	w.WriteHeader(400)
+	fmt.Fprintf(w, "Unknown apply option %q, only %q is supported", apply, CONFIG_APPLY_ROLLING)
+	return apply, false
+}
+
+// serveConfigSettings returns the settings of the cluster, or of the node if the route has one
+func (schttp *SchedulerHTTPServer) serveConfigSettings(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	nodeID := vars["node"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	if _, assigned := cluster.Nodes[nodeID]; nodeID != "" && !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Node %s not found", nodeID)
+		return
+	}
+	revision := cluster.CurrentConfigRevision()
+	settings := &ConfigSettings{Revision: revision.Revision, Settings: copySettings(revision.Settings)}
+	if nodeID != "" {
+		settings.NodeSettings = copySettings(revision.NodeSettings[nodeID])
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(settings)
+}
+
+// patchConfigSettings merges a JSON object of riak.conf settings into those of the cluster, or of the node if the
+// route has one. A null value removes the setting.
+func (schttp *SchedulerHTTPServer) patchConfigSettings(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	nodeID := vars["node"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	if _, assigned := cluster.Nodes[nodeID]; nodeID != "" && !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Node %s not found", nodeID)
+		return
+	}
+	patch := make(map[string]*string)
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, "Unable to parse settings: ", err)
+		return
+	}
+	apply, ok := checkConfigApply(w, r, cluster)
+	if !ok {
+		return
+	}
+	snapshot := cluster.snapshotConfig()
+	revision, configErrors := cluster.PatchConfigSettings(nodeID, patch, schttp.sc.auditLog.Actor(r))
+	if len(configErrors) > 0 {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, configErrors)
+		return
+	}
+	if apply == CONFIG_APPLY_ROLLING {
+		cluster.ApplyConfigRevision(revision.Revision)
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		cluster.restoreConfig(snapshot)
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.events.Publish(EVENT_CONFIG_CHANGED, clusterName, nodeID, map[string]interface{}{"Setting": "settings", "Revision": revision.Revision, "Apply": apply})
+	settings := &ConfigSettings{Revision: revision.Revision, Settings: copySettings(revision.Settings)}
+	if nodeID != "" {
+		settings.NodeSettings = copySettings(revision.NodeSettings[nodeID])
+	}
+	if apply == CONFIG_APPLY_ROLLING {
+		w.WriteHeader(202)
+	} else {
+		w.WriteHeader(200)
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+// serveConfigVariables returns the variables the cluster's config templates are rendered with
+func (schttp *SchedulerHTTPServer) serveConfigVariables(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	revision := cluster.CurrentConfigRevision()
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(&ConfigVariables{Revision: revision.Revision, Variables: copySettings(revision.Variables)})
+}
+
+// setConfigVariables replaces the cluster's template variables with a JSON object of strings
+func (schttp *SchedulerHTTPServer) setConfigVariables(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	variables := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&variables); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, "Unable to parse variables: ", err)
+		return
+	}
+	apply, ok := checkConfigApply(w, r, cluster)
+	if !ok {
+		return
+	}
+	snapshot := cluster.snapshotConfig()
+	revision, configErrors := cluster.SetConfigVariables(variables, schttp.sc.auditLog.Actor(r))
+	if len(configErrors) > 0 {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, configErrors)
+		return
+	}
+	if apply == CONFIG_APPLY_ROLLING {
+		cluster.ApplyConfigRevision(revision.Revision)
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		cluster.restoreConfig(snapshot)
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.events.Publish(EVENT_CONFIG_CHANGED, clusterName, "", map[string]interface{}{"Setting": "variables", "Revision": revision.Revision, "Apply": apply})
+	if apply == CONFIG_APPLY_ROLLING {
+		w.WriteHeader(202)
+	} else {
+		w.WriteHeader(200)
+	}
+	json.NewEncoder(w).Encode(&ConfigVariables{Revision: revision.Revision, Variables: copySettings(revision.Variables)})
+}
+
+// serveNodeConfig returns the riak.conf the node runs with, or will be started with if it hasn't been yet. Its
+// executor asks for the revision it was launched with.
+func (schttp *SchedulerHTTPServer) serveNodeConfig(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	nodeID := vars["node"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	node, assigned := cluster.Nodes[nodeID]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Node %s not found", nodeID)
+		return
+	}
+	defaultRevision := node.ConfigRevision
+	if defaultRevision == 0 {
+		defaultRevision = cluster.ConfigRevision
+	}
+	revision, err := requestedConfigRevision(cluster, r, defaultRevision)
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, revision.EffectiveRiakConfig(nodeID))
+}
+
+// requestedConfigRevision is the ?revision= asked for, or the default revision
+func requestedConfigRevision(cluster *FrameworkRiakCluster, r *http.Request, defaultRevision int64) (*ConfigRevision, error) {
+	revisionNumber := defaultRevision
+	if revisionParam := r.URL.Query().Get("revision"); revisionParam != "" {
+		parsed, err := strconv.ParseInt(revisionParam, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid config revision: %s", revisionParam)
+		}
+		revisionNumber = parsed
+	}
+	revision := cluster.GetConfigRevision(revisionNumber)
+	if revision == nil {
+		return nil, fmt.Errorf("Config revision %d not found for cluster %s", revisionNumber, cluster.Name)
+	}
+	return revision, nil
+}
+
+func (schttp *SchedulerHTTPServer) serveConfigRevisions(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(cluster.ConfigRevisionSummaries())
+}
+
+func (schttp *SchedulerHTTPServer) serveConfigRevision(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	revisionNumber, err := strconv.ParseInt(vars["revision"], 10, 64)
+	revision := cluster.GetConfigRevision(revisionNumber)
+	if err != nil || revision == nil {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Config revision %s not found for cluster %s", vars["revision"], clusterName)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(revision)
+}
+
+// serveConfigDiff diffs ?from= against ?to=, which defaults to the current revision
+func (schttp *SchedulerHTTPServer) serveConfigDiff(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, "Invalid from revision: ", err)
+		return
+	}
+	to := cluster.ConfigRevision
+	if toParam := r.URL.Query().Get("to"); toParam != "" {
+		to, err = strconv.ParseInt(toParam, 10, 64)
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintln(w, "Invalid to revision: ", err)
+			return
+		}
+	}
+	diff, err := cluster.DiffConfigRevisions(from, to)
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(diff)
+}
+
+func (schttp *SchedulerHTTPServer) rollbackConfig(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	revisionNumber, err := strconv.ParseInt(vars["revision"], 10, 64)
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Config revision %s not found for cluster %s", vars["revision"], clusterName)
+		return
+	}
+	apply, ok := checkConfigApply(w, r, cluster)
+	if !ok {
+		return
+	}
+	snapshot := cluster.snapshotConfig()
+	revision, err := cluster.RollbackConfig(revisionNumber, schttp.sc.auditLog.Actor(r))
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if apply == CONFIG_APPLY_ROLLING {
+		cluster.ApplyConfigRevision(revision.Revision)
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		cluster.restoreConfig(snapshot)
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.events.Publish(EVENT_CONFIG_CHANGED, clusterName, "", map[string]interface{}{"Setting": "config", "Revision": revision.Revision, "Apply": apply})
+	if apply == CONFIG_APPLY_ROLLING {
+		w.WriteHeader(202)
+	} else {
+		w.WriteHeader(200)
+	}
+	json.NewEncoder(w).Encode(revision)
+}
+
+// settingValidator is a cluster setting which can check itself before it's set
+type settingValidator interface {
+	Validate() error
+}
+
+// setClusterSetting decodes a cluster setting from the request into target, a pointer to the setting, and has
+// assign set it on the cluster, once it's valid. A setting held by pointer is reset by an empty body. The cluster is
+// then persisted, and the setting, as get has it, is returned.
+func (schttp *SchedulerHTTPServer) setClusterSetting(w http.ResponseWriter, r *http.Request, setting string, target interface{}, assign func(cluster *FrameworkRiakCluster) error, get func(cluster *FrameworkRiakCluster) interface{}) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(target); err != nil && err != io.EOF {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Unable to parse %s: %v\n", setting, err)
+		return
+	}
+	if value := reflect.ValueOf(target).Elem(); value.Kind() != reflect.Ptr || !value.IsNil() {
+		if validator, ok := value.Interface().(settingValidator); ok {
+			if err := validator.Validate(); err != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, err)
+				return
+			}
+		}
+	}
+	if err := assign(cluster); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, err)
+		return
+	}
 	if err := schttp.sc.schedulerState.Persist(); err != nil {
 		w.WriteHeader(503)
-		fmt.Fprintln(w, "Unable persist cluster data: ", err)
-		log.Error("Unable persist cluster data: ", err)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
 		return
 	}
+	schttp.sc.events.Publish(EVENT_CONFIG_CHANGED, clusterName, "", map[string]interface{}{"Setting": setting})
 	w.WriteHeader(200)
-	fmt.Fprintf(w, "Success!")
+	json.NewEncoder(w).Encode(get(cluster))
 }
-func (schttp *SchedulerHTTPServer) getConfig(w http.ResponseWriter, r *http.Request) {
+
+// getClusterSetting returns a cluster setting, as get has it
+func (schttp *SchedulerHTTPServer) getClusterSetting(w http.ResponseWriter, r *http.Request, get func(cluster *FrameworkRiakCluster) interface{}) {
 	schttp.sc.lock.Lock()
 	defer schttp.sc.lock.Unlock()
 	vars := mux.Vars(r)
@@ -126,14 +630,155 @@ func (schttp *SchedulerHTTPServer) getConfig(w http.ResponseWriter, r *http.Requ
 	if !assigned {
 		w.WriteHeader(404)
 		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(get(cluster))
+}
 
-	} else {
-		w.WriteHeader(200)
-		fmt.Fprint(w, cluster.RiakConfig)
+func clusterPortRange(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.PortRange
+}
+
+func (schttp *SchedulerHTTPServer) setPortRange(w http.ResponseWriter, r *http.Request) {
+	var portRange *common.PortRange
+	schttp.setClusterSetting(w, r, "portRange", &portRange, func(cluster *FrameworkRiakCluster) error {
+		cluster.PortRange = portRange
+		return nil
+	}, clusterPortRange)
+}
+
+func (schttp *SchedulerHTTPServer) getPortRange(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterPortRange)
+}
+
+func clusterPortNames(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.AllPortNames()
+}
+
+func (schttp *SchedulerHTTPServer) setPortNames(w http.ResponseWriter, r *http.Request) {
+	portNames := []string{}
+	schttp.setClusterSetting(w, r, "portNames", &portNames, func(cluster *FrameworkRiakCluster) error {
+		return cluster.SetPortNames(portNames)
+	}, clusterPortNames)
+}
+
+func (schttp *SchedulerHTTPServer) getPortNames(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterPortNames)
+}
+
+func clusterVolumeRetention(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.VolumeRetention
+}
+
+func (schttp *SchedulerHTTPServer) setVolumeRetention(w http.ResponseWriter, r *http.Request) {
+	var retention *VolumeRetention
+	schttp.setClusterSetting(w, r, "volumeRetention", &retention, func(cluster *FrameworkRiakCluster) error {
+		cluster.VolumeRetention = retention
+		return nil
+	}, clusterVolumeRetention)
+}
+
+func (schttp *SchedulerHTTPServer) getVolumeRetention(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterVolumeRetention)
+}
+
+func clusterHealthCheck(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.HealthCheck
+}
+
+func (schttp *SchedulerHTTPServer) setHealthCheck(w http.ResponseWriter, r *http.Request) {
+	var healthCheck *common.HealthCheck
+	schttp.setClusterSetting(w, r, "healthCheck", &healthCheck, func(cluster *FrameworkRiakCluster) error {
+		cluster.HealthCheck = healthCheck
+		return nil
+	}, clusterHealthCheck)
+}
+
+func (schttp *SchedulerHTTPServer) getHealthCheck(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterHealthCheck)
+}
+
+func clusterLogShipping(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.LogShipping
+}
+
+func (schttp *SchedulerHTTPServer) setLogShipping(w http.ResponseWriter, r *http.Request) {
+	var logShipping *common.LogShipping
+	schttp.setClusterSetting(w, r, "logShipping", &logShipping, func(cluster *FrameworkRiakCluster) error {
+		cluster.LogShipping = logShipping
+		return nil
+	}, clusterLogShipping)
+}
+
+func (schttp *SchedulerHTTPServer) getLogShipping(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterLogShipping)
+}
+
+func clusterStopSequence(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.StopSequence
+}
+
+func (schttp *SchedulerHTTPServer) setStopSequence(w http.ResponseWriter, r *http.Request) {
+	var stopSequence *common.StopSequence
+	schttp.setClusterSetting(w, r, "stopSequence", &stopSequence, func(cluster *FrameworkRiakCluster) error {
+		cluster.StopSequence = stopSequence
+		return nil
+	}, clusterStopSequence)
+}
+
+func (schttp *SchedulerHTTPServer) getStopSequence(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterStopSequence)
+}
+
+func clusterProcessSpec(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.ProcessSpec
+}
+
+func (schttp *SchedulerHTTPServer) setProcessSpec(w http.ResponseWriter, r *http.Request) {
+	var processSpec *common.ProcessSpec
+	schttp.setClusterSetting(w, r, "processSpec", &processSpec, func(cluster *FrameworkRiakCluster) error {
+		cluster.ProcessSpec = processSpec
+		return nil
+	}, clusterProcessSpec)
+}
+
+func (schttp *SchedulerHTTPServer) getProcessSpec(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterProcessSpec)
+}
+
+func clusterRequireMountDisk(cluster *FrameworkRiakCluster) interface{} {
+	return cluster.RequireMountDisk
+}
+
+func (schttp *SchedulerHTTPServer) setRequireMountDisk(w http.ResponseWriter, r *http.Request) {
+	var requireMountDisk bool
+	schttp.setClusterSetting(w, r, "requireMountDisk", &requireMountDisk, func(cluster *FrameworkRiakCluster) error {
+		cluster.RequireMountDisk = requireMountDisk
+		return nil
+	}, clusterRequireMountDisk)
+}
+
+func (schttp *SchedulerHTTPServer) getRequireMountDisk(w http.ResponseWriter, r *http.Request) {
+	schttp.getClusterSetting(w, r, clusterRequireMountDisk)
+}
+
+func (schttp *SchedulerHTTPServer) serveVolumes(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
 	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(cluster.RetainedVolumes(schttp.sc.reservationGC.gracePeriod))
 }
 
-func (schttp *SchedulerHTTPServer) setAdvancedConfig(w http.ResponseWriter, r *http.Request) {
+func (schttp *SchedulerHTTPServer) purgeVolume(w http.ResponseWriter, r *http.Request) {
 	schttp.sc.lock.Lock()
 	defer schttp.sc.lock.Unlock()
 	vars := mux.Vars(r)
@@ -144,36 +789,117 @@ func (schttp *SchedulerHTTPServer) setAdvancedConfig(w http.ResponseWriter, r *h
 		fmt.Fprintf(w, "Cluster %s not found", clusterName)
 		return
 	}
-	data, err := ioutil.ReadAll(r.Body)
+	if err := cluster.PurgeVolume(vars["volume"]); err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.reviveOffers()
+	w.WriteHeader(202)
+}
+
+func (schttp *SchedulerHTTPServer) reattachVolume(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	node, err := cluster.ReattachVolume(schttp.sc, vars["volume"])
 	if err != nil {
-		w.WriteHeader(503)
-		fmt.Fprintln(w, "Unable to read file: ", err)
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
+	schttp.sc.reviveOffers()
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(node)
+}
+
+func (schttp *SchedulerHTTPServer) createWebhook(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	if _, assigned := schttp.sc.schedulerState.Clusters[clusterName]; !assigned {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return
+	}
+	webhook := &Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(webhook); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, "Unable to parse webhook: ", err)
+		return
+	}
+	if err := webhook.Validate(); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, err)
 		return
 	}
-	cluster.AdvancedConfig = string(data)
+	webhook.ClusterName = clusterName
+	schttp.sc.schedulerState.addWebhook(webhook)
 	if err := schttp.sc.schedulerState.Persist(); err != nil {
 		w.WriteHeader(503)
-		fmt.Fprintln(w, "Unable persist cluster data: ", err)
-		log.Error("Unable persist cluster data: ", err)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
 		return
 	}
 	w.WriteHeader(200)
-	fmt.Fprint(w, "Success!")
+	json.NewEncoder(w).Encode(webhook.redacted())
 }
 
-func (schttp *SchedulerHTTPServer) getAdvancedConfig(w http.ResponseWriter, r *http.Request) {
+func (schttp *SchedulerHTTPServer) serveWebhooks(w http.ResponseWriter, r *http.Request) {
 	schttp.sc.lock.Lock()
 	defer schttp.sc.lock.Unlock()
 	vars := mux.Vars(r)
 	clusterName := vars["cluster"]
-	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
-	if !assigned {
+	if _, assigned := schttp.sc.schedulerState.Clusters[clusterName]; !assigned {
 		w.WriteHeader(404)
 		fmt.Fprintf(w, "Cluster %s not found", clusterName)
 		return
 	}
+	webhooks := []*Webhook{}
+	for _, webhook := range schttp.sc.schedulerState.getWebhooks(clusterName) {
+		webhooks = append(webhooks, webhook.redacted())
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (schttp *SchedulerHTTPServer) removeWebhook(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	if err := schttp.sc.schedulerState.removeWebhook(clusterName, vars["webhook"]); err != nil {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if err := schttp.sc.schedulerState.Persist(); err != nil {
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to persist cluster data: ", err)
+		log.Error("Unable to persist cluster data: ", err)
+		return
+	}
 	w.WriteHeader(200)
-	fmt.Fprint(w, cluster.AdvancedConfig)
 }
 
 func (schttp *SchedulerHTTPServer) serveClusters(w http.ResponseWriter, r *http.Request) {
@@ -232,6 +958,7 @@ func (schttp *SchedulerHTTPServer) createNode(w http.ResponseWriter, r *http.Req
 	} else {
 		node := cluster.CreateNode(schttp.sc)
 		schttp.sc.schedulerState.Persist()
+		schttp.sc.reviveOffers()
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(node)
 	}
@@ -312,6 +1039,215 @@ func (schttp *SchedulerHTTPServer) nodeStatus(w http.ResponseWriter, r *http.Req
 	w.WriteHeader(200)
 	fmt.Fprintf(w, body)
 }
+
+// nodeRequest sends the message made for the node to its executor and waits for the reply, without holding the
+// scheduler's lock meanwhile. Commands which run a program get their TimeoutSeconds on top of NODE_REQUEST_TIMEOUT,
+// so it has to be set rather than left to the executor's default. If there's no reply to use, the response is
+// written and ok is false.
+func (schttp *SchedulerHTTPServer) nodeRequest(w http.ResponseWriter, r *http.Request, makeMessage func(*FrameworkRiakCluster, *FrameworkRiakNode) common.FrameworkMessage) (reply common.FrameworkReply, ok bool) {
+	schttp.sc.lock.Lock()
+	vars := mux.Vars(r)
+	clusterName := vars["cluster"]
+	nodeName := vars["node"]
+	cluster, assigned := schttp.sc.schedulerState.Clusters[clusterName]
+	if !assigned {
+		schttp.sc.lock.Unlock()
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Cluster %s not found", clusterName)
+		return reply, false
+	}
+	node, assigned := cluster.Nodes[nodeName]
+	if !assigned {
+		schttp.sc.lock.Unlock()
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Node %s not found", nodeName)
+		return reply, false
+	}
+	if !node.IsRunning() {
+		schttp.sc.lock.Unlock()
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Node %s is not running", nodeName)
+		return reply, false
+	}
+	message := makeMessage(cluster, node)
+	request, err := schttp.sc.nodeRequests.Send(schttp.sc.driver, node, message)
+	schttp.sc.lock.Unlock()
+	if err != nil {
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to send framework message: ", err)
+		return reply, false
+	}
+
+	reply, err = request.Wait(NODE_REQUEST_TIMEOUT + time.Duration(message.TimeoutSeconds)*time.Second)
+	if err == errNodeRequestTimeout {
+		w.WriteHeader(504)
+		fmt.Fprintln(w, err)
+		return reply, false
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, err)
+		return reply, false
+	}
+	return reply, true
+}
+
+// writeNodeReply passes the result of the executor's reply on as the response
+func (schttp *SchedulerHTTPServer) writeNodeReply(w http.ResponseWriter, r *http.Request, message common.FrameworkMessage) {
+	reply, ok := schttp.nodeRequest(w, r, func(*FrameworkRiakCluster, *FrameworkRiakNode) common.FrameworkMessage {
+		return message
+	})
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(reply.Result)
+}
+
+func (schttp *SchedulerHTTPServer) nodeDiskUsage(w http.ResponseWriter, r *http.Request) {
+	schttp.writeNodeReply(w, r, common.FrameworkMessage{Command: common.COMMAND_REPORT_DISK_USAGE})
+}
+
+func (schttp *SchedulerHTTPServer) nodeDiagnostics(w http.ResponseWriter, r *http.Request) {
+	schttp.writeNodeReply(w, r, common.FrameworkMessage{Command: common.COMMAND_COLLECT_DIAGNOSTICS})
+}
+
+// RiakAdminRequest is the riak-admin command line to run on a node, without riak-admin itself
+type RiakAdminRequest struct {
+	Args           []string
+	TimeoutSeconds int64
+}
+
+// nodeAdmin runs an allowed riak-admin subcommand on the node, and responds with its output and exit status
+func (schttp *SchedulerHTTPServer) nodeAdmin(w http.ResponseWriter, r *http.Request) {
+	adminRequest := &RiakAdminRequest{}
+	if err := json.NewDecoder(r.Body).Decode(adminRequest); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if err := common.ValidateRiakAdminArgs(adminRequest.Args); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if adminRequest.TimeoutSeconds < 0 || adminRequest.TimeoutSeconds > common.MAX_RIAK_COMMAND_TIMEOUT_SECONDS {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "TimeoutSeconds must be between 0 and %d\n", common.MAX_RIAK_COMMAND_TIMEOUT_SECONDS)
+		return
+	}
+	// The executor is told the timeout, so the request waits for as long as riak-admin may run
+	if adminRequest.TimeoutSeconds == 0 {
+		adminRequest.TimeoutSeconds = common.DEFAULT_RIAK_ADMIN_TIMEOUT_SECONDS
+	}
+	schttp.writeNodeReply(w, r, common.FrameworkMessage{
+		Command:        common.COMMAND_RUN_RIAK_ADMIN,
+		Args:           adminRequest.Args,
+		TimeoutSeconds: adminRequest.TimeoutSeconds,
+	})
+}
+
+// nodeDebug has riak-debug collect a bundle on the node, then downloads it from the node's agent. ?timeout= is how
+// many seconds riak-debug gets.
+func (schttp *SchedulerHTTPServer) nodeDebug(w http.ResponseWriter, r *http.Request) {
+	timeoutSeconds := int64(0)
+	if timeout := r.URL.Query().Get("timeout"); timeout != "" {
+		var err error
+		timeoutSeconds, err = strconv.ParseInt(timeout, 10, 64)
+		if err != nil || timeoutSeconds < 0 || timeoutSeconds > common.MAX_RIAK_COMMAND_TIMEOUT_SECONDS {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "timeout must be between 0 and %d\n", common.MAX_RIAK_COMMAND_TIMEOUT_SECONDS)
+			return
+		}
+	}
+	// As for riak-admin, the executor is told the timeout so the request waits for as long as riak-debug may run
+	if timeoutSeconds == 0 {
+		timeoutSeconds = common.DEFAULT_RIAK_DEBUG_TIMEOUT_SECONDS
+	}
+	var agentURL string
+	reply, ok := schttp.nodeRequest(w, r, func(_ *FrameworkRiakCluster, node *FrameworkRiakNode) common.FrameworkMessage {
+		agentURL = node.AgentURL
+		return common.FrameworkMessage{Command: common.COMMAND_RUN_RIAK_DEBUG, TimeoutSeconds: timeoutSeconds}
+	})
+	if !ok {
+		return
+	}
+	bundle := &common.DebugBundle{}
+	if err := json.Unmarshal(reply.Result, bundle); err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Unable to parse reply: ", err)
+		return
+	}
+
+	resp, err := http.Get(agentURL + "/files/download.json?path=" + url.QueryEscape(bundle.Path))
+	if err != nil {
+		w.WriteHeader(503)
+		fmt.Fprintln(w, "Unable to download riak-debug bundle: ", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		w.WriteHeader(503)
+		fmt.Fprintf(w, "Unable to download riak-debug bundle, agent returned %d\n", resp.StatusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-riak-debug.tar.gz", mux.Vars(r)["node"]))
+	w.WriteHeader(200)
+	io.Copy(w, resp.Body)
+}
+
+// nodeLog responds with the last lines of one of the node's logs, ?tail= says how many
+func (schttp *SchedulerHTTPServer) nodeLog(w http.ResponseWriter, r *http.Request) {
+	file := mux.Vars(r)["file"]
+	if !common.IsRiakLogFile(file) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Log %s not found", file)
+		return
+	}
+	lines := common.DEFAULT_LOG_TAIL_LINES
+	if tail := r.URL.Query().Get("tail"); tail != "" {
+		var err error
+		lines, err = strconv.Atoi(tail)
+		if err != nil || lines <= 0 || lines > common.MAX_LOG_TAIL_LINES {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "tail must be between 1 and %d\n", common.MAX_LOG_TAIL_LINES)
+			return
+		}
+	}
+	reply, ok := schttp.nodeRequest(w, r, func(*FrameworkRiakCluster, *FrameworkRiakNode) common.FrameworkMessage {
+		return common.FrameworkMessage{Command: common.COMMAND_TAIL_LOG, Args: []string{file}, Lines: lines}
+	})
+	if !ok {
+		return
+	}
+	logTail := &common.LogTail{}
+	if err := json.Unmarshal(reply.Result, logTail); err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Unable to parse reply: ", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	for _, line := range logTail.Lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// reloadNodeConfig has the node's executor render its configs with the cluster's current config revision. Riak only
+// reads them when it's restarted.
+func (schttp *SchedulerHTTPServer) reloadNodeConfig(w http.ResponseWriter, r *http.Request) {
+	_, ok := schttp.nodeRequest(w, r, func(cluster *FrameworkRiakCluster, node *FrameworkRiakNode) common.FrameworkMessage {
+		taskData := cluster.restartTaskData(node)
+		return common.FrameworkMessage{Command: common.COMMAND_RELOAD_CONFIG, TaskData: &taskData}
+	})
+	if !ok {
+		return
+	}
+	w.WriteHeader(200)
+}
+
 func (schttp *SchedulerHTTPServer) nodeRingready(w http.ResponseWriter, r *http.Request) {
 	schttp.sc.lock.Lock()
 	defer schttp.sc.lock.Unlock()
@@ -453,6 +1389,93 @@ func (schttp *SchedulerHTTPServer) GetURI() string {
 	return schttp.URI
 }
 
+func (schttp *SchedulerHTTPServer) serveReservations(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(schttp.sc.reservationGC.Report(schttp.sc.schedulerState))
+}
+
+func (schttp *SchedulerHTTPServer) serveReconciliation(w http.ResponseWriter, r *http.Request) {
+	schttp.sc.lock.Lock()
+	defer schttp.sc.lock.Unlock()
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(schttp.sc.rServer.Status())
+}
+
+// serveAudit returns the audit log, oldest first, optionally filtered by ?cluster=, ?actor=, ?since= (RFC 3339),
+// ?before= (an entry ID, to page back) and ?limit=
+func (schttp *SchedulerHTTPServer) serveAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(schttp.sc.auditLog.Query(filter))
+}
+
+// serveEvents streams scheduler events as Server-Sent Events until the client goes away. Clients reconnecting
+// with a Last-Event-ID are sent the events they missed first, as far as the history goes back.
+func (schttp *SchedulerHTTPServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Streaming is not supported")
+		return
+	}
+	lastEventID := int64(0)
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintln(w, "Invalid Last-Event-ID: ", err)
+			return
+		}
+		lastEventID = id
+	}
+
+	events, missed := schttp.sc.events.Subscribe(lastEventID)
+	defer schttp.sc.events.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	for _, event := range missed {
+		if err := event.writeServerSentEvent(w); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var closed <-chan bool
+	if closeNotifier, ok := w.(http.CloseNotifier); ok {
+		closed = closeNotifier.CloseNotify()
+	}
+	keepalive := time.NewTicker(EVENT_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			if err := event.writeServerSentEvent(w); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
 func (schttp *SchedulerHTTPServer) healthcheck(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Fprintln(w, "Scheduler: OK")
@@ -533,6 +1556,12 @@ func ServeExecutorArtifact(sc *SchedulerCore, schedulerHostname string) *Schedul
 	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/status").HandlerFunc(schttp.nodeStatus)
 	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/ringready").HandlerFunc(schttp.nodeRingready)
 	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/transfers").HandlerFunc(schttp.nodeTransfers)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/diskUsage").HandlerFunc(schttp.nodeDiskUsage)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/diagnostics").HandlerFunc(schttp.nodeDiagnostics)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/nodes/{node}/config/reload").HandlerFunc(schttp.reloadNodeConfig)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/nodes/{node}/admin").HandlerFunc(schttp.nodeAdmin)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/debug").HandlerFunc(schttp.nodeDebug)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/logs/{file}").HandlerFunc(schttp.nodeLog)
 	router.Methods("POST").Path("/api/v1/clusters/{cluster}/nodes/{node}/types/{buckettype}").HandlerFunc(schttp.nodeCreateType)
 	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/types").HandlerFunc(schttp.nodeTypes)
 
@@ -540,16 +1569,55 @@ func ServeExecutorArtifact(sc *SchedulerCore, schedulerHostname string) *Schedul
 	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config").HandlerFunc(schttp.getConfig)
 	router.Methods("POST").Path("/api/v1/clusters/{cluster}/advancedConfig").HandlerFunc(schttp.setAdvancedConfig)
 	router.Methods("GET").Path("/api/v1/clusters/{cluster}/advancedConfig").HandlerFunc(schttp.getAdvancedConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/revisions").HandlerFunc(schttp.serveConfigRevisions)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/revisions/{revision}").HandlerFunc(schttp.serveConfigRevision)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/config/revisions/{revision}/rollback").HandlerFunc(schttp.rollbackConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/diff").HandlerFunc(schttp.serveConfigDiff)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/settings").HandlerFunc(schttp.serveConfigSettings)
+	router.Methods("PATCH").Path("/api/v1/clusters/{cluster}/config/settings").HandlerFunc(schttp.patchConfigSettings)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/config/variables").HandlerFunc(schttp.serveConfigVariables)
+	router.Methods("PUT").Path("/api/v1/clusters/{cluster}/config/variables").HandlerFunc(schttp.setConfigVariables)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/config").HandlerFunc(schttp.serveNodeConfig)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/nodes/{node}/config/settings").HandlerFunc(schttp.serveConfigSettings)
+	router.Methods("PATCH").Path("/api/v1/clusters/{cluster}/nodes/{node}/config/settings").HandlerFunc(schttp.patchConfigSettings)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/portRange").HandlerFunc(schttp.setPortRange)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/portRange").HandlerFunc(schttp.getPortRange)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/portNames").HandlerFunc(schttp.setPortNames)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/portNames").HandlerFunc(schttp.getPortNames)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/volumeRetention").HandlerFunc(schttp.setVolumeRetention)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/volumeRetention").HandlerFunc(schttp.getVolumeRetention)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/healthCheck").HandlerFunc(schttp.setHealthCheck)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/healthCheck").HandlerFunc(schttp.getHealthCheck)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/logShipping").HandlerFunc(schttp.setLogShipping)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/logShipping").HandlerFunc(schttp.getLogShipping)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/stopSequence").HandlerFunc(schttp.setStopSequence)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/stopSequence").HandlerFunc(schttp.getStopSequence)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/processSpec").HandlerFunc(schttp.setProcessSpec)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/processSpec").HandlerFunc(schttp.getProcessSpec)
+	router.Methods("POST", "PUT").Path("/api/v1/clusters/{cluster}/requireMountDisk").HandlerFunc(schttp.setRequireMountDisk)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/requireMountDisk").HandlerFunc(schttp.getRequireMountDisk)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/volumes").HandlerFunc(schttp.serveVolumes)
+	router.Methods("DELETE").Path("/api/v1/clusters/{cluster}/volumes/{volume}").HandlerFunc(schttp.purgeVolume)
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/volumes/{volume}/reattach").HandlerFunc(schttp.reattachVolume)
+
+	router.Methods("POST").Path("/api/v1/clusters/{cluster}/webhooks").HandlerFunc(schttp.createWebhook)
+	router.Methods("GET").Path("/api/v1/clusters/{cluster}/webhooks").HandlerFunc(schttp.serveWebhooks)
+	router.Methods("DELETE").Path("/api/v1/clusters/{cluster}/webhooks/{webhook}").HandlerFunc(schttp.removeWebhook)
 
 	router.Methods("POST").Path("/api/v1/clusters/{cluster}/nodes").HandlerFunc(schttp.createNode)
+	router.Methods("GET").Path("/api/v1/reservations").HandlerFunc(schttp.serveReservations)
+	router.Methods("GET").Path("/api/v1/reconciliation").HandlerFunc(schttp.serveReconciliation)
+	router.Methods("GET").Path("/api/v1/events").HandlerFunc(schttp.serveEvents)
+	router.Methods("GET").Path("/api/v1/audit").HandlerFunc(schttp.serveAudit)
 	router.Methods("GET").Path("/healthcheck").HandlerFunc(schttp.healthcheck)
 
 	// TODO: Add a function handler for /
 	//http.Serve(ln, newHandler())
 
+	audited := sc.auditLog.Middleware(router)
 	middleWare := http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
 		log.Infof("%v %s %s %s ? %s %s %s", request.Host, request.RemoteAddr, request.Method, request.URL.Path, request.URL.RawQuery, request.Proto, request.Header.Get("User-Agent"))
-		router.ServeHTTP(w, request)
+		audited.ServeHTTP(w, request)
 	})
 
 	log.Println("Listener Info: ", ln.Addr().String())
@@ -18,12 +18,17 @@ type SchedulerState struct {
 	FrameworkID *string
 	Clusters    map[string]*FrameworkRiakCluster
 	Graveyard   map[string]*FrameworkRiakCluster
+	// Webhooks of every cluster by ID, and the deliveries which haven't been made yet
+	Webhooks     map[string]*Webhook
+	WebhookQueue []*WebhookDelivery
 }
 
 func emptySchedulerState() *SchedulerState {
 	return &SchedulerState{
-		Clusters:  make(map[string]*FrameworkRiakCluster),
-		Graveyard: make(map[string]*FrameworkRiakCluster),
+		Clusters:     make(map[string]*FrameworkRiakCluster),
+		Graveyard:    make(map[string]*FrameworkRiakCluster),
+		Webhooks:     make(map[string]*Webhook),
+		WebhookQueue: []*WebhookDelivery{},
 	}
 }
 func GetSchedulerState(mm *metadata_manager.MetadataManager) *SchedulerState {
@@ -44,6 +49,8 @@ func GetSchedulerState(mm *metadata_manager.MetadataManager) *SchedulerState {
 			log.Panic(err)
 		}
 		ss.zkNode = zkNode
+		ss.markUnreconciled()
+		ss.addConfigRevisions()
 		return ss
 	}
 }
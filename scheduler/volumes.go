@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/basho-labs/riak-mesos/scheduler/process_state"
+	"time"
+)
+
+// What happens to the persistent volume of a node once it's removed from its cluster
+const (
+	VOLUME_RETENTION_DESTROY      string = "destroy"      // Destroyed as soon as it's offered back
+	VOLUME_RETENTION_KEEP         string = "keep"         // Kept for Hours after the node was removed
+	VOLUME_RETENTION_UNTIL_PURGED string = "until_purged" // Kept until purged through the API
+)
+
+// VolumeRetention is set per cluster. Clusters without one keep volumes for the framework's volume grace period
+type VolumeRetention struct {
+	Policy string
+	Hours  float64 `json:",omitempty"`
+}
+
+func (retention *VolumeRetention) Validate() error {
+	switch retention.Policy {
+	case VOLUME_RETENTION_DESTROY, VOLUME_RETENTION_UNTIL_PURGED:
+		return nil
+	case VOLUME_RETENTION_KEEP:
+		if retention.Hours <= 0 {
+			return fmt.Errorf("Volume retention policy %s needs a positive number of Hours", retention.Policy)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown volume retention policy: %s", retention.Policy)
+}
+
+// RetainedVolume is the volume of a removed node which hasn't been destroyed yet
+type RetainedVolume struct {
+	PersistenceID string
+	NodeID        string
+	SlaveID       string
+	Hostname      string
+	Disk          float64
+	RemovedAt     time.Time
+	DestroyAfter  *time.Time `json:",omitempty"`
+	UntilPurged   bool
+}
+
+// VolumeReclaimTime is when the volume of a removed node may be destroyed, falling back to defaultTime when the
+// cluster has no retention policy. Returns true instead if it's kept until purged.
+func (frc *FrameworkRiakCluster) VolumeReclaimTime(riakNode *FrameworkRiakNode, defaultTime time.Time) (time.Time, bool) {
+	if riakNode.VolumePurged {
+		return time.Time{}, false
+	}
+	if frc.VolumeRetention == nil {
+		return defaultTime, false
+	}
+	switch frc.VolumeRetention.Policy {
+	case VOLUME_RETENTION_DESTROY:
+		return time.Time{}, false
+	case VOLUME_RETENTION_KEEP:
+		if riakNode.RemovedAt.IsZero() {
+			return defaultTime, false
+		}
+		return riakNode.RemovedAt.Add(time.Duration(frc.VolumeRetention.Hours * float64(time.Hour))), false
+	case VOLUME_RETENTION_UNTIL_PURGED:
+		return time.Time{}, true
+	}
+	return defaultTime, false
+}
+
+// RetainedVolumes lists the volumes of removed nodes which haven't been purged or reattached to a new node
+func (frc *FrameworkRiakCluster) RetainedVolumes(gracePeriod time.Duration) []*RetainedVolume {
+	volumes := []*RetainedVolume{}
+	for _, riakNode := range frc.Graveyard {
+		if !frc.hasRetainedVolume(riakNode) {
+			continue
+		}
+		volume := &RetainedVolume{
+			PersistenceID: riakNode.PersistenceID(),
+			NodeID:        riakNode.CurrentID(),
+			SlaveID:       riakNode.SlaveID.GetValue(),
+			Hostname:      riakNode.Hostname,
+			Disk:          riakNode.volumeSize(),
+			RemovedAt:     riakNode.RemovedAt,
+		}
+		destroyAfter, untilPurged := frc.VolumeReclaimTime(riakNode, riakNode.RemovedAt.Add(gracePeriod))
+		volume.UntilPurged = untilPurged
+		if !untilPurged {
+			volume.DestroyAfter = &destroyAfter
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes
+}
+
+func (frc *FrameworkRiakCluster) hasRetainedVolume(riakNode *FrameworkRiakNode) bool {
+	if riakNode.VolumePurged || riakNode.SlaveID == nil || !riakNode.HasRequestedReservation() {
+		return false
+	}
+	for _, liveNode := range frc.Nodes {
+		if liveNode.PersistenceID() == riakNode.PersistenceID() {
+			return false
+		}
+	}
+	return true
+}
+
+func (frc *FrameworkRiakCluster) getRetainedVolumeNode(persistenceID string) (*FrameworkRiakNode, error) {
+	for _, riakNode := range frc.Graveyard {
+		if riakNode.PersistenceID() == persistenceID && frc.hasRetainedVolume(riakNode) {
+			return riakNode, nil
+		}
+	}
+	return nil, fmt.Errorf("Volume %s is not retained by cluster %s", persistenceID, frc.Name)
+}
+
+// PurgeVolume lets the volume of a removed node be destroyed the next time it's offered
+func (frc *FrameworkRiakCluster) PurgeVolume(persistenceID string) error {
+	riakNode, err := frc.getRetainedVolumeNode(persistenceID)
+	if err != nil {
+		return err
+	}
+	log.Infof("Purging volume %s of removed node %s", persistenceID, riakNode.CurrentID())
+	riakNode.VolumePurged = true
+	return nil
+}
+
+// ReattachVolume creates a new node which takes over the reservation and volume of a removed node, so it's
+// launched on the same agent with the same data
+func (frc *FrameworkRiakCluster) ReattachVolume(sc *SchedulerCore, persistenceID string) (*FrameworkRiakNode, error) {
+	if sc.compatibilityMode {
+		return nil, fmt.Errorf("Volumes can't be reattached without reservations")
+	}
+	deadNode, err := frc.getRetainedVolumeNode(persistenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	riakNode := frc.CreateNode(sc)
+	riakNode.UUID = deadNode.UUID
+	riakNode.ContainerPath = deadNode.ContainerPath
+	riakNode.Cpus = deadNode.Cpus
+	riakNode.Mem = deadNode.Mem
+	riakNode.Disk = deadNode.Disk
+	riakNode.DiskSource = deadNode.DiskSource
+	riakNode.ReservedDisk = deadNode.ReservedDisk
+	riakNode.SlaveID = deadNode.SlaveID
+	riakNode.Hostname = deadNode.Hostname
+	riakNode.AssignedPorts = deadNode.AssignedPorts
+	riakNode.CurrentState = process_state.Reserved
+	log.Infof("Reattaching volume %s of removed node %s to node %s", persistenceID, deadNode.CurrentID(), riakNode.CurrentID())
+	return riakNode, nil
+}
@@ -0,0 +1,342 @@
+package scheduler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Webhook events, derived from scheduler events
+const (
+	WEBHOOK_NODE_FAILED      string = "node_failed"      // The node's task failed or errored
+	WEBHOOK_NODE_LOST        string = "node_lost"        // The node's task was lost
+	WEBHOOK_NODE_RESTARTING  string = "node_restarting"  // A rolling restart is restarting the node
+	WEBHOOK_NODE_JOINED      string = "node_joined"      // The node joined the ring
+	WEBHOOK_NODE_LEFT        string = "node_left"        // The node left the ring
+	WEBHOOK_RESTART_FINISHED string = "restart_finished" // A rolling restart of the cluster finished
+	WEBHOOK_RESTART_HALTED   string = "restart_halted"   // A rolling restart was stopped by a node failing
+)
+
+var WEBHOOK_EVENTS = []string{
+	WEBHOOK_NODE_FAILED,
+	WEBHOOK_NODE_LOST,
+	WEBHOOK_NODE_RESTARTING,
+	WEBHOOK_NODE_JOINED,
+	WEBHOOK_NODE_LEFT,
+	WEBHOOK_RESTART_FINISHED,
+	WEBHOOK_RESTART_HALTED,
+}
+
+const (
+	WEBHOOK_MAX_ATTEMPTS   = 10
+	WEBHOOK_BASE_BACKOFF   = 5 * time.Second
+	WEBHOOK_MAX_BACKOFF    = 10 * time.Minute
+	WEBHOOK_TIMEOUT        = 10 * time.Second
+	WEBHOOK_SEND_INTERVAL  = time.Second
+	WEBHOOK_SIGNATURE      = "X-Riak-Mesos-Signature"
+	WEBHOOK_EVENT_HEADER   = "X-Riak-Mesos-Event"
+	WEBHOOK_DELIVERY_ID    = "X-Riak-Mesos-Delivery"
+	WEBHOOK_SIGNATURE_ALGO = "sha256"
+)
+
+// Webhook is registered per cluster. The body of every delivery is signed with Secret, as
+// "sha256=<hex HMAC-SHA256 of the body>" in the X-Riak-Mesos-Signature header.
+type Webhook struct {
+	ID          string
+	ClusterName string
+	URL         string
+	Secret      string
+	// Events to deliver, every event when empty
+	Events []string `json:",omitempty"`
+}
+
+// WebhookDelivery is a payload waiting to be delivered. Deliveries are queued in the scheduler state, so they
+// survive a failover.
+type WebhookDelivery struct {
+	ID          string
+	WebhookID   string
+	Event       string
+	Payload     string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string `json:",omitempty"`
+}
+
+type WebhookPayload struct {
+	DeliveryID  string
+	Event       string
+	Time        time.Time
+	ClusterName string
+	NodeID      string                 `json:",omitempty"`
+	Data        map[string]interface{} `json:",omitempty"`
+}
+
+// Validate checks the webhook before it's registered
+func (webhook *Webhook) Validate() error {
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("Webhook URL must be an absolute http or https URL: %s", webhook.URL)
+	}
+	if webhook.Secret == "" {
+		return fmt.Errorf("Webhook needs a Secret to sign deliveries with")
+	}
+	for _, event := range webhook.Events {
+		if !isWebhookEvent(event) {
+			return fmt.Errorf("Unknown webhook event: %s", event)
+		}
+	}
+	return nil
+}
+
+func (webhook *Webhook) wants(event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, wanted := range webhook.Events {
+		if wanted == event {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted is the webhook as returned by the API, without its secret
+func (webhook *Webhook) redacted() *Webhook {
+	copied := *webhook
+	copied.Secret = ""
+	return &copied
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range WEBHOOK_EVENTS {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEvent maps a scheduler event onto a webhook event, or "" if webhooks aren't told about it
+func webhookEvent(event *Event) string {
+	switch event.Type {
+	case EVENT_NODE_STATE:
+		switch event.Data["State"] {
+		case "TASK_FAILED", "TASK_ERROR":
+			return WEBHOOK_NODE_FAILED
+		case "TASK_LOST":
+			return WEBHOOK_NODE_LOST
+		}
+	case EVENT_NODE_JOINED:
+		return WEBHOOK_NODE_JOINED
+	case EVENT_NODE_LEFT:
+		return WEBHOOK_NODE_LEFT
+	case EVENT_RESTART_PROGRESS:
+		if event.Data["Finished"] == true {
+			return WEBHOOK_RESTART_FINISHED
+		}
+		if event.Data["Halted"] == true {
+			return WEBHOOK_RESTART_HALTED
+		}
+		return WEBHOOK_NODE_RESTARTING
+	}
+	return ""
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return WEBHOOK_SIGNATURE_ALGO + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	backoff := WEBHOOK_BASE_BACKOFF
+	for i := 1; i < attempts && backoff < WEBHOOK_MAX_BACKOFF; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > WEBHOOK_MAX_BACKOFF {
+		return WEBHOOK_MAX_BACKOFF
+	}
+	return backoff
+}
+
+// WebhookDispatcher queues a delivery for every webhook interested in an event, and sends them from its own
+// goroutine. The webhooks and the queue are part of the scheduler state, and guarded by the SchedulerCore's lock.
+type WebhookDispatcher struct {
+	sc     *SchedulerCore
+	client *http.Client
+}
+
+func newWebhookDispatcher(sc *SchedulerCore) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		sc:     sc,
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	}
+}
+
+// enqueue is an EventBroker listener. Events are published while holding the SchedulerCore's lock.
+func (dispatcher *WebhookDispatcher) enqueue(event *Event) {
+	eventName := webhookEvent(event)
+	if eventName == "" {
+		return
+	}
+	ss := dispatcher.sc.schedulerState
+	queued := false
+	for _, webhook := range ss.getWebhooks(event.ClusterName) {
+		if !webhook.wants(eventName) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			ID:          uuid.NewV4().String(),
+			WebhookID:   webhook.ID,
+			Event:       eventName,
+			NextAttempt: event.Time,
+		}
+		payload, err := json.Marshal(&WebhookPayload{
+			DeliveryID:  delivery.ID,
+			Event:       eventName,
+			Time:        event.Time,
+			ClusterName: event.ClusterName,
+			NodeID:      event.NodeID,
+			Data:        event.Data,
+		})
+		if err != nil {
+			log.Error("Unable to encode webhook payload: ", err)
+			continue
+		}
+		delivery.Payload = string(payload)
+		ss.WebhookQueue = append(ss.WebhookQueue, delivery)
+		queued = true
+	}
+	if queued {
+		ss.Persist()
+	}
+}
+
+func (dispatcher *WebhookDispatcher) loop() {
+	for range time.Tick(WEBHOOK_SEND_INTERVAL) {
+		dispatcher.sendDue(time.Now())
+	}
+}
+
+// sendDue sends every delivery which is due. The lock is only held while picking deliveries and recording
+// the results, not while waiting on the receivers.
+func (dispatcher *WebhookDispatcher) sendDue(now time.Time) {
+	type pendingSend struct {
+		delivery *WebhookDelivery
+		webhook  Webhook
+	}
+	sends := []*pendingSend{}
+
+	dispatcher.sc.lock.Lock()
+	ss := dispatcher.sc.schedulerState
+	for _, delivery := range ss.WebhookQueue {
+		webhook, assigned := ss.Webhooks[delivery.WebhookID]
+		if assigned && !now.Before(delivery.NextAttempt) {
+			sends = append(sends, &pendingSend{delivery: delivery, webhook: *webhook})
+		}
+	}
+	dispatcher.sc.lock.Unlock()
+
+	results := make(map[string]error)
+	for _, send := range sends {
+		results[send.delivery.ID] = dispatcher.send(&send.webhook, send.delivery)
+	}
+
+	dispatcher.sc.lock.Lock()
+	defer dispatcher.sc.lock.Unlock()
+	remaining := []*WebhookDelivery{}
+	stateDirty := false
+	for _, delivery := range ss.WebhookQueue {
+		if _, assigned := ss.Webhooks[delivery.WebhookID]; !assigned {
+			// The webhook was removed
+			stateDirty = true
+			continue
+		}
+		err, attempted := results[delivery.ID]
+		if !attempted {
+			remaining = append(remaining, delivery)
+			continue
+		}
+		stateDirty = true
+		if err == nil {
+			continue
+		}
+		delivery.Attempts = delivery.Attempts + 1
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= WEBHOOK_MAX_ATTEMPTS {
+			log.Errorf("Giving up on webhook delivery %s (%s) to %s after %d attempts: %v", delivery.ID, delivery.Event, delivery.WebhookID, delivery.Attempts, err)
+			continue
+		}
+		log.Warnf("Webhook delivery %s (%s) to %s failed, retrying: %v", delivery.ID, delivery.Event, delivery.WebhookID, err)
+		delivery.NextAttempt = now.Add(webhookBackoff(delivery.Attempts))
+		remaining = append(remaining, delivery)
+	}
+	ss.WebhookQueue = remaining
+	if stateDirty {
+		ss.Persist()
+	}
+}
+
+func (dispatcher *WebhookDispatcher) send(webhook *Webhook, delivery *WebhookDelivery) error {
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WEBHOOK_SIGNATURE, signWebhookPayload(webhook.Secret, payload))
+	request.Header.Set(WEBHOOK_EVENT_HEADER, delivery.Event)
+	request.Header.Set(WEBHOOK_DELIVERY_ID, delivery.ID)
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// getWebhooks returns the webhooks of a cluster, ordered by ID
+func (ss *SchedulerState) getWebhooks(clusterName string) []*Webhook {
+	webhookIDs := []string{}
+	for webhookID, webhook := range ss.Webhooks {
+		if webhook.ClusterName == clusterName {
+			webhookIDs = append(webhookIDs, webhookID)
+		}
+	}
+	sort.Strings(webhookIDs)
+	webhooks := []*Webhook{}
+	for _, webhookID := range webhookIDs {
+		webhooks = append(webhooks, ss.Webhooks[webhookID])
+	}
+	return webhooks
+}
+
+func (ss *SchedulerState) addWebhook(webhook *Webhook) {
+	if ss.Webhooks == nil {
+		ss.Webhooks = make(map[string]*Webhook)
+	}
+	webhook.ID = uuid.NewV4().String()
+	ss.Webhooks[webhook.ID] = webhook
+}
+
+func (ss *SchedulerState) removeWebhook(clusterName string, webhookID string) error {
+	webhook, assigned := ss.Webhooks[webhookID]
+	if !assigned || webhook.ClusterName != clusterName {
+		return fmt.Errorf("Webhook %s not found for cluster %s", webhookID, clusterName)
+	}
+	delete(ss.Webhooks, webhookID)
+	return nil
+}
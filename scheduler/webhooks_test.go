@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"encoding/json"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the deliveries it's sent, and fails the first failures of them
+type webhookReceiver struct {
+	lock       *sync.Mutex
+	failures   int
+	signatures []string
+	payloads   []*WebhookPayload
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	if receiver.failures > 0 {
+		receiver.failures = receiver.failures - 1
+		w.WriteHeader(500)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	payload := &WebhookPayload{}
+	json.Unmarshal(body, payload)
+	receiver.payloads = append(receiver.payloads, payload)
+	receiver.signatures = append(receiver.signatures, r.Header.Get(WEBHOOK_SIGNATURE))
+	w.WriteHeader(200)
+}
+
+func TestWebhookDeliveredSigned(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	receiver := &webhookReceiver{lock: &sync.Mutex{}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	webhook := &Webhook{ClusterName: cluster.Name, URL: server.URL, Secret: "s3cret", Events: []string{WEBHOOK_NODE_LOST}}
+	assert.Nil(webhook.Validate())
+	sc.schedulerState.addWebhook(webhook)
+	node := runningTestNode(t, sc, driver, cluster)
+	assert.Equal(0, len(sc.schedulerState.WebhookQueue))
+
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_LOST))
+	assert.Equal(1, len(sc.schedulerState.WebhookQueue))
+	delivery := sc.schedulerState.WebhookQueue[0]
+
+	sc.webhooks.sendDue(time.Now())
+	assert.Equal(0, len(sc.schedulerState.WebhookQueue))
+	assert.Equal(1, len(receiver.payloads))
+	assert.Equal(WEBHOOK_NODE_LOST, receiver.payloads[0].Event)
+	assert.Equal(node.CurrentID(), receiver.payloads[0].NodeID)
+	assert.Equal(signWebhookPayload("s3cret", []byte(delivery.Payload)), receiver.signatures[0])
+}
+
+func TestWebhookRetriedWithBackoff(t *testing.T) {
+	assert := assert.New(t)
+	sc, driver, _ := newTestSchedulerCore(true)
+	receiver := &webhookReceiver{lock: &sync.Mutex{}, failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cluster := NewFrameworkRiakCluster("default")
+	sc.schedulerState.Clusters[cluster.Name] = cluster
+	sc.schedulerState.addWebhook(&Webhook{ClusterName: cluster.Name, URL: server.URL, Secret: "s3cret"})
+	node := runningTestNode(t, sc, driver, cluster)
+	sc.StatusUpdate(driver, newTestStatus(node, mesos.TaskState_TASK_FAILED))
+
+	now := time.Now()
+	sc.webhooks.sendDue(now)
+	assert.Equal(1, len(sc.schedulerState.WebhookQueue))
+	assert.Equal(1, sc.schedulerState.WebhookQueue[0].Attempts)
+	assert.Equal(now.Add(WEBHOOK_BASE_BACKOFF), sc.schedulerState.WebhookQueue[0].NextAttempt)
+
+	// The queue is part of the persisted state
+	assert.Equal(1, len(GetSchedulerState(sc.mgr).WebhookQueue))
+
+	sc.webhooks.sendDue(now.Add(time.Second))
+	assert.Equal(0, len(receiver.payloads))
+	sc.webhooks.sendDue(now.Add(WEBHOOK_BASE_BACKOFF))
+	assert.Equal(1, len(receiver.payloads))
+	assert.Equal(WEBHOOK_NODE_FAILED, receiver.payloads[0].Event)
+	assert.Equal(0, len(sc.schedulerState.WebhookQueue))
+}
+
+func TestWebhookValidation(t *testing.T) {
+	assert := assert.New(t)
+	assert.NotNil((&Webhook{URL: "ftp://example.com", Secret: "s3cret"}).Validate())
+	assert.NotNil((&Webhook{URL: "http://example.com"}).Validate())
+	assert.NotNil((&Webhook{URL: "http://example.com", Secret: "s3cret", Events: []string{"node_exploded"}}).Validate())
+	assert.Nil((&Webhook{URL: "https://example.com/hook", Secret: "s3cret", Events: []string{WEBHOOK_RESTART_FINISHED}}).Validate())
+}